@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// zip64Song is the self-test fixture, for archives that only need a score
+func zip64Song(t *testing.T) *GpxFileSystem {
+	t.Helper()
+	fs := &GpxFileSystem{Limits: limits}
+	if err := fs.Load(selftestGpx); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// TestArchiveZip64Entries writes more entries than the classic end of
+// central directory record can count
+func TestArchiveZip64Entries(t *testing.T) {
+	fs := zip64Song(t)
+	const extra = 70000
+	for i := 0; i < extra; i++ {
+		fs.assets = append(fs.assets, generatedFile{fmt.Sprintf("Content/Assets/%05d.txt", i), []byte{byte(i)}})
+	}
+	var out bytes.Buffer
+	if err := writeGpArchive(&out, fs); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) <= extra {
+		t.Fatalf("read back %d entries, expected more than %d", len(zr.File), extra)
+	}
+	last, err := readZipEntry(zr, fmt.Sprintf("Content/Assets/%05d.txt", extra-1))
+	if err != nil || !bytes.Equal(last, []byte{byte((extra - 1) % 256)}) {
+		t.Errorf("last asset reads back as %v, %v", last, err)
+	}
+}
+
+// TestArchiveZip64Size writes an entry over 4 GiB, from a sparse spill
+// file of zeros that deflate to a few MiB
+func TestArchiveZip64Size(t *testing.T) {
+	if testing.Short() {
+		t.Skip("deflates 4 GiB")
+	}
+	const size = 1<<32 + 1<<20
+	spill := filepath.Join(t.TempDir(), "BinaryStylesheet")
+	f, err := os.Create(spill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		t.Skip("no sparse files here:", err)
+	}
+	f.Close()
+
+	fs := zip64Song(t)
+	fs.Files = append(fs.Files, GpxFile{FileName: "BinaryStylesheet", FileSize: size, spill: spill, spillSize: size})
+	var out bytes.Buffer
+	if err := writeGpArchive(&out, fs); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, zf := range zr.File {
+		if zf.Name != "Content/BinaryStylesheet" {
+			continue
+		}
+		if zf.UncompressedSize64 != size {
+			t.Fatalf("entry size reads back as %d, %d written", zf.UncompressedSize64, size)
+		}
+		r, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		n, err := io.Copy(io.Discard, r)
+		if err != nil || n != size {
+			t.Fatalf("read %d bytes of %d: %v", n, size, err)
+		}
+		return
+	}
+	t.Fatal("Content/BinaryStylesheet is missing")
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Backing tracks. Guitar Pro 7 and later play an audio file along with
+// the score: the file is stored under Content/Assets/ and score.gpif
+// refers to it from a BackingTrack element through an Assets list. Guitar
+// Pro 6 had no such track, so the audio comes from -audio, or from audio
+// files someone packed into the container.
+
+// audioExts are the formats Guitar Pro accepts for a backing track
+var audioExts = map[string]bool{".mp3": true, ".ogg": true, ".wav": true, ".flac": true, ".m4a": true}
+
+// audioAsset is an audio file to carry into the archive
+type audioAsset struct {
+	original string // file name it came from, shown by Guitar Pro
+	data     []byte
+}
+
+// backingAudio holds the file of -audio, audioSum its hash, which is part
+// of the cache key
+var (
+	backingAudio *audioAsset
+	audioSum     string
+)
+
+// loadAudio reads the file of -audio
+func loadAudio(path string) (*audioAsset, string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !audioExts[ext] {
+		return nil, "", fmt.Errorf("%s: not a backing track format Guitar Pro plays, use %s", path, strings.Join(sortedAudioExts(), ", "))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) == 0 {
+		return nil, "", fmt.Errorf("%s: file is empty", path)
+	}
+	sum := sha256.Sum256(data)
+	return &audioAsset{original: filepath.Base(path), data: data}, hex.EncodeToString(sum[:]), nil
+}
+
+func sortedAudioExts() []string {
+	exts := make(map[string]string, len(audioExts))
+	for ext := range audioExts {
+		exts[ext] = ext
+	}
+	return sortedKeys(exts)
+}
+
+// assetEntryName names an asset after the SHA-1 of its data, formatted
+// like the UUIDs Guitar Pro uses, so the same audio always gets the same
+// entry
+func (a *audioAsset) assetEntryName() (string, string) {
+	sum := sha1.Sum(a.data)
+	h := hex.EncodeToString(sum[:])
+	id := h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+	return "Content/Assets/" + id + strings.ToLower(path.Ext(a.original)), h
+}
+
+// containerAudio lists the audio files packed into the container
+func (fs *GpxFileSystem) containerAudio() []*GpxFile {
+	var found []*GpxFile
+	for i := range fs.Files {
+		if audioExts[strings.ToLower(path.Ext(fs.Files[i].FileName))] {
+			found = append(found, &fs.Files[i])
+		}
+	}
+	return found
+}
+
+// backingTrackXML is the GPIF of a backing track playing asset 0 from the
+// start of the score
+const backingTrackXML = `<BackingTrack>` +
+	`<IconId>21</IconId>` +
+	`<Color>0 0 0</Color>` +
+	`<Name>Audio Track</Name>` +
+	`<ShortName>a.track</ShortName>` +
+	`<PlaybackState>Default</PlaybackState>` +
+	`<Enabled>true</Enabled>` +
+	`<Source>Local</Source>` +
+	`<AssetId>0</AssetId>` +
+	`<FramePadding>0</FramePadding>` +
+	`<Semitones>0</Semitones>` +
+	`<Cents>0</Cents>` +
+	`</BackingTrack>`
+
+// xmlTokens decodes a fragment into the tokens rewriteXML emits
+func xmlTokens(fragment string) []xml.Token {
+	decoder := xml.NewDecoder(strings.NewReader(fragment))
+	var toks []xml.Token
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return toks
+		}
+		toks = append(toks, xml.CopyToken(tok))
+	}
+}
+
+// addBackingTrack refers score.gpif to an asset stored as entry
+func addBackingTrack(gpif []byte, a *audioAsset, entry, sha1sum string) ([]byte, error) {
+	var fragment strings.Builder
+	fragment.WriteString(backingTrackXML)
+	fragment.WriteString(`<Assets><Asset id="0"><OriginalFilePath>`)
+	xml.EscapeText(&fragment, []byte(a.original))
+	fragment.WriteString(`</OriginalFilePath><OriginalFileSha1>` + sha1sum + `</OriginalFileSha1><EmbeddedFilePath>`)
+	xml.EscapeText(&fragment, []byte(entry))
+	fragment.WriteString(`</EmbeddedFilePath></Asset></Assets>`)
+	added := xmlTokens(fragment.String())
+
+	existing := false
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 1 && (t.Name.Local == "BackingTrack" || t.Name.Local == "Assets") {
+				existing = true
+			}
+		case xml.EndElement:
+			if len(stack) == 1 && t.Name.Local == "GPIF" {
+				return append(added, tok)
+			}
+		}
+		return []xml.Token{tok}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if existing {
+		return nil, fmt.Errorf("the score already has a backing track")
+	}
+	return out, nil
+}
+
+// attachAudio makes a the backing track of the converted score
+func (fs *GpxFileSystem) attachAudio(a *audioAsset) error {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return fs.missingScore()
+	}
+	entry, sum := a.assetEntryName()
+	data, err := addBackingTrack(file.Data, a, entry, sum)
+	if err != nil {
+		return fmt.Errorf("adding backing track %s: %v", a.original, err)
+	}
+	file.Data, file.FileSize = data, len(data)
+	fs.assets = append(fs.assets, generatedFile{entry, a.data})
+	return nil
+}
+
+// carryAudio attaches the -audio file, or else the audio packed into the
+// container. Guitar Pro plays a single backing track, further audio files
+// are left out with a warning.
+func (fs *GpxFileSystem) carryAudio(audio *audioAsset) (string, error) {
+	found := fs.containerAudio()
+	if audio == nil && len(found) > 0 {
+		f := found[0]
+		data, err := f.Content()
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %v", f.FileName, err)
+		}
+		audio = &audioAsset{original: path.Base(f.FileName), data: data}
+		found = found[1:]
+	}
+	for _, f := range found {
+		if err := fs.warn("%s is audio, but the score can only have one backing track: left out", f.FileName); err != nil {
+			return "", err
+		}
+	}
+	if audio == nil {
+		return "", nil
+	}
+	return audio.original, fs.attachAudio(audio)
+}
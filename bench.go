@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// gpx2gp bench times the conversion stages over a corpus of real files.
+// Micro benchmarks of the bit reader and the BCFZ decoder are in
+// bench_test.go.
+
+// benchStage accumulates the work and time of one conversion stage
+type benchStage struct {
+	name    string
+	bytes   int64
+	elapsed time.Duration
+}
+
+func (st *benchStage) run(n int, f func() error) error {
+	start := time.Now()
+	err := f()
+	st.elapsed += time.Since(start)
+	st.bytes += int64(n)
+	return err
+}
+
+func (st *benchStage) String() string {
+	rate := 0.0
+	if st.elapsed > 0 {
+		rate = float64(st.bytes) / st.elapsed.Seconds() / 1e6
+	}
+	return fmt.Sprintf("%-16s %10.1f MB/s %12v", st.name, rate, st.elapsed.Round(time.Microsecond))
+}
+
+// benchConvert converts one container in memory, timing its stages
+func benchConvert(raw []byte, decode, parse, write *benchStage) error {
+	fs := &GpxFileSystem{Limits: limits, Mode: parseMode}
+	src := NewBitReader(raw)
+	header, err := src.ReadBytes(4)
+	if err != nil {
+		return err
+	}
+	fs.Format = string(header)
+	var container []byte
+	switch fs.Format {
+	case "BCFZ":
+		err = decode.run(0, func() error {
+			container, err = fs.decompress(src)
+			return err
+		})
+		decode.bytes += int64(len(container))
+	case "BCFS":
+		container = src.ReadAll()
+	default:
+		return fmt.Errorf("unsupported format header: %q", header)
+	}
+	if err != nil {
+		return err
+	}
+	if err := parse.run(len(container), func() error { return fs.readUncompressedBlock(container) }); err != nil {
+		return err
+	}
+	size := 0
+	for _, f := range fs.Files {
+		size += f.Len()
+	}
+	return write.run(size, func() error { return writeGpArchive(io.Discard, fs) })
+}
+
+func runBench(args []string) {
+	cmd := flag.NewFlagSet("bench", flag.ExitOnError)
+	rounds := cmd.Int("rounds", 3, "Conversions of each file")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if cmd.NArg() == 0 || *rounds < 1 {
+		fmt.Println("Usage: gpx2gp bench [-rounds 3] <input.gpx|dir>...")
+		os.Exit(1)
+	}
+	inputs, err := collectInputs(cmd.Args())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The corpus is read up front, the stages are timed in memory
+	var corpus [][]byte
+	var total int64
+	for _, path := range inputs {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		corpus = append(corpus, raw)
+		total += int64(len(raw))
+	}
+
+	decode := &benchStage{name: "decompression"}
+	parse := &benchStage{name: "sector parsing"}
+	write := &benchStage{name: "zip writing"}
+	skipped := make(map[int]bool)
+	start := time.Now()
+	for r := 0; r < *rounds; r++ {
+		for i, raw := range corpus {
+			if skipped[i] {
+				continue
+			}
+			if err := benchConvert(raw, decode, parse, write); err != nil {
+				fmt.Printf("Skipping %s: %v\n", inputs[i], err)
+				skipped[i] = true
+			}
+		}
+	}
+	elapsed := time.Since(start)
+
+	fmt.Printf("Corpus: %d files, %.1f MiB, %d rounds, %d skipped\n", len(corpus), float64(total)/(1<<20), *rounds, len(skipped))
+	for _, st := range []*benchStage{decode, parse, write} {
+		fmt.Println(st)
+	}
+	overall := &benchStage{name: "total", bytes: total * int64(*rounds), elapsed: elapsed}
+	fmt.Println(overall)
+}
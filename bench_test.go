@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// Micro benchmarks of the bit reader and the BCFZ decoder. Compare runs
+// before and after touching the hot paths with
+//
+//	go test -run - -bench . -count 10 > new.txt
+//	benchstat old.txt new.txt
+
+// benchData is 1 MiB of reproducible random bytes
+func benchData() []byte {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// benchScore is a compressible payload shaped like a score, about 4 MiB
+func benchScore() []byte {
+	var sb bytes.Buffer
+	sb.WriteString("BCFS")
+	rng := rand.New(rand.NewSource(1))
+	for sb.Len() < 4<<20 {
+		fmt.Fprintf(&sb, "<Note id=\"%d\"><Properties><Property name=\"Fret\"><Fret>%d</Fret></Property></Properties></Note>\n", rng.Intn(100000), rng.Intn(24))
+	}
+	return sb.Bytes()
+}
+
+// benchBitReader reads benchData with read until it fails
+func benchBitReader(b *testing.B, read func(br *BitReader) error) {
+	data := benchData()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br := NewBitReader(data)
+		for read(br) == nil {
+		}
+	}
+}
+
+func BenchmarkBitReaderReadBit(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error { _, err := br.ReadBit(); return err })
+}
+
+func BenchmarkBitReaderReadBits4(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error { _, err := br.ReadBits(4); return err })
+}
+
+func BenchmarkBitReaderReadBitsReversed15(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error { _, err := br.ReadBitsReversed(15); return err })
+}
+
+func BenchmarkBitReaderReadByte(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error { _, err := br.ReadByte(); return err })
+}
+
+func BenchmarkBitReaderReadBytesAligned(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error { _, err := br.ReadBytes(3); return err })
+}
+
+func BenchmarkBitReaderReadBytesMisaligned(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error {
+		if _, err := br.ReadBits(3); err != nil {
+			return err
+		}
+		_, err := br.ReadBytes(3)
+		return err
+	})
+}
+
+func BenchmarkBitReaderReadBytesLargeAligned(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error { _, err := br.ReadBytes(4096); return err })
+}
+
+func BenchmarkBitReaderReadBytesLargeMisaligned(b *testing.B) {
+	benchBitReader(b, func(br *BitReader) error {
+		if _, err := br.ReadBits(3); err != nil {
+			return err
+		}
+		_, err := br.ReadBytes(4096)
+		return err
+	})
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		payload []byte
+	}{
+		{"Score", benchScore()},
+		{"Random", append([]byte("BCFS"), benchData()...)},
+	} {
+		stream := compressBcfz(bench.payload)[4:]
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bench.payload)))
+			for i := 0; i < b.N; i++ {
+				fs := &GpxFileSystem{Mode: ParseLenient}
+				if _, err := fs.decompress(NewBitReader(stream)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	// Streamed to a sink, the decoding buffer is all that is allocated
+	payload := benchScore()
+	stream := compressBcfz(payload)[4:]
+	b.Run("ScoreStream", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			fs := &GpxFileSystem{Mode: ParseLenient}
+			src := NewBitReader(stream)
+			n, err := fs.declaredLength(src)
+			if err == nil {
+				err = fs.decompressTo(src, n, io.Discard)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Conversion cache: outputs are stored under a key made of the input's
+// SHA-256 and everything that shapes the output, so batch runs over an
+// unchanged library copy or link earlier results instead of converting.
+
+// cacheDir enables the cache, cacheLink hard-links outputs to it
+var (
+	cacheDir  string
+	cacheLink bool
+)
+
+// cacheEntry is the record kept next to a cached archive, enough to
+// report the conversion as it went the first time
+type cacheEntry struct {
+	Name     string   `json:"name"` // from the score's metadata, for outputs named after it
+	Files    int      `json:"files"`
+	Partial  bool     `json:"partial"`
+	Warnings []string `json:"warnings"`
+}
+
+// hashFile returns the hex SHA-256 of a file
+func hashFile(path string) (string, error) {
+	if isRemotePath(path) {
+		d, err := readRemote(path)
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(d.data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheKey identifies a conversion: the input, the converter version and
+// every option that changes the archive or the warnings
+func cacheKey(inputSum string) string {
+	h := sha256.New()
+	o := archiveOptions
+	fmt.Fprintf(h, "gpx2gp %s\ninput %s\n", toolVersion(), inputSum)
+	fmt.Fprintf(h, "manifest %v reproducible %v modified %s provenance %v method %d level %d\n",
+		o.Manifest, o.Reproducible, o.Modified.UTC().Format("2006-01-02T15:04:05.999999999Z"), o.Provenance, o.Method, o.Level)
+	if o.EmbedSource {
+		// Only then, keys of earlier cached conversions stay valid
+		fmt.Fprintf(h, "embed source\n")
+	}
+	if translationSum != "" {
+		fmt.Fprintf(h, "translation %s\n", translationSum)
+	}
+	if fileNameChars != "unicode" {
+		// The cached record keeps the derived output name
+		fmt.Fprintf(h, "file names %s\n", fileNameChars)
+	}
+	if m := mixerFlags; len(m.mute)+len(m.solo)+len(m.volume) > 0 {
+		fmt.Fprintf(h, "mixer mute %q solo %q volume %q\n", m.mute, m.solo, m.volume)
+	}
+	if verifyContent {
+		fmt.Fprintln(h, "verify content")
+	}
+	if repairContinuity {
+		fmt.Fprintln(h, "repair continuity")
+	}
+	if repairDirections {
+		fmt.Fprintln(h, "repair directions")
+	}
+	if forcedFeel.feel != "" {
+		fmt.Fprintf(h, "feel %s %d %d\n", forcedFeel.feel, forcedFeel.first, forcedFeel.last)
+	}
+	if len(restringFlags) > 0 {
+		fmt.Fprintf(h, "strings %q\n", restringFlags)
+	}
+	if trackRulesSum != "" {
+		fmt.Fprintf(h, "track rules %s\n", trackRulesSum)
+	}
+	if coverSum != "" {
+		fmt.Fprintf(h, "cover %s\n", coverSum)
+	}
+	if audioSum != "" {
+		fmt.Fprintf(h, "audio %s\n", audioSum)
+	}
+	fmt.Fprintf(h, "target %s mode %d synthesize %v\n", target.Target, parseMode, synthesizeMissing)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachePaths returns the archive and record paths of a key
+func cachePaths(key string) (string, string) {
+	base := filepath.Join(cacheDir, key[:2], key)
+	return base + ".gp", base + ".json"
+}
+
+// lookupCache returns the record of a cached conversion, or nil
+func lookupCache(key string) *cacheEntry {
+	archive, record := cachePaths(key)
+	if _, err := os.Stat(archive); err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(record)
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// restoreCached writes the cached archive of key to outputPath
+func restoreCached(key, outputPath string) error {
+	archive, _ := cachePaths(key)
+	if cacheLink {
+		if err := os.Link(archive, outputPath); err == nil {
+			return nil
+		}
+	}
+	return copyFile(archive, outputPath)
+}
+
+// storeCache records a finished conversion. The archive is written under
+// a temporary name first, so readers never see a partial entry.
+func storeCache(key, outputPath string, entry cacheEntry) error {
+	archive, record := cachePaths(key)
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(record+".tmp", data, 0644); err != nil {
+		return err
+	}
+	os.Remove(archive + ".tmp")
+	if err := copyFile(outputPath, archive+".tmp"); err != nil {
+		os.Remove(record + ".tmp")
+		return err
+	}
+	if err := os.Rename(record+".tmp", record); err != nil {
+		return err
+	}
+	return os.Rename(archive+".tmp", archive)
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The canonical text form of a score, for keeping tabs in version control.
+// It follows the music rather than the file: metadata, tracks, tempo
+// changes, then bar by bar every voice of every track, one beat per line.
+// The ids GPIF uses to link these, which Guitar Pro renumbers on every
+// save, are left out, so an edit shows up in a diff as the lines of the
+// beats it touched.
+
+const canonicalHeader = "gpx2gp canonical score 1"
+
+// pitchOctaveName names a MIDI pitch with its octave, middle C being C4
+func pitchOctaveName(pitch int) string {
+	octave := (pitch-((pitch%12)+12)%12)/12 - 1
+	return pitchName(pitch) + strconv.Itoa(octave)
+}
+
+// canonicalText quotes s when it would otherwise not read back as one value
+func canonicalText(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.ContainsAny(s, "\"\\\n\r\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// rhythmName describes a beat's duration, e.g. "Eighth." or "16th 3:2"
+func (g *Gpif) rhythmName(beat *GpifBeat) string {
+	id, err := strconv.Atoi(beat.Rhythm.Ref)
+	rhythm := g.rhythmByID[id]
+	if err != nil || rhythm == nil {
+		return "?"
+	}
+	name := rhythm.NoteValue
+	if name == "" {
+		name = "Quarter"
+	}
+	name += strings.Repeat(".", max(rhythm.AugmentationDot.Count, 0))
+	for _, t := range []GpifTuplet{rhythm.PrimaryTuplet, rhythm.SecondaryTuplet} {
+		if t.Num > 0 && t.Den > 0 {
+			name += fmt.Sprintf(" %d:%d", t.Num, t.Den)
+		}
+	}
+	return name
+}
+
+// canonicalNote is "string:fret", strings numbered from the highest as in
+// tab, or the pitch for notes without a string, with the techniques
+func canonicalNote(track *GpifTrack, note *GpifNote) string {
+	var s string
+	stringCount := len(track.Tuning())
+	if str, fret, ok := note.StringFret(); ok && !track.IsPercussion() {
+		s = fmt.Sprintf("%d:%d", stringCount-str, fret)
+	} else if pitch, ok := note.Pitch(track); ok {
+		s = pitchOctaveName(pitch)
+	} else {
+		s = "?"
+	}
+	techniques := note.Techniques()
+	if note.Tie.Destination {
+		techniques = append(techniques, "tie_end")
+	}
+	if len(techniques) > 0 {
+		s += "(" + strings.Join(techniques, ",") + ")"
+	}
+	return s
+}
+
+// canonicalBeat is one beat line: duration, notes or "rest", then the
+// beat's markings
+func (g *Gpif) canonicalBeat(track *GpifTrack, beat *GpifBeat) string {
+	parts := []string{g.rhythmName(beat)}
+	if beat.GraceNotes != "" {
+		parts = append(parts, "grace="+beat.GraceNotes)
+	}
+	notes := g.BeatNotes(beat)
+	if len(notes) == 0 {
+		parts = append(parts, "rest")
+	}
+	for _, note := range notes {
+		parts = append(parts, canonicalNote(track, note))
+	}
+	if beat.Dynamic != "" {
+		parts = append(parts, "dynamic="+beat.Dynamic)
+	}
+	if strings.TrimSpace(beat.FreeText) != "" {
+		parts = append(parts, "text="+canonicalText(beat.FreeText))
+	}
+	for i, line := range beat.Lyrics {
+		if strings.TrimSpace(line) != "" {
+			parts = append(parts, fmt.Sprintf("lyric%d=%s", i+1, canonicalText(line)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeCanonical writes the canonical text form of the score
+func (g *Gpif) writeCanonical(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	line := func(format string, a ...interface{}) {
+		fmt.Fprintf(bw, format+"\n", a...)
+	}
+	field := func(indent, name, value string) {
+		if strings.TrimSpace(value) != "" {
+			line("%s%s: %s", indent, name, canonicalText(value))
+		}
+	}
+
+	line("%s", canonicalHeader)
+	line("# notes are string:fret, strings numbered from the highest")
+	line("")
+	s := &g.Score
+	for _, f := range []struct{ name, value string }{
+		{"title", s.Title}, {"subtitle", s.SubTitle}, {"artist", s.Artist}, {"album", s.Album},
+		{"words", s.Words}, {"music", s.Music}, {"copyright", s.Copyright}, {"tabber", s.Tabber},
+	} {
+		field("", f.name, f.value)
+	}
+
+	for i := range g.Tracks {
+		t := &g.Tracks[i]
+		line("")
+		line("track %d: %s", i+1, canonicalText(t.Name))
+		field("  ", "short name", t.ShortName)
+		field("  ", "instrument", t.Instrument.Ref)
+		line("  program: %d", t.GeneralMidi.Program)
+		line("  channel: %d", t.GeneralMidi.PrimaryChannel)
+		if tuning := t.Tuning(); len(tuning) > 0 {
+			names := make([]string, len(tuning))
+			for j, pitch := range tuning {
+				names[j] = pitchOctaveName(pitch)
+			}
+			line("  tuning: %s", strings.Join(names, " "))
+		}
+		if capo := t.Capo(); capo != 0 {
+			line("  capo: %d", capo)
+		}
+		for j, l := range t.Lyrics {
+			if strings.TrimSpace(l.Text) != "" {
+				line("  lyrics %d from bar %d: %s", j+1, l.Offset+1, canonicalText(l.Text))
+			}
+		}
+	}
+
+	automations := append([]GpifAutomation(nil), g.MasterTrack.Automations...)
+	sort.SliceStable(automations, func(i, j int) bool {
+		a, b := automations[i], automations[j]
+		if a.Bar != b.Bar {
+			return a.Bar < b.Bar
+		}
+		return a.Position < b.Position
+	})
+	if len(automations) > 0 {
+		line("")
+	}
+	for _, a := range automations {
+		kind := strings.ToLower(a.Type)
+		if a.Linear {
+			kind += " linear"
+		}
+		line("%s bar %d at %s: %s", kind, a.Bar+1, strconv.FormatFloat(a.Position, 'f', -1, 64), canonicalText(a.Value))
+	}
+
+	for m := range g.MasterBars {
+		mb := &g.MasterBars[m]
+		line("")
+		key := fmt.Sprintf("key %d", mb.Key.AccidentalCount)
+		if mode := strings.TrimSpace(mb.Key.Mode); mode != "" {
+			key += " " + strings.ToLower(mode)
+		}
+		line("bar %d: %s, %s", m+1, strings.TrimSpace(mb.Time), key)
+		if mb.Section != nil && mb.Section.Name() != "" {
+			line("  section: %s", canonicalText(mb.Section.Name()))
+		}
+		if mb.Repeat.Start {
+			line("  repeat start")
+		}
+		if mb.Repeat.End {
+			line("  repeat end x%d", mb.Repeat.Count)
+		}
+		field("  ", "alternate endings", mb.AlternateEndings)
+		for _, target := range mb.Directions.Targets {
+			field("  ", "target", target)
+		}
+		for _, jump := range mb.Directions.Jumps {
+			field("  ", "jump", jump)
+		}
+		field("  ", "triplet feel", mb.TripletFeel)
+
+		for t := range g.Tracks {
+			bar := g.TrackBar(m, t)
+			if bar == nil {
+				continue
+			}
+			if clef := strings.TrimSpace(bar.Clef); clef != "" && (m == 0 || clef != g.previousClef(m, t)) {
+				line("  track %d clef: %s", t+1, clef)
+			}
+			for slot, id := range parseIDs(bar.Voices) {
+				voice := g.voiceByID[id]
+				if id < 0 || voice == nil {
+					continue
+				}
+				line("  track %d voice %d:", t+1, slot+1)
+				for _, beat := range g.VoiceBeats(voice) {
+					line("    %s", g.canonicalBeat(&g.Tracks[t], beat))
+				}
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// previousClef is the clef of track t in the bar before master bar m
+func (g *Gpif) previousClef(m, t int) string {
+	if bar := g.TrackBar(m-1, t); bar != nil {
+		return strings.TrimSpace(bar.Clef)
+	}
+	return ""
+}
+
+func runExportCanonical(args []string) {
+	cmd := flag.NewFlagSet("export canonical", flag.ExitOnError)
+	var inputPath, outputPath string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp export canonical -f <input.gpx> [-o <output.txt>]")
+		os.Exit(1)
+	}
+	if err := checkExportOutput(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+
+	if err := score.writeCanonical(w); err != nil {
+		fmt.Printf("Error writing canonical text: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readZipEntry returns the content of the named archive entry, or nil
+func readZipEntry(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, nil
+}
+
+// checkAlphaTab verifies a .gp archive against the rules alphaTab's GP7
+// importer enforces, returning one message per problem
+func checkAlphaTab(zr *zip.Reader) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for _, f := range zr.File {
+		if f.Method != zip.Store && f.Method != zip.Deflate {
+			problems = append(problems, fmt.Sprintf("%s: compression method %d is not supported (only store and deflate)", f.Name, f.Method))
+		}
+		if strings.Contains(f.Name, "\\") || strings.HasPrefix(f.Name, "/") {
+			problems = append(problems, fmt.Sprintf("%s: entry names must be relative and use forward slashes", f.Name))
+		}
+		if seen[f.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate entry", f.Name))
+		}
+		seen[f.Name] = true
+	}
+
+	data, err := readZipEntry(zr, "Content/score.gpif")
+	if err != nil {
+		return append(problems, fmt.Sprintf("Content/score.gpif: unreadable: %v", err))
+	}
+	if data == nil {
+		return append(problems, "Content/score.gpif: missing, alphaTab requires it")
+	}
+	return append(problems, checkGpifAlphaTab(data)...)
+}
+
+// gpifSections lists the root children alphaTab expects to find
+var gpifSections = []string{"Score", "MasterTrack", "Tracks", "MasterBars", "Bars", "Voices", "Beats", "Rhythms"}
+
+func checkGpifAlphaTab(data []byte) []string {
+	var problems []string
+
+	decoder := newGpifDecoder(data)
+	depth := 0
+	root := ""
+	present := make(map[string]bool)
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return append(problems, fmt.Sprintf("score.gpif: malformed XML: %v", err))
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				root = t.Name.Local
+			} else if depth == 1 {
+				present[t.Name.Local] = true
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			problems = append(problems, "score.gpif: XML directives (DOCTYPE) are not supported")
+		}
+	}
+	if root != "GPIF" {
+		return append(problems, fmt.Sprintf("score.gpif: root element is <%s>, expected <GPIF>", root))
+	}
+	for _, s := range gpifSections {
+		if !present[s] {
+			problems = append(problems, fmt.Sprintf("score.gpif: missing <%s> section", s))
+		}
+	}
+
+	g, err := parseGpif(data)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+
+	return append(problems, g.Validate()...)
+}
+
+func runCheck(args []string) {
+	cmd := flag.NewFlagSet("check", flag.ExitOnError)
+	var inputPath string
+	cmd.StringVar(&inputPath, "f", "", "Converted .gp file")
+	cmd.StringVar(&inputPath, "file", "", "Converted .gp file")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" && cmd.NArg() > 0 {
+		inputPath = cmd.Arg(0)
+	}
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp check -f <song.gp>")
+		os.Exit(1)
+	}
+
+	zr, err := zip.OpenReader(inputPath)
+	if err != nil {
+		fmt.Printf("Error: cannot open archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer zr.Close()
+
+	problems := checkAlphaTab(&zr.Reader)
+	if len(problems) > 0 {
+		fmt.Printf("%s: %d alphaTab compatibility problem(s):\n", inputPath, len(problems))
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+		zr.Close()
+		os.Exit(1)
+	}
+	fmt.Printf("%s: OK, loads under alphaTab's rules.\n", inputPath)
+}
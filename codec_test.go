@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+// roundTrip decodes the encoder's output for data
+func roundTrip(t testing.TB, data []byte) {
+	t.Helper()
+	fs := &GpxFileSystem{Mode: ParseStrict}
+	got, err := fs.decompress(NewBitReader(compressBcfz(append([]byte("BCFS"), data...))[4:]))
+	if err != nil {
+		t.Fatalf("%d bytes: %v", len(data), err)
+	}
+	if bytes.Equal(got, data) {
+		return
+	}
+	for i := range got {
+		if i >= len(data) || got[i] != data[i] {
+			t.Fatalf("%d bytes decoded to %d, first difference at byte %d", len(data), len(got), i)
+		}
+	}
+	t.Fatalf("%d bytes decoded to %d", len(data), len(got))
+}
+
+// codecPayloads are the structured round-trip cases: sizes around the
+// literal and match limits, runs longer than a back-reference can copy,
+// repeats at every word size boundary and at the edge of the 15 bit window
+func codecPayloads(rng *rand.Rand) map[string][]byte {
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rng.Read(b)
+		return b
+	}
+	repeat := func(unit []byte, n int) []byte {
+		return bytes.Repeat(unit, n/len(unit)+1)[:n]
+	}
+	payloads := map[string][]byte{
+		"empty":         {},
+		"one byte":      {0x42},
+		"literal limit": random(3),
+		"four literals": random(4),
+		"minimum match": repeat([]byte("abcd"), 8),
+		"long run":      repeat([]byte{0}, 3*bcfzWindow),
+		"period 1000":   repeat(random(1000), 50000),
+	}
+	for word := 2; word < bcfzMaxWord; word++ {
+		for _, period := range []int{1<<word - 1, 1 << word} {
+			payloads[fmt.Sprintf("word %d period %d", word, period)] = repeat(random(period), 3*period)
+		}
+	}
+	for _, period := range []int{bcfzWindow - 1, bcfzWindow, bcfzWindow + 1} {
+		payloads[fmt.Sprintf("window edge %d", period)] = repeat(random(period), 3*period)
+	}
+	return payloads
+}
+
+func TestCodecStructured(t *testing.T) {
+	for name, data := range codecPayloads(rand.New(rand.NewSource(1))) {
+		t.Run(name, func(t *testing.T) { roundTrip(t, data) })
+	}
+}
+
+// TestCodecRandom mixes noise with repeats of earlier data at random
+// distances, so back-references of every length and offset come up
+func TestCodecRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	rounds := 20
+	if testing.Short() {
+		rounds = 3
+	}
+	for r := 0; r < rounds; r++ {
+		var data []byte
+		for size := rng.Intn(1 << 17); len(data) < size; {
+			if len(data) > 0 && rng.Intn(2) == 0 {
+				from := rng.Intn(len(data))
+				data = append(data, data[from:from+rng.Intn(len(data)-from)+1]...)
+			} else {
+				chunk := make([]byte, rng.Intn(64)+1)
+				rng.Read(chunk)
+				data = append(data, chunk...)
+			}
+		}
+		roundTrip(t, data)
+	}
+}
+
+// TestCodecQuick round-trips arbitrary byte slices, and the same slices
+// doubled so they contain matches
+func TestCodecQuick(t *testing.T) {
+	check := func(data []byte) bool {
+		roundTrip(t, data)
+		roundTrip(t, append(data, data...))
+		return true
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
+
+func FuzzBCFZ(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("abcdabcdabcd"))
+	f.Add(bytes.Repeat([]byte{0}, 100))
+	f.Add(selftestGpx)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		roundTrip(t, data)
+	})
+}
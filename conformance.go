@@ -0,0 +1,150 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Ruleset is what one Guitar Pro release expects of a .gp archive.
+// Supporting a new release means adding its ruleset here.
+type Ruleset struct {
+	Target   string
+	Version  string         // written to VERSION
+	Versions *regexp.Regexp // VERSION values the release opens
+	Required []string       // entries the release refuses to open a file without
+	Known    []string       // entry names with the casing the release looks up
+	Roots    []string       // entries allowed outside Content/
+}
+
+// rulesets by -target value. Guitar Pro 7.5 and 8 read what 7.0 reads and
+// share its rules until a release differs.
+var rulesets = map[string]*Ruleset{
+	"7.0": gp7Ruleset("7.0"),
+	"7.5": gp7Ruleset("7.5"),
+	"8":   gp7Ruleset("8"),
+}
+
+// gp7Ruleset is the layout Guitar Pro 7.0 introduced, written as 7.0
+func gp7Ruleset(target string) *Ruleset {
+	return &Ruleset{
+		Target:   target,
+		Version:  "7.0",
+		Versions: regexp.MustCompile(`^[0-9]+\.[0-9]+$`),
+		Required: []string{"VERSION", "meta.json", "Content/score.gpif"},
+		Known: []string{
+			"VERSION", "meta.json",
+			"Content/score.gpif", "Content/Preferences.json", "Content/PartConfiguration",
+			"Content/LayoutConfiguration", "Content/BinaryStylesheet",
+			"Content/Stylesheets/score.gpss", "Content/ScoreViews/",
+		},
+		Roots: []string{"VERSION", "meta.json", manifestName},
+	}
+}
+
+const defaultTarget = "7.0"
+
+// target is the release written archives are checked against
+var target = rulesets[defaultTarget]
+
+func rulesetNames() []string {
+	names := make([]string, 0, len(rulesets))
+	for name := range rulesets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkArchive verifies the structure the ruleset's release expects of a
+// .gp archive, returning one message per problem
+func checkArchive(zr *zip.Reader, rs *Ruleset) []string {
+	var problems []string
+	present := make(map[string]bool)
+	for _, f := range zr.File {
+		present[f.Name] = true
+		for _, known := range rs.Known {
+			if f.Name != known && strings.EqualFold(f.Name, known) {
+				problems = append(problems, fmt.Sprintf("%s: must be spelled %s", f.Name, known))
+			}
+		}
+		if !slices.Contains(rs.Roots, f.Name) && !strings.HasPrefix(f.Name, "Content/") {
+			problems = append(problems, fmt.Sprintf("%s: entries other than %s belong under Content/", f.Name, strings.Join(rs.Roots, ", ")))
+		}
+		if strings.HasSuffix(f.Name, "/") && f.UncompressedSize64 > 0 {
+			problems = append(problems, fmt.Sprintf("%s: directory entry has content", f.Name))
+		}
+	}
+	for _, name := range rs.Required {
+		if !present[name] {
+			problems = append(problems, fmt.Sprintf("%s: missing", name))
+		}
+	}
+
+	if data, err := readZipEntry(zr, "VERSION"); err == nil && data != nil && !rs.Versions.Match(data) {
+		problems = append(problems, fmt.Sprintf("VERSION: %q is not a major.minor version", data))
+	}
+	if data, err := readZipEntry(zr, "meta.json"); err == nil && data != nil {
+		var meta map[string]interface{}
+		if err := json.Unmarshal(data, &meta); err != nil {
+			problems = append(problems, fmt.Sprintf("meta.json: not a JSON object: %v", err))
+		}
+	}
+	if data, err := readZipEntry(zr, "Content/score.gpif"); err == nil && data != nil {
+		if root := gpifRoot(data); root != "GPIF" {
+			problems = append(problems, fmt.Sprintf("Content/score.gpif: root element is %q, expected GPIF", root))
+		}
+	}
+	return problems
+}
+
+// gpifRoot returns the name of the document's root element, or "" when
+// there is none
+func gpifRoot(data []byte) string {
+	decoder := newGpifDecoder(data)
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local
+		}
+	}
+}
+
+// conformTarget checks a written archive against the target release
+func conformTarget(path string) error {
+	if isRemotePath(path) {
+		// Checked by putRemote before the upload
+		return nil
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("cannot reopen archive: %v", err)
+	}
+	defer zr.Close()
+	return conformReader(&zr.Reader)
+}
+
+// conformData checks an archive held in memory against the target release
+func conformData(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("cannot reopen archive: %v", err)
+	}
+	return conformReader(zr)
+}
+
+func conformReader(zr *zip.Reader) error {
+	if problems := checkArchive(zr, target); len(problems) > 0 {
+		return fmt.Errorf("archive does not meet Guitar Pro %s expectations: %s", target.Target, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The content check proves a conversion kept the music: it counts the
+// beats and notes of every bar of every track, and hashes the bar's
+// canonical text, in the score as read and in the score as written after
+// every transform. Bars that differ are reported by track, so a change is
+// either expected from the options given or a bug to report.
+
+// verifyContent is set by -verify-content
+var verifyContent bool
+
+// barContent is what the check compares of one bar of one track
+type barContent struct {
+	beats, notes int
+	sum          string // SHA-256 of the canonical text of the bar's beats
+}
+
+// trackContent is a track's bars, by master bar
+type trackContent struct {
+	name string
+	bars []barContent
+}
+
+// scoreContent counts and hashes the bars of every track
+func (g *Gpif) scoreContent() []trackContent {
+	var tracks []trackContent
+	for t := range g.Tracks {
+		track := &g.Tracks[t]
+		content := trackContent{name: strings.TrimSpace(track.Name), bars: make([]barContent, len(g.MasterBars))}
+		for m := range g.MasterBars {
+			bar := g.TrackBar(m, t)
+			if bar == nil {
+				continue
+			}
+			h := sha256.New()
+			c := &content.bars[m]
+			for k, voice := range g.BarVoices(bar) {
+				for _, beat := range g.VoiceBeats(voice) {
+					c.beats++
+					c.notes += len(g.BeatNotes(beat))
+					fmt.Fprintf(h, "%d %s\n", k, g.canonicalBeat(track, beat))
+				}
+			}
+			c.sum = hex.EncodeToString(h.Sum(nil))
+		}
+		tracks = append(tracks, content)
+	}
+	return tracks
+}
+
+// totals adds up the beats and notes of a track
+func (t *trackContent) totals() (beats, notes int) {
+	for _, b := range t.bars {
+		beats += b.beats
+		notes += b.notes
+	}
+	return beats, notes
+}
+
+// compareContent describes every track of after against before, returning
+// the tracks that are unchanged and the differences
+func compareContent(before, after []trackContent) (same, differences []string) {
+	if len(before) != len(after) {
+		differences = append(differences, fmt.Sprintf("the score had %s, it has %d", plural(len(before), "track"), len(after)))
+	}
+	for t := 0; t < len(before) && t < len(after); t++ {
+		b, a := &before[t], &after[t]
+		beatsBefore, notesBefore := b.totals()
+		beatsAfter, notesAfter := a.totals()
+		var changed []string
+		for m := 0; m < max(len(b.bars), len(a.bars)); m++ {
+			if m >= len(b.bars) || m >= len(a.bars) || b.bars[m] != a.bars[m] {
+				changed = append(changed, strconv.Itoa(m+1))
+			}
+		}
+		if len(changed) == 0 {
+			same = append(same, fmt.Sprintf("%s: %s, %s, %s, identical", a.name,
+				plural(len(a.bars), "bar"), plural(beatsAfter, "beat"), plural(notesAfter, "note")))
+			continue
+		}
+		if len(changed) > 10 {
+			changed = append(changed[:10], fmt.Sprintf("%d more", len(changed)-10))
+		}
+		differences = append(differences, fmt.Sprintf("%s: %d of %s differ (bars %s): %d beats and %d notes before, %d and %d after",
+			a.name, len(changed), plural(len(b.bars), "bar"), strings.Join(changed, ", "),
+			beatsBefore, notesBefore, beatsAfter, notesAfter))
+	}
+	return same, differences
+}
+
+// checkContent compares score.gpif with the score as read, warning about
+// every track that differs and describing those that do not
+func (fs *GpxFileSystem) checkContent(before *Gpif) ([]string, error) {
+	after, err := fs.loadScore()
+	if err != nil {
+		return nil, err
+	}
+	same, differences := compareContent(before.scoreContent(), after.scoreContent())
+	for _, d := range differences {
+		if err := fs.warn("content check: %s", d); err != nil {
+			return nil, err
+		}
+	}
+	return same, nil
+}
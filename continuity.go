@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Ties and grace notes connect a note to its neighbour in the same voice,
+// across bar lines too. A tie whose other end is missing or of another
+// pitch, and a grace note with no note after it, are drawn as stray arcs
+// and orphaned small notes. checkContinuity finds them, conversion warns,
+// and -repair-continuity repairs them: a tie is completed when the note it
+// needs is there and removed otherwise, and grace notes leading nowhere
+// are removed.
+
+// repairContinuity is set by -repair-continuity
+var repairContinuity bool
+
+// voiceStep is a beat in the run of a voice through the bars of a track,
+// with where it is listed
+type voiceStep struct {
+	masterBar int
+	voice     int // id
+	position  int // in the voice's beat list
+	beat      *GpifBeat
+}
+
+// continuityPlan lists tie and grace note problems and their repairs
+type continuityPlan struct {
+	problems []string
+	fixes    []string
+	ties     map[int][2]bool      // note id to its new tie origin and destination
+	graces   map[int]map[int]bool // voice id to the positions of grace beats to remove
+}
+
+func (p *continuityPlan) add(problem, fix string) {
+	p.problems = append(p.problems, problem)
+	p.fixes = append(p.fixes, fix)
+}
+
+// voiceRuns lists the beats of every voice of a track in score order. A
+// bar where the voice is missing breaks the run, as a nil beat.
+func (g *Gpif) voiceRuns(track int) [][]voiceStep {
+	var runs [][]voiceStep
+	for k := 0; k < 4; k++ {
+		var run []voiceStep
+		for m := range g.MasterBars {
+			bar := g.TrackBar(m, track)
+			var voice *GpifVoice
+			if bar != nil {
+				if ids := parseIDs(bar.Voices); k < len(ids) && ids[k] >= 0 {
+					voice = g.voiceByID[ids[k]]
+				}
+			}
+			if voice == nil {
+				run = append(run, voiceStep{masterBar: m})
+				continue
+			}
+			for pos, id := range parseIDs(voice.Beats) {
+				if beat := g.beatByID[id]; beat != nil {
+					run = append(run, voiceStep{masterBar: m, voice: voice.ID, position: pos, beat: beat})
+				}
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// tiedTo finds the note of a beat a note can be tied to: the same pitch,
+// on the same string when both are on strings
+func (g *Gpif) tiedTo(track *GpifTrack, note *GpifNote, beat *GpifBeat) *GpifNote {
+	pitch, ok := note.Pitch(track)
+	if !ok || beat == nil {
+		return nil
+	}
+	str, _, onString := note.StringFret()
+	for _, other := range g.BeatNotes(beat) {
+		p, ok := other.Pitch(track)
+		if !ok || p != pitch {
+			continue
+		}
+		if s, _, ok := other.StringFret(); onString && ok && s != str {
+			continue
+		}
+		return other
+	}
+	return nil
+}
+
+// checkContinuity finds broken ties and grace notes leading nowhere
+func (g *Gpif) checkContinuity() *continuityPlan {
+	p := &continuityPlan{ties: make(map[int][2]bool), graces: make(map[int]map[int]bool)}
+	ties := make(map[int][2]bool)
+	tie := func(n *GpifNote) [2]bool {
+		if t, ok := ties[n.ID]; ok {
+			return t
+		}
+		return [2]bool{n.Tie.Origin, n.Tie.Destination}
+	}
+	setTie := func(n *GpifNote, t [2]bool) {
+		ties[n.ID] = t
+		p.ties[n.ID] = t
+	}
+
+	for t := range g.Tracks {
+		track := &g.Tracks[t]
+		name := strings.TrimSpace(track.Name)
+		where := func(m int) string { return fmt.Sprintf("bar %d, %s", m+1, name) }
+		for _, run := range g.voiceRuns(t) {
+			// Grace notes lead to the next beat that is not one
+			var steps []voiceStep // the run without grace notes
+			for i, st := range run {
+				if st.beat == nil || st.beat.GraceNotes == "" {
+					steps = append(steps, st)
+					continue
+				}
+				j := i + 1
+				for j < len(run) && run[j].beat != nil && run[j].beat.GraceNotes != "" {
+					j++
+				}
+				if j == len(run) || run[j].beat == nil || len(parseIDs(run[j].beat.Notes)) == 0 {
+					if p.graces[st.voice] == nil {
+						p.graces[st.voice] = make(map[int]bool)
+					}
+					p.graces[st.voice][st.position] = true
+					p.add(where(st.masterBar)+": grace note with no note after it", where(st.masterBar)+": removed a grace note with no note after it")
+				}
+			}
+
+			// A tie ends on a note of the previous beat and starts one on
+			// the next, nothing in between
+			for i, st := range steps {
+				if st.beat == nil {
+					continue
+				}
+				var prev *GpifBeat
+				if i > 0 {
+					prev = steps[i-1].beat
+				}
+				for _, note := range g.BeatNotes(st.beat) {
+					if !tie(note)[1] {
+						continue
+					}
+					pitch, _ := note.Pitch(track)
+					from := g.tiedTo(track, note, prev)
+					switch {
+					case from == nil:
+						setTie(note, [2]bool{tie(note)[0], false})
+						p.add(fmt.Sprintf("%s: tie into %s has no note of that pitch before it", where(st.masterBar), pitchOctaveName(pitch)),
+							fmt.Sprintf("%s: removed the tie into %s", where(st.masterBar), pitchOctaveName(pitch)))
+					case !tie(from)[0]:
+						setTie(from, [2]bool{true, tie(from)[1]})
+						p.add(fmt.Sprintf("%s: tie into %s does not start on the note before it", where(st.masterBar), pitchOctaveName(pitch)),
+							fmt.Sprintf("%s: started the tie into %s on the note before it", where(st.masterBar), pitchOctaveName(pitch)))
+					}
+				}
+			}
+			for i, st := range steps {
+				if st.beat == nil {
+					continue
+				}
+				var next *GpifBeat
+				if i+1 < len(steps) {
+					next = steps[i+1].beat
+				}
+				for _, note := range g.BeatNotes(st.beat) {
+					if !tie(note)[0] {
+						continue
+					}
+					pitch, _ := note.Pitch(track)
+					to := g.tiedTo(track, note, next)
+					switch {
+					case to == nil:
+						setTie(note, [2]bool{false, tie(note)[1]})
+						p.add(fmt.Sprintf("%s: tie from %s has no note of that pitch after it", where(st.masterBar), pitchOctaveName(pitch)),
+							fmt.Sprintf("%s: removed the tie from %s", where(st.masterBar), pitchOctaveName(pitch)))
+					case !tie(to)[1]:
+						setTie(to, [2]bool{tie(to)[0], true})
+						p.add(fmt.Sprintf("%s: tie from %s does not end on the note after it", where(st.masterBar), pitchOctaveName(pitch)),
+							fmt.Sprintf("%s: ended the tie from %s on the note after it", where(st.masterBar), pitchOctaveName(pitch)))
+					}
+				}
+			}
+		}
+	}
+	return p
+}
+
+// applyContinuity writes the repaired ties and removes the grace notes
+// leading nowhere
+func applyContinuity(gpif []byte, p *continuityPlan) ([]byte, error) {
+	note, voice := -1, -1
+	tied := false // the note has a Tie element
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "Voice" && inside(stack, "Voices"):
+				voice, _ = strconv.Atoi(attrValue(t, "id"))
+			case t.Name.Local == "Note" && inside(stack, "Notes"):
+				note, _ = strconv.Atoi(attrValue(t, "id"))
+				tied = false
+			case t.Name.Local == "Tie" && inside(stack, "Notes", "Note"):
+				tied = true
+				if tie, ok := p.ties[note]; ok {
+					return []xml.Token{tieElement(tie)}
+				}
+			}
+		case xml.CharData:
+			if remove := p.graces[voice]; remove != nil && inside(stack, "Voices", "Voice", "Beats") {
+				var kept []string
+				for pos, id := range strings.Fields(string(t)) {
+					if !remove[pos] {
+						kept = append(kept, id)
+					}
+				}
+				return []xml.Token{xml.CharData(strings.Join(kept, " "))}
+			}
+		case xml.EndElement:
+			if tie, ok := p.ties[note]; ok && !tied && t.Name.Local == "Note" && inside(stack, "Notes", "Note") && (tie[0] || tie[1]) {
+				start := tieElement(tie)
+				return []xml.Token{start, start.End(), tok}
+			}
+		}
+		return []xml.Token{tok}
+	})
+	return out, err
+}
+
+// tieElement is a Tie start element with the given origin and destination
+func tieElement(tie [2]bool) xml.StartElement {
+	return xml.StartElement{Name: xml.Name{Local: "Tie"}, Attr: []xml.Attr{
+		{Name: xml.Name{Local: "origin"}, Value: strconv.FormatBool(tie[0])},
+		{Name: xml.Name{Local: "destination"}, Value: strconv.FormatBool(tie[1])},
+	}}
+}
+
+// reviewContinuity reports the tie and grace note problems of score.gpif,
+// or with repair set repairs them and describes the repairs
+func (fs *GpxFileSystem) reviewContinuity(repair bool) ([]string, error) {
+	score, err := fs.loadScore()
+	if err != nil {
+		return nil, err
+	}
+	p := score.checkContinuity()
+	if !repair {
+		for _, problem := range p.problems {
+			if err := fs.warn("%s, -repair-continuity repairs it", problem); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	if len(p.fixes) == 0 {
+		return nil, nil
+	}
+	file := fs.File("score.gpif")
+	data, err := applyContinuity(file.Data, p)
+	if err != nil {
+		return nil, fmt.Errorf("repairing ties and grace notes in score.gpif: %v", err)
+	}
+	file.Data, file.FileSize = data, len(data)
+	return p.fixes, nil
+}
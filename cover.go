@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+)
+
+// Cover artwork. -cover stores an image in the archive under
+// Content/Assets/ and names it in meta.json, where library tools look for
+// it. The score itself is left alone.
+
+// coverFormats maps the formats image decodes to the extension stored
+var coverFormats = map[string]string{"png": ".png", "jpeg": ".jpg"}
+
+// maxCoverSize keeps artwork from dwarfing the score it belongs to
+const maxCoverSize = 8 << 20
+
+// coverImage holds the image of -cover, coverSum its hash, which is part
+// of the cache key
+var (
+	coverImage *generatedFile
+	coverSum   string
+)
+
+// loadCover reads and checks the image of -cover, naming its archive entry
+func loadCover(path string) (*generatedFile, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxCoverSize {
+		return nil, "", fmt.Errorf("%s: %d bytes, covers are limited to %d", path, len(data), maxCoverSize)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || coverFormats[format] == "" {
+		return nil, "", fmt.Errorf("%s: not a PNG or JPEG image", path)
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return nil, "", fmt.Errorf("%s: image is empty", path)
+	}
+	debug("Cover %s: %s, %dx%d", path, format, config.Width, config.Height)
+	sum := sha256.Sum256(data)
+	return &generatedFile{"Content/Assets/cover" + coverFormats[format], data}, hex.EncodeToString(sum[:]), nil
+}
+
+// setCover adds the cover image to the archive
+func (fs *GpxFileSystem) setCover(cover *generatedFile) {
+	fs.assets = append(fs.assets, *cover)
+	fs.cover = cover.path
+}
+
+// archiveMeta is the content of meta.json: the cover and the tuning
+// labels, when there are any
+func archiveMeta(fs *GpxFileSystem) []byte {
+	meta := make(map[string]interface{})
+	if fs.cover != "" {
+		meta["cover"] = fs.cover
+	}
+	if len(fs.tunings) > 0 {
+		meta["tunings"] = fs.tunings
+	}
+	data, _ := json.Marshal(meta)
+	return data
+}
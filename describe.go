@@ -0,0 +1,315 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// gpx2gp describe narrates a score in plain sentences, for screen readers:
+// the song, its tracks and tunings, sections and changes, and on request
+// every bar beat by beat. Symbols are spelled out, nothing is laid out
+// in columns.
+
+// noteValueWords spells GPIF note values
+var noteValueWords = map[string]string{
+	"DoubleWhole": "double whole note",
+	"Whole":       "whole note",
+	"Half":        "half note",
+	"Quarter":     "quarter note",
+	"Eighth":      "eighth note",
+	"16th":        "sixteenth note",
+	"32nd":        "thirty-second note",
+	"64th":        "sixty-fourth note",
+	"128th":       "128th note",
+	"256th":       "256th note",
+}
+
+// drumNames names the General MIDI percussion notes drum tracks use
+var drumNames = map[int]string{
+	35: "acoustic bass drum", 36: "bass drum", 37: "side stick", 38: "snare",
+	39: "hand clap", 40: "electric snare", 41: "low floor tom", 42: "closed hi-hat",
+	43: "high floor tom", 44: "pedal hi-hat", 45: "low tom", 46: "open hi-hat",
+	47: "low mid tom", 48: "high mid tom", 49: "crash cymbal", 50: "high tom",
+	51: "ride cymbal", 52: "china cymbal", 53: "ride bell", 54: "tambourine",
+	55: "splash cymbal", 56: "cowbell", 57: "crash cymbal 2", 59: "ride cymbal 2",
+}
+
+// plural appends an s to word unless n is 1
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return strconv.Itoa(n) + " " + word + "s"
+}
+
+// spokenPitch spells a pitch for speech, "F sharp 4" rather than "F#4"
+func spokenPitch(pitch int) string {
+	return strings.Replace(pitchOctaveName(pitch), "#", " sharp ", 1)
+}
+
+// spokenDuration describes a beat's rhythm, e.g. "dotted eighth note"
+func (g *Gpif) spokenDuration(beat *GpifBeat) string {
+	id, _ := strconv.Atoi(beat.Rhythm.Ref)
+	rhythm := g.rhythmByID[id]
+	if rhythm == nil {
+		return "note of unknown length"
+	}
+	words := noteValueWords[rhythm.NoteValue]
+	if words == "" {
+		words = "quarter note"
+	}
+	switch dots := rhythm.AugmentationDot.Count; {
+	case dots == 2:
+		words = "double dotted " + words
+	case dots > 0:
+		words = "dotted " + words
+	}
+	if t := rhythm.PrimaryTuplet; t.Num > 0 && t.Den > 0 {
+		if t.Num == 3 && t.Den == 2 {
+			words = "triplet " + words
+		} else {
+			words = fmt.Sprintf("%s in a %d to %d tuplet", words, t.Num, t.Den)
+		}
+	}
+	return words
+}
+
+// spokenNote says where a note is played and what sounds
+func spokenNote(track *GpifTrack, note *GpifNote) string {
+	var parts []string
+	pitch, hasPitch := note.Pitch(track)
+	if str, fret, ok := note.StringFret(); ok && !track.IsPercussion() {
+		number := len(track.Tuning()) - str
+		if fret == 0 {
+			parts = append(parts, fmt.Sprintf("string %d open", number))
+		} else {
+			parts = append(parts, fmt.Sprintf("string %d fret %d", number, fret))
+		}
+		if hasPitch {
+			parts[0] = spokenPitch(pitch) + ", " + parts[0]
+		}
+	} else if name := drumNames[pitch]; hasPitch && track.IsPercussion() && name != "" {
+		parts = append(parts, name)
+	} else if hasPitch {
+		parts = append(parts, spokenPitch(pitch))
+	} else {
+		parts = append(parts, "unpitched note")
+	}
+	for _, t := range note.Techniques() {
+		parts = append(parts, strings.ReplaceAll(t, "_", " "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// spokenBeat narrates one beat
+func (g *Gpif) spokenBeat(track *GpifTrack, beat *GpifBeat) string {
+	notes := g.BeatNotes(beat)
+	duration := g.spokenDuration(beat)
+	var s string
+	switch len(notes) {
+	case 0:
+		s = strings.Replace(duration, "note", "rest", 1)
+	case 1:
+		s = duration + ": " + spokenNote(track, notes[0])
+	default:
+		spoken := make([]string, len(notes))
+		for i, n := range notes {
+			spoken[i] = spokenNote(track, n)
+		}
+		s = fmt.Sprintf("%s chord of %d notes: %s", duration, len(notes), strings.Join(spoken, "; "))
+	}
+	if beat.GraceNotes != "" {
+		s = "grace note, " + s
+	}
+	if text := strings.TrimSpace(beat.FreeText); text != "" {
+		s += ". Text: " + text
+	}
+	return s
+}
+
+// spokenTuning lists a track's strings from the lowest
+func spokenTuning(tuning []int) string {
+	names := make([]string, len(tuning))
+	for i, p := range tuning {
+		names[i] = spokenPitch(p)
+	}
+	return strings.Join(names, ", ")
+}
+
+// writeDescription narrates the score, bar by bar when bars is set
+func (g *Gpif) writeDescription(w io.Writer, bars bool) {
+	say := func(format string, a ...interface{}) {
+		fmt.Fprintf(w, format+"\n", a...)
+	}
+	s := &g.Score
+	title := strings.TrimSpace(s.Title)
+	if title == "" {
+		title = "Untitled score"
+	}
+	if artist := strings.TrimSpace(s.Artist); artist != "" {
+		say("%s, by %s.", title, artist)
+	} else {
+		say("%s.", title)
+	}
+	if tabber := strings.TrimSpace(s.Tabber); tabber != "" {
+		say("Transcribed by %s.", tabber)
+	}
+
+	tempos := g.masterBarTempos()
+	tl := g.BuildTimeline()
+	summary := fmt.Sprintf("%s and %s", plural(len(g.Tracks), "track"), plural(len(g.MasterBars), "bar"))
+	if len(g.MasterBars) > 0 {
+		first := &g.MasterBars[0]
+		summary += fmt.Sprintf(", starting in %s time at %s beats per minute, in %s", spokenTime(first.Time), formatBpm(tempos[0]), first.Key.Name())
+	}
+	say("%s. It plays for about %s, repeats included.", summary, spokenClock(tl.Duration()))
+
+	say("")
+	for i := range g.Tracks {
+		t := &g.Tracks[i]
+		line := fmt.Sprintf("Track %d, %s", i+1, strings.TrimSpace(t.Name))
+		switch {
+		case t.IsPercussion():
+			line += ", drums and percussion."
+		case len(t.Tuning()) > 0:
+			tuning := t.Tuning()
+			line += fmt.Sprintf(", %s tuned from the lowest: %s.", plural(len(tuning), "string"), spokenTuning(tuning))
+			if capo := t.Capo(); capo > 0 {
+				line += fmt.Sprintf(" Capo on fret %d.", capo)
+			}
+		default:
+			line += "."
+		}
+		say("%s", line)
+	}
+
+	var sections []string
+	for i, mb := range g.MasterBars {
+		if mb.Section != nil && mb.Section.Name() != "" {
+			sections = append(sections, fmt.Sprintf("%s at bar %d", mb.Section.Name(), i+1))
+		}
+	}
+	if len(sections) > 0 {
+		say("")
+		say("Sections: %s.", strings.Join(sections, "; "))
+	}
+
+	var changes []string
+	for i := 1; i < len(g.MasterBars); i++ {
+		prev, mb := &g.MasterBars[i-1], &g.MasterBars[i]
+		if strings.TrimSpace(mb.Time) != strings.TrimSpace(prev.Time) {
+			changes = append(changes, fmt.Sprintf("bar %d changes to %s time", i+1, spokenTime(mb.Time)))
+		}
+		if mb.Key != prev.Key {
+			changes = append(changes, fmt.Sprintf("bar %d changes key to %s", i+1, mb.Key.Name()))
+		}
+		if tempos[i] != tempos[i-1] {
+			changes = append(changes, fmt.Sprintf("bar %d changes tempo to %s beats per minute", i+1, formatBpm(tempos[i])))
+		}
+	}
+	start := 0
+	for i, mb := range g.MasterBars {
+		if mb.Repeat.Start {
+			start = i
+		}
+		if mb.Repeat.End {
+			changes = append(changes, fmt.Sprintf("bars %d to %d are played %s", start+1, i+1, plural(max(mb.Repeat.Count, 2), "time")))
+		}
+	}
+	if len(changes) > 0 {
+		say("")
+		say("Changes: %s.", strings.Join(changes, "; "))
+	}
+
+	if !bars {
+		return
+	}
+	for m, mb := range g.MasterBars {
+		say("")
+		header := fmt.Sprintf("Bar %d, %s time", m+1, spokenTime(mb.Time))
+		if mb.Section != nil && mb.Section.Name() != "" {
+			header += ", section " + mb.Section.Name()
+		}
+		say("%s.", header)
+		for t := range g.Tracks {
+			bar := g.TrackBar(m, t)
+			if bar == nil {
+				continue
+			}
+			voices := g.BarVoices(bar)
+			for v, voice := range voices {
+				beats := g.VoiceBeats(voice)
+				if len(beats) == 0 {
+					continue
+				}
+				spoken := make([]string, len(beats))
+				for i, beat := range beats {
+					spoken[i] = g.spokenBeat(&g.Tracks[t], beat)
+				}
+				name := strings.TrimSpace(g.Tracks[t].Name)
+				if len(voices) > 1 {
+					name += fmt.Sprintf(", voice %d", v+1)
+				}
+				say("%s: %s.", name, strings.Join(spoken, ". Then "))
+			}
+		}
+	}
+}
+
+// spokenTime reads a time signature, "3/4" as "3 4"
+func spokenTime(time string) string {
+	return strings.Replace(strings.TrimSpace(time), "/", " ", 1)
+}
+
+// spokenClock reads a duration, e.g. "3 minutes 5 seconds"
+func spokenClock(seconds float64) string {
+	s := int(seconds + 0.5)
+	if s < 60 {
+		return plural(s, "second")
+	}
+	if s%60 == 0 {
+		return plural(s/60, "minute")
+	}
+	return plural(s/60, "minute") + " " + plural(s%60, "second")
+}
+
+func runDescribe(args []string) {
+	cmd := flag.NewFlagSet("describe", flag.ExitOnError)
+	var inputPath, outputPath string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	bars := cmd.Bool("bars", false, "Also describe every bar beat by beat")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" && cmd.NArg() > 0 {
+		inputPath = cmd.Arg(0)
+	}
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp describe -f <input.gpx> [-bars] [-o <output.txt>]")
+		os.Exit(1)
+	}
+	if err := checkExportOutput(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+	score.writeDescription(w, *bars)
+}
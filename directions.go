@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Directions send playback back to a segno or the start and on to a coda
+// or Fine. A jump whose target is missing, or a repeat that plays once or
+// a hundred times, makes Guitar Pro play a very different length than the
+// score reads. checkDirections finds such problems, which conversion
+// reports, and works out the repair -repair-directions applies: jumps
+// that cannot be resolved are removed, or made plain D.C. and D.S. when
+// only their coda or Fine is missing, and repeat counts are made sane.
+
+// directionTargets are the targets GPIF knows, by how they read
+var directionTargets = map[string]string{
+	"Segno": "segno", "SegnoSegno": "double segno",
+	"Coda": "coda", "DoubleCoda": "double coda", "Fine": "Fine",
+}
+
+// directionJumps are the jumps GPIF knows
+var directionJumps = map[string]bool{
+	"DaCapo": true, "DaCapoAlCoda": true, "DaCapoAlDoubleCoda": true, "DaCapoAlFine": true,
+	"DaSegno": true, "DaSegnoAlCoda": true, "DaSegnoAlDoubleCoda": true, "DaSegnoAlFine": true,
+	"DaSegnoSegno": true, "DaSegnoSegnoAlCoda": true, "DaSegnoSegnoAlDoubleCoda": true, "DaSegnoSegnoAlFine": true,
+	"DaCoda": true, "DaDoubleCoda": true,
+}
+
+// repairDirections is set by -repair-directions
+var repairDirections bool
+
+// maxRepeatCount is the most plays a repeat is taken to mean
+const maxRepeatCount = 16
+
+// jumpName spells a jump as printed, DaSegnoAlCoda as D.S. al Coda
+func jumpName(jump string) string {
+	switch jump {
+	case "DaCoda":
+		return "To Coda"
+	case "DaDoubleCoda":
+		return "To Double Coda"
+	}
+	for _, r := range []struct{ from, to string }{
+		{"DaCapo", "D.C."}, {"DaSegnoSegno", "D.S.S."}, {"DaSegno", "D.S."},
+		{"AlDoubleCoda", " al Double Coda"}, {"AlCoda", " al Coda"}, {"AlFine", " al Fine"},
+	} {
+		jump = strings.Replace(jump, r.from, r.to, 1)
+	}
+	return jump
+}
+
+// jumpParts splits a D.C. or D.S. jump: the target it returns to, "" for
+// the start, the plain D.C. or D.S. it is based on, and where it then goes,
+// the To Coda jump and the coda, or Fine
+func jumpParts(jump string) (from, base, toJump, toTarget string) {
+	base = jump
+	for _, al := range []struct{ suffix, jump, target string }{
+		{"AlDoubleCoda", "DaDoubleCoda", "DoubleCoda"},
+		{"AlCoda", "DaCoda", "Coda"},
+		{"AlFine", "", "Fine"},
+	} {
+		if strings.HasSuffix(jump, al.suffix) {
+			base, toJump, toTarget = strings.TrimSuffix(jump, al.suffix), al.jump, al.target
+			break
+		}
+	}
+	switch base {
+	case "DaSegno":
+		from = "Segno"
+	case "DaSegnoSegno":
+		from = "SegnoSegno"
+	}
+	return from, base, toJump, toTarget
+}
+
+// directionsPlan lists the problems of a score's directions and repeats
+// and how to repair them
+type directionsPlan struct {
+	problems []string
+	fixes    []string
+	jumps    map[[2]int]string // master bar and jump index to the new jump, "" to remove
+	targets  map[[2]int]bool   // master bar and target index to remove
+	counts   map[int]int       // master bar to its new repeat count
+}
+
+func (p *directionsPlan) add(problem, fix string) {
+	p.problems = append(p.problems, problem)
+	p.fixes = append(p.fixes, fix)
+}
+
+// checkDirections finds the directions and repeats playback cannot follow
+func (g *Gpif) checkDirections() *directionsPlan {
+	p := &directionsPlan{
+		jumps: make(map[[2]int]string), targets: make(map[[2]int]bool),
+		counts: make(map[int]int),
+	}
+
+	// The first of each target counts, later ones are ambiguous
+	targetBar := make(map[string]int)
+	jumpBar := make(map[string]int)
+	for m, mb := range g.MasterBars {
+		for k, target := range mb.Directions.Targets {
+			target = strings.TrimSpace(target)
+			name, known := directionTargets[target]
+			switch _, seen := targetBar[target]; {
+			case !known:
+				p.targets[[2]int{m, k}] = true
+				p.add(fmt.Sprintf("bar %d: unknown direction target %q", m+1, target), fmt.Sprintf("bar %d: removed the unknown target %q", m+1, target))
+			case seen:
+				p.targets[[2]int{m, k}] = true
+				p.add(fmt.Sprintf("bar %d: a second %s, the first is in bar %d", m+1, name, targetBar[target]+1), fmt.Sprintf("bar %d: removed the second %s", m+1, name))
+			default:
+				targetBar[target] = m
+			}
+		}
+		for _, jump := range mb.Directions.Jumps {
+			if _, seen := jumpBar[strings.TrimSpace(jump)]; !seen {
+				jumpBar[strings.TrimSpace(jump)] = m
+			}
+		}
+	}
+
+	for m, mb := range g.MasterBars {
+		for k, jump := range mb.Directions.Jumps {
+			jump = strings.TrimSpace(jump)
+			key := [2]int{m, k}
+			if !directionJumps[jump] {
+				p.jumps[key] = ""
+				p.add(fmt.Sprintf("bar %d: unknown direction %q", m+1, jump), fmt.Sprintf("bar %d: removed the unknown direction %q", m+1, jump))
+				continue
+			}
+			name := jumpName(jump)
+			if jump == "DaCoda" || jump == "DaDoubleCoda" {
+				target := strings.TrimPrefix(jump, "Da")
+				if at, ok := targetBar[target]; !ok || at <= m {
+					p.jumps[key] = ""
+					p.add(fmt.Sprintf("bar %d: %s has no %s after it", m+1, name, directionTargets[target]), fmt.Sprintf("bar %d: removed %s", m+1, name))
+				}
+				continue
+			}
+			from, base, toJump, toTarget := jumpParts(jump)
+			if at, ok := targetBar[from]; from != "" && (!ok || at > m) {
+				p.jumps[key] = ""
+				p.add(fmt.Sprintf("bar %d: %s has no %s before it to return to", m+1, name, directionTargets[from]), fmt.Sprintf("bar %d: removed %s", m+1, name))
+				continue
+			}
+			if toTarget == "" {
+				continue
+			}
+			_, hasTarget := targetBar[toTarget]
+			_, hasJump := jumpBar[toJump]
+			switch {
+			case !hasTarget:
+				p.jumps[key] = base
+				p.add(fmt.Sprintf("bar %d: %s has no %s to go on to", m+1, name, directionTargets[toTarget]), fmt.Sprintf("bar %d: made %s %s", m+1, name, jumpName(base)))
+			case toJump != "" && !hasJump:
+				p.jumps[key] = base
+				p.add(fmt.Sprintf("bar %d: %s has no %s marking where to leave for the %s", m+1, name, jumpName(toJump), directionTargets[toTarget]), fmt.Sprintf("bar %d: made %s %s", m+1, name, jumpName(base)))
+			}
+		}
+	}
+
+	for m, mb := range g.MasterBars {
+		if !mb.Repeat.End {
+			continue
+		}
+		switch {
+		case mb.Repeat.Count < 2:
+			p.counts[m] = 2
+			p.add(fmt.Sprintf("bar %d: repeat plays %s", m+1, plural(mb.Repeat.Count, "time")), fmt.Sprintf("bar %d: repeat plays twice", m+1))
+		case mb.Repeat.Count > maxRepeatCount:
+			p.counts[m] = maxRepeatCount
+			p.add(fmt.Sprintf("bar %d: repeat plays %d times", m+1, mb.Repeat.Count), fmt.Sprintf("bar %d: repeat plays %d times", m+1, maxRepeatCount))
+		}
+	}
+	return p
+}
+
+// applyDirections repairs the directions and repeats of a score
+func applyDirections(gpif []byte, p *directionsPlan) ([]byte, error) {
+	masterBar, target, jump := -1, -1, -1
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "MasterBar" && inside(stack, "MasterBars"):
+				masterBar++
+				target, jump = -1, -1
+			case t.Name.Local == "Target" && inside(stack, "MasterBar", "Directions"):
+				target++
+				if p.targets[[2]int{masterBar, target}] {
+					return nil
+				}
+			case t.Name.Local == "Jump" && inside(stack, "MasterBar", "Directions"):
+				jump++
+				if to, ok := p.jumps[[2]int{masterBar, jump}]; ok && to == "" {
+					return nil
+				}
+			case t.Name.Local == "Repeat" && inside(stack, "MasterBar"):
+				if count, ok := p.counts[masterBar]; ok {
+					for i, a := range t.Attr {
+						if a.Name.Local == "count" {
+							t.Attr[i].Value = strconv.Itoa(count)
+						}
+					}
+					return []xml.Token{t}
+				}
+			}
+		case xml.CharData:
+			if to, ok := p.jumps[[2]int{masterBar, jump}]; ok && inside(stack, "MasterBar", "Directions", "Jump") {
+				return []xml.Token{xml.CharData(to)}
+			}
+		}
+		return []xml.Token{tok}
+	})
+	return out, err
+}
+
+// reviewDirections reports the direction problems of score.gpif, or with
+// repair set repairs them and describes the repairs
+func (fs *GpxFileSystem) reviewDirections(repair bool) ([]string, error) {
+	score, err := fs.loadScore()
+	if err != nil {
+		return nil, err
+	}
+	p := score.checkDirections()
+	if !repair {
+		for _, problem := range p.problems {
+			if err := fs.warn("%s, -repair-directions repairs it", problem); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	if len(p.fixes) == 0 {
+		return nil, nil
+	}
+	file := fs.File("score.gpif")
+	data, err := applyDirections(file.Data, p)
+	if err != nil {
+		return nil, fmt.Errorf("repairing directions in score.gpif: %v", err)
+	}
+	file.Data, file.FileSize = data, len(data)
+	return p.fixes, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Text found in the wild is mostly UTF-8, but files written by older
+// Windows builds carry UTF-16 names and Windows-1252 scores.
+
+// cp1252 maps the 0x80-0x9F range of Windows-1252, the rest matches Latin-1
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8d, 'Ž', 0x8f,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9d, 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c >= 0x80 && c < 0xa0 {
+			sb.WriteRune(cp1252[c-0x80])
+		} else {
+			sb.WriteRune(rune(c))
+		}
+	}
+	return sb.String()
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, order.Uint16(b[i:]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// decodeFileName reads a NUL terminated name field. A zero second byte
+// means UTF-16LE, otherwise the bytes are UTF-8 or, failing that, Windows-1252.
+func decodeFileName(raw []byte) string {
+	if len(raw) >= 2 && raw[0] != 0 && raw[1] == 0 {
+		end := 0
+		for end+1 < len(raw) && (raw[end] != 0 || raw[end+1] != 0) {
+			end += 2
+		}
+		return decodeUTF16(raw[:end], binary.LittleEndian)
+	}
+	end := bytes.IndexByte(raw, 0)
+	if end < 0 {
+		end = len(raw)
+	}
+	if utf8.Valid(raw[:end]) {
+		return string(raw[:end])
+	}
+	return decodeWindows1252(raw[:end])
+}
+
+// gpifText converts a score to UTF-8. UTF-16 is recognised by its byte
+// order mark, anything else that is not valid UTF-8 is read as Windows-1252,
+// whatever the XML declaration claims.
+func gpifText(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xef, 0xbb, 0xbf}):
+		return data[3:]
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		return []byte(decodeUTF16(data[2:], binary.LittleEndian))
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		return []byte(decodeUTF16(data[2:], binary.BigEndian))
+	case !utf8.Valid(data):
+		return []byte(decodeWindows1252(data))
+	}
+	return data
+}
+
+// newGpifDecoder returns an XML decoder that understands the encodings
+// score.gpif files declare
+func newGpifDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(gpifText(data)))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-16", "utf-16le", "utf-16be", "unicode", "iso-8859-1", "latin1", "latin-1", "windows-1252", "cp1252":
+			// Already transcoded by gpifText, only the declaration is left
+			return input, nil
+		}
+		return nil, fmt.Errorf("unsupported encoding %q", charset)
+	}
+	return decoder
+}
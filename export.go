@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var exporters = map[string]func(args []string){
+	"canonical": runExportCanonical,
+	"events":    runExportEvents,
+	"hydrogen":  runExportHydrogen,
+	"markers":   runExportMarkers,
+	"practice":  runExportPractice,
+	"tempo":     runExportTempo,
+	"texts":     runExportTexts,
+}
+
+func runExport(args []string) {
+	if len(args) == 0 || exporters[args[0]] == nil {
+		kinds := make([]string, 0, len(exporters))
+		for k := range exporters {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		fmt.Printf("Usage: gpx2gp export <%s> -f <input.gpx> [options]\n", strings.Join(kinds, "|"))
+		os.Exit(1)
+	}
+	exporters[args[0]](args[1:])
+}
+
+// Event is one note-on or note-off in the flat event stream
+type Event struct {
+	Time       float64  `json:"time"`
+	Tick       int      `json:"tick"`
+	Type       string   `json:"type"`
+	Track      int      `json:"track"`
+	TrackName  string   `json:"track_name"`
+	Pitch      int      `json:"pitch"`
+	Velocity   int      `json:"velocity"`
+	String     *int     `json:"string,omitempty"`
+	Fret       *int     `json:"fret,omitempty"`
+	Techniques []string `json:"techniques"`
+}
+
+func (g *Gpif) Events(tl *Timeline) []Event {
+	events := []Event{}
+	for track := range g.Tracks {
+		name := g.Tracks[track].Name
+		stringCount := len(g.Tracks[track].Tuning())
+		for _, n := range g.TrackNotes(tl, track) {
+			on := Event{
+				Time:       tl.Seconds(n.Tick),
+				Tick:       n.Tick,
+				Type:       "on",
+				Track:      track,
+				TrackName:  name,
+				Pitch:      n.Pitch,
+				Velocity:   n.Velocity,
+				Techniques: n.Note.Techniques(),
+			}
+			if str, fret, ok := n.Note.StringFret(); ok && str >= 0 && str < stringCount {
+				number := stringCount - str
+				on.String, on.Fret = &number, &fret
+			}
+			off := on
+			off.Type = "off"
+			off.Tick = n.Tick + n.Ticks
+			off.Time = tl.Seconds(off.Tick)
+			off.Velocity = 0
+			events = append(events, on, off)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Tick != events[j].Tick {
+			return events[i].Tick < events[j].Tick
+		}
+		return events[i].Type == "off" && events[j].Type == "on"
+	})
+	return events
+}
+
+func writeEventsCsv(w io.Writer, events []Event) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "tick", "type", "track", "track_name", "pitch", "velocity", "string", "fret", "techniques"})
+	optional := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+	for _, e := range events {
+		cw.Write([]string{
+			strconv.FormatFloat(e.Time, 'f', 4, 64),
+			strconv.Itoa(e.Tick),
+			e.Type,
+			strconv.Itoa(e.Track),
+			e.TrackName,
+			strconv.Itoa(e.Pitch),
+			strconv.Itoa(e.Velocity),
+			optional(e.String),
+			optional(e.Fret),
+			strings.Join(e.Techniques, "|"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func runExportEvents(args []string) {
+	cmd := flag.NewFlagSet("export events", flag.ExitOnError)
+	var inputPath, outputPath, format string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	cmd.StringVar(&format, "format", "json", "Output format: json or csv")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp export events -f <input.gpx> [-o <output>] [-format json|csv]")
+		os.Exit(1)
+	}
+	if format != "json" && format != "csv" {
+		fmt.Printf("Error: unknown format '%s'.\n", format)
+		os.Exit(1)
+	}
+	if err := checkExportOutput(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	events := score.Events(score.BuildTimeline())
+
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+
+	if format == "csv" {
+		err = writeEventsCsv(w, events)
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(events)
+	}
+	if err != nil {
+		fmt.Printf("Error writing events: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runExportTempo(args []string) {
+	cmd := flag.NewFlagSet("export tempo", flag.ExitOnError)
+	var inputPath, outputPath string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output MIDI file")
+	cmd.StringVar(&outputPath, "out", "", "Output MIDI file")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" || outputPath == "" {
+		fmt.Println("Usage: gpx2gp export tempo -f <input.gpx> -o <tempo.mid>")
+		os.Exit(1)
+	}
+	outputPath = withExtension(outputPath, ".mid")
+	if err := checkOutputPath(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	tl := score.BuildTimeline()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer out.Close()
+
+	// A type-1 file whose only track is the conductor track
+	if err := writeMidiFile(out, []*MidiTrack{score.conductorTrack(tl)}); err != nil {
+		fmt.Printf("Error writing tempo map: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s: %d tempo changes over %d bars.\n", outputPath, len(tl.Tempos), len(tl.Bars))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sumsName is the sidecar listing the SHA-256 of every extracted file, in
+// sha256sum format
+const sumsName = "SHA256SUMS"
+
+func runExtract(args []string) {
+	cmd := flag.NewFlagSet("extract", flag.ExitOnError)
+	var inputPath, outputDir string
+	var sums bool
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputDir, "o", "", "Output directory")
+	cmd.StringVar(&outputDir, "out", "", "Output directory")
+	cmd.BoolVar(&sums, "sums", false, "Write a "+sumsName+" file covering every extracted file")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" || outputDir == "" {
+		fmt.Println("Usage: gpx2gp extract -f <input.gpx> -o <dir> [-sums]")
+		os.Exit(1)
+	}
+
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, w := range fs.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	var list strings.Builder
+	err = extractFiles(fs, outputDir, func(name string, data []byte) {
+		list.WriteString(manifestLine(name, data))
+		fmt.Printf("%10d  %s\n", len(data), name)
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if sums {
+		path := filepath.Join(outputDir, sumsName)
+		if err := os.WriteFile(path, []byte(list.String()), 0644); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %s, check with: cd %s && sha256sum -c %s\n", path, outputDir, sumsName)
+	}
+	if len(fs.Warnings) > 0 {
+		os.Exit(exitWarnings)
+	}
+}
+
+// extractFiles writes every inner file of fs below outputDir, refusing to
+// overwrite, and calls written for each
+func extractFiles(fs *GpxFileSystem, outputDir string, written func(name string, data []byte)) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	for _, file := range fs.Files {
+		// Names were checked when the container was read, check again
+		// right before they become paths
+		if err := safeEntryName(file.FileName); err != nil {
+			return err
+		}
+		path := filepath.Join(outputDir, filepath.FromSlash(file.FileName))
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("output file '%s' already exists", path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		data, err := file.Content()
+		if err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+		if err != nil {
+			return err
+		}
+		written(file.FileName, data)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Triplet feel is a master bar setting in both GP6 and GP7 scores, one of
+// the names below. Guitar Pro 7 plays a bar whose value it does not know
+// straight, so conversion respells values written in another case and
+// reports unknown ones. -feel sets the feel of every bar, or of a range,
+// for tabs written straight that are meant to swing.
+
+// tripletFeels are the values Guitar Pro 7 knows, by their names for -feel
+var tripletFeels = map[string]string{
+	"straight":   "NoTripletFeel",
+	"triplet8":   "Triplet8th",
+	"triplet16":  "Triplet16th",
+	"dotted8":    "Dotted8th",
+	"dotted16":   "Dotted16th",
+	"scottish8":  "Scottish8th",
+	"scottish16": "Scottish16th",
+}
+
+// feelAliases are further -feel names
+var feelAliases = map[string]string{
+	"none": "straight", "swing": "triplet8", "shuffle": "triplet8",
+	"swing8": "triplet8", "swing16": "triplet16",
+}
+
+// canonicalFeel returns the GPIF spelling of a triplet feel value
+func canonicalFeel(value string) (string, bool) {
+	for _, feel := range tripletFeels {
+		if strings.EqualFold(feel, strings.TrimSpace(value)) {
+			return feel, true
+		}
+	}
+	return "", false
+}
+
+// feelFlag is the value of -feel: a feel, and the master bars it applies
+// to when not all of them
+type feelFlag struct {
+	feel        string // GPIF value, "" when not set
+	first, last int    // master bars, from 0; last is -1 for the end
+	text        string
+}
+
+var forcedFeel = feelFlag{last: -1}
+
+func (f *feelFlag) String() string { return f.text }
+
+// Set parses name or name:first-last, bars numbered from 1
+func (f *feelFlag) Set(s string) error {
+	name, bars, ranged := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	if alias, ok := feelAliases[name]; ok {
+		name = alias
+	}
+	feel, ok := tripletFeels[name]
+	if !ok {
+		if feel, ok = canonicalFeel(name); !ok {
+			return fmt.Errorf("unknown feel %q, use straight, triplet8, triplet16, dotted8, dotted16, scottish8 or scottish16", name)
+		}
+	}
+	first, last := 0, -1
+	if ranged {
+		from, to, _ := strings.Cut(bars, "-")
+		a, err1 := strconv.Atoi(from)
+		b, err2 := strconv.Atoi(to)
+		if to == "" {
+			b, err2 = a, nil
+		}
+		if err1 != nil || err2 != nil || a < 1 || b < a {
+			return fmt.Errorf("bars %q: use first-last, numbered from 1", bars)
+		}
+		first, last = a-1, b-1
+	}
+	*f = feelFlag{feel: feel, first: first, last: last, text: s}
+	return nil
+}
+
+// covers reports whether the forced feel applies to a master bar
+func (f *feelFlag) covers(masterBar int) bool {
+	return f.feel != "" && masterBar >= f.first && (f.last < 0 || masterBar <= f.last)
+}
+
+// feelChanges is what applyFeel did
+type feelChanges struct {
+	respelled int
+	forced    []int          // master bars
+	unknown   map[int]string // master bar to the value it had
+}
+
+// applyFeel respells the triplet feel of every master bar and applies the
+// forced feel, returning gpif itself when nothing changed
+func applyFeel(gpif []byte, force *feelFlag) ([]byte, *feelChanges, error) {
+	c := &feelChanges{unknown: make(map[int]string)}
+	masterBar := -1
+	seen := false
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "MasterBar" && inside(stack, "MasterBars") {
+				masterBar++
+				seen = false
+			}
+		case xml.CharData:
+			if !inside(stack, "MasterBar", "TripletFeel") {
+				break
+			}
+			seen = true
+			value := strings.TrimSpace(string(t))
+			feel, ok := canonicalFeel(value)
+			switch {
+			case force.covers(masterBar):
+				c.forced = append(c.forced, masterBar)
+				return []xml.Token{xml.CharData(force.feel)}
+			case !ok:
+				c.unknown[masterBar] = value
+				return []xml.Token{xml.CharData("NoTripletFeel")}
+			case feel != string(t):
+				c.respelled++
+				return []xml.Token{xml.CharData(feel)}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "MasterBar" && len(stack) >= 2 && stack[len(stack)-2] == "MasterBars" && !seen && force.covers(masterBar) {
+				c.forced = append(c.forced, masterBar)
+				start := xml.StartElement{Name: xml.Name{Local: "TripletFeel"}}
+				return []xml.Token{start, xml.CharData(force.feel), start.End(), tok}
+			}
+		}
+		return []xml.Token{tok}
+	})
+	if err != nil || (c.respelled == 0 && len(c.forced) == 0 && len(c.unknown) == 0) {
+		// Rewriting would respell the rest of the document, CDATA included
+		return gpif, c, err
+	}
+	return out, c, nil
+}
+
+// setFeel migrates the triplet feel of score.gpif and applies -feel,
+// describing the result
+func (fs *GpxFileSystem) setFeel() (string, error) {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return "", fs.missingScore()
+	}
+	data, c, err := applyFeel(file.Data, &forcedFeel)
+	if err != nil {
+		return "", fmt.Errorf("setting the triplet feel in score.gpif: %v", err)
+	}
+	if c.respelled > 0 || len(c.forced) > 0 || len(c.unknown) > 0 {
+		file.Data, file.FileSize = data, len(data)
+	}
+
+	var unknown []int
+	for m := range c.unknown {
+		unknown = append(unknown, m)
+	}
+	sort.Ints(unknown)
+	for _, m := range unknown {
+		if err := fs.warn("master bar %d has the unknown triplet feel %q, it plays straight", m+1, c.unknown[m]); err != nil {
+			return "", err
+		}
+	}
+	var done []string
+	if len(c.forced) > 0 {
+		done = append(done, fmt.Sprintf("%s on %s", forcedFeel.feel, barSpan(c.forced[0], c.forced[len(c.forced)-1])))
+		if forcedFeel.last >= 0 && c.forced[len(c.forced)-1] < forcedFeel.last {
+			if err := fs.warn("-feel: the score ends at bar %d", c.forced[len(c.forced)-1]+1); err != nil {
+				return "", err
+			}
+		}
+	} else if forcedFeel.feel != "" {
+		if err := fs.warn("-feel: the score has no bar %d", forcedFeel.first+1); err != nil {
+			return "", err
+		}
+	}
+	if c.respelled > 0 {
+		done = append(done, plural(c.respelled, "bar")+" respelled")
+	}
+	return strings.Join(done, ", "), nil
+}
+
+// barSpan names a range of master bars, numbered from 1
+func barSpan(first, last int) string {
+	if first == last {
+		return fmt.Sprintf("bar %d", first+1)
+	}
+	return fmt.Sprintf("bars %d-%d", first+1, last+1)
+}
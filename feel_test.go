@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fixtureScore loads the self-test fixture, returning it and a copy of
+// its score.gpif as read
+func fixtureScore(t *testing.T) (*GpxFileSystem, []byte) {
+	t.Helper()
+	fs := &GpxFileSystem{Limits: limits}
+	if err := fs.Load(selftestGpx); err != nil {
+		t.Fatal(err)
+	}
+	return fs, append([]byte(nil), fs.File("score.gpif").Data...)
+}
+
+func TestSetFeelLeavesScore(t *testing.T) {
+	fs, before := fixtureScore(t)
+	done, err := fs.setFeel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != "" {
+		t.Errorf("setFeel reported %q with nothing to do", done)
+	}
+	if !bytes.Equal(fs.File("score.gpif").Data, before) {
+		t.Error("score.gpif changed without -feel or a feel to respell")
+	}
+}
+
+func TestSetFeelForced(t *testing.T) {
+	saved := forcedFeel
+	defer func() { forcedFeel = saved }()
+	if err := forcedFeel.Set("swing:1"); err != nil {
+		t.Fatal(err)
+	}
+	fs, before := fixtureScore(t)
+	done, err := fs.setFeel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done != "Triplet8th on bar 1" {
+		t.Errorf("setFeel reported %q", done)
+	}
+	after := fs.File("score.gpif").Data
+	if bytes.Equal(after, before) || strings.Count(string(after), "<TripletFeel>Triplet8th</TripletFeel>") != 1 {
+		t.Error("score.gpif does not swing bar 1 only")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFileNameBytes keeps derived names well inside the 255 byte limit of
+// common file systems, leaving room for an extension and a counter
+const maxFileNameBytes = 200
+
+// windowsReserved are device names Windows refuses as a file's base name
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"COM¹": true, "COM²": true, "COM³": true, "LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// fileNameChars is the -filename-chars setting: "unicode" keeps names as
+// written, "fold" strips diacritics, "ascii" also replaces whatever is
+// not ASCII, for file systems and tools that mangle anything else
+var fileNameChars = "unicode"
+
+var fileNameCharsModes = []string{"unicode", "fold", "ascii"}
+
+// foldedLetter returns the ASCII letter a precomposed letter decomposes
+// into followed by marks only, as é into e and a combining acute
+func foldedLetter(r rune) (rune, bool) {
+	d := []rune(norm.NFD.String(string(r)))
+	if len(d) < 2 || d[0] >= 0x80 {
+		return 0, false
+	}
+	for _, m := range d[1:] {
+		if !unicode.Is(unicode.Mn, m) {
+			return 0, false
+		}
+	}
+	return d[0], true
+}
+
+// letterFolds spells letters that are not a base letter with a mark
+var letterFolds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "Th", 'ł': "l", 'Ł': "L", 'ı': "i",
+	'‘': "'", '’': "'", '“': "'", '”': "'", '–': "-", '—': "-", '…': "...",
+}
+
+// transliterate applies the -filename-chars setting to a composed name
+func transliterate(name string) string {
+	if fileNameChars == "unicode" {
+		return name
+	}
+	var sb strings.Builder
+	lastOther := false
+	for _, r := range name {
+		if base, ok := foldedLetter(r); ok {
+			sb.WriteRune(base)
+		} else if fold, ok := letterFolds[r]; ok {
+			sb.WriteString(fold)
+		} else if r < 0x80 || fileNameChars == "fold" {
+			sb.WriteRune(r)
+		} else if !lastOther {
+			// A run of other characters becomes a single underscore
+			sb.WriteRune('_')
+			lastOther = true
+			continue
+		} else {
+			continue
+		}
+		lastOther = false
+	}
+	name = sb.String()
+	if !strings.ContainsFunc(name, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+		// Nothing readable is left, such as from a title in Japanese
+		return ""
+	}
+	return name
+}
+
+// safeFileName turns score metadata into a file name that is valid on
+// Windows, macOS and Linux. It returns "" when nothing usable is left.
+func safeFileName(name string) string {
+	name = transliterate(norm.NFC.String(name))
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			sb.WriteRune('_')
+		case unicode.IsControl(r) || r == utf8.RuneError:
+		case unicode.IsSpace(r):
+			sb.WriteRune(' ')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	name = strings.Join(strings.Fields(sb.String()), " ")
+
+	// Cap the length on a character boundary. Long titles that share a
+	// beginning would end up with the same name, so a truncated name ends
+	// in a hash of the whole.
+	if len(name) > maxFileNameBytes {
+		sum := sha1.Sum([]byte(name))
+		suffix := "~" + hex.EncodeToString(sum[:4])
+		cut := maxFileNameBytes - len(suffix)
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = strings.TrimRight(name[:cut], " ") + suffix
+	}
+
+	// Leading dots hide files on macOS and Linux, trailing dots and spaces
+	// are stripped by Windows
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+	return name
+}
+
+// checkFileNameChars validates the -filename-chars setting
+func checkFileNameChars(mode string) error {
+	for _, m := range fileNameCharsModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown -filename-chars '%s', use %s", mode, strings.Join(fileNameCharsModes, ", "))
+}
+
+// scoreFileName derives "Artist - Title" from the score, or "" when the
+// score has no title, or none left after transliteration
+func (g *Gpif) scoreFileName() string {
+	title, artist := strings.TrimSpace(g.Score.Title), strings.TrimSpace(g.Score.Artist)
+	if title == "" || transliterate(norm.NFC.String(title)) == "" {
+		return ""
+	}
+	if artist != "" && transliterate(norm.NFC.String(artist)) != "" {
+		title = artist + " - " + title
+	}
+	return safeFileName(title)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// withFileNameChars runs f with -filename-chars set to mode
+func withFileNameChars(t *testing.T, mode string, f func()) {
+	t.Helper()
+	saved := fileNameChars
+	fileNameChars = mode
+	defer func() { fileNameChars = saved }()
+	f()
+}
+
+func TestSafeFileNameTransliteration(t *testing.T) {
+	tests := []struct {
+		mode, in, want string
+	}{
+		{"unicode", "Motörhead - Ace of Spades", "Motörhead - Ace of Spades"},
+		{"unicode", "Mo\u0308tley Cru\u0308e", "M\u00f6tley Cr\u00fce"}, // decomposed, as macOS stores it
+		{"unicode", "Sigur Rós - Hoppípolla", "Sigur Rós - Hoppípolla"},
+		{"unicode", "\u1100\u1161\u11a8\u1109\u1161\u11bc", "\uac01\uc0c1"},       // Hangul jamo
+		{"unicode", "Tie\u0302\u0301ng Vie\u0302\u0323t", "Ti\u1ebfng Vi\u1ec7t"}, // stacked marks, the dot below written last
+		{"unicode", "\u30cf\u309a\u30f3", "\u30d1\u30f3"},
+		{"fold", "Motörhead - Ace of Spades", "Motorhead - Ace of Spades"},
+		{"fold", "Mo\u0308tley Cru\u0308e", "Motley Crue"},
+		{"fold", "Straße – Œuvre", "Strasse - OEuvre"},
+		{"fold", "Кино - Группа крови", "Кино - Группа крови"},
+		{"fold", "Tie\u0302\u0301ng Vie\u0323\u0302t", "Tieng Viet"},
+		{"fold", "Чайф", "Чайф"},
+		{"ascii", "Кино - Группа крови", ""}, // nothing readable left
+		{"ascii", "Björk - Jóga", "Bjork - Joga"},
+		{"ascii", "Sigur Rós ✝ Svefn-g-englar", "Sigur Ros _ Svefn-g-englar"},
+		{"ascii", "東京事変", ""},
+	}
+	for _, tt := range tests {
+		withFileNameChars(t, tt.mode, func() {
+			if got := safeFileName(tt.in); got != tt.want {
+				t.Errorf("%s: safeFileName(%q) = %q, expected %q", tt.mode, tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSafeFileNameInvalidCharacters(t *testing.T) {
+	// What each system refuses in a file name; the result must be valid
+	// on all of them
+	invalid := map[string]string{
+		"windows": `<>:"/\|?*` + "\x00\x01\x1f",
+		"darwin":  ":/\x00",
+		"linux":   "/\x00",
+	}
+	for goos, chars := range invalid {
+		for _, r := range chars {
+			in := "Before" + string(r) + "After"
+			got := safeFileName(in)
+			if strings.ContainsAny(got, chars) {
+				t.Errorf("%s: safeFileName(%q) = %q keeps %q", goos, in, got, r)
+			}
+			if !strings.HasPrefix(got, "Before") || !strings.HasSuffix(got, "After") {
+				t.Errorf("%s: safeFileName(%q) = %q lost the text around %q", goos, in, got, r)
+			}
+		}
+	}
+
+	tests := map[string]string{
+		"AC/DC - Back in Black":      "AC_DC - Back in Black",
+		"What?":                      "What_",
+		"Wide\u3000space  and  runs": "Wide space and runs",
+		"Line\nbreak":                "Linebreak",
+		"...hidden":                  "hidden",
+		"Trailing dots...":           "Trailing dots",
+		"Trailing space  ":           "Trailing space",
+		"\xff\xfeBroken":             "Broken",
+	}
+	for in, want := range tests {
+		if got := safeFileName(in); got != want {
+			t.Errorf("safeFileName(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
+
+func TestSafeFileNameWindowsDevices(t *testing.T) {
+	tests := map[string]string{
+		"CON":         "_CON",
+		"con":         "_con",
+		"NUL.txt":     "_NUL.txt",
+		"Com1":        "_Com1",
+		"LPT9":        "_LPT9",
+		"COM¹":        "_COM¹",
+		"AUX - Intro": "AUX - Intro",
+		"Console":     "Console",
+		"CON10":       "CON10",
+	}
+	for in, want := range tests {
+		if got := safeFileName(in); got != want {
+			t.Errorf("safeFileName(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
+
+func TestSafeFileNameTruncation(t *testing.T) {
+	short := strings.Repeat("a", maxFileNameBytes)
+	if got := safeFileName(short); got != short {
+		t.Errorf("a name of %d bytes was changed to %q", maxFileNameBytes, got)
+	}
+
+	long := strings.Repeat("Very long title ", 20)
+	a, b := safeFileName(long+"one"), safeFileName(long+"two")
+	for _, name := range []string{a, b} {
+		if len(name) > maxFileNameBytes {
+			t.Errorf("%q is %d bytes, over %d", name, len(name), maxFileNameBytes)
+		}
+		if i := strings.LastIndex(name, "~"); i < 0 || len(name)-i != 9 {
+			t.Errorf("%q does not end in ~ and a hash", name)
+		}
+	}
+	if a == b {
+		t.Errorf("names sharing their first %d bytes both became %q", len(long), a)
+	}
+	if safeFileName(long+"one") != a {
+		t.Error("the hash of a truncated name is not stable")
+	}
+
+	// Multi-byte characters are cut whole
+	name := safeFileName(strings.Repeat("ö", maxFileNameBytes))
+	if !utf8.ValidString(name) || len(name) > maxFileNameBytes {
+		t.Errorf("%q is not a valid name of at most %d bytes", name, maxFileNameBytes)
+	}
+}
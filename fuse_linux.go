@@ -0,0 +1,381 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// The FUSE kernel protocol, spoken directly on /dev/fuse: enough of it for
+// a read-only directory of files held in memory. Root mounts the file
+// system itself, other users go through the setuid fusermount helper,
+// which hands the device back over a socket.
+
+const (
+	fuseRootID     = 1
+	fuseMaxWrite   = 128 << 10
+	fuseBufferSize = fuseMaxWrite + 4096
+	fuseTTL        = 60 // seconds the kernel may cache names and attributes
+
+	fuseLookup      = 1
+	fuseForget      = 2
+	fuseGetattr     = 3
+	fuseOpen        = 14
+	fuseRead        = 15
+	fuseStatfs      = 17
+	fuseRelease     = 18
+	fuseFlush       = 25
+	fuseInit        = 26
+	fuseOpendir     = 27
+	fuseReaddir     = 28
+	fuseReleasedir  = 29
+	fuseAccess      = 34
+	fuseInterrupt   = 36
+	fuseDestroy     = 38
+	fuseBatchForget = 42
+
+	fuseInHeaderSize = 40
+	fuseKeepCache    = 1 << 1 // FOPEN_KEEP_CACHE, the files never change
+)
+
+// fuseMount is a mounted file system and the device serving it
+type fuseMount struct {
+	dev        *os.File
+	mountPoint string
+	helper     string // fusermount binary that mounted it, "" when mounted directly
+
+	files    []mountedFile
+	modified time.Time
+}
+
+func mountFuse(mountPoint string) (*fuseMount, error) {
+	abs, err := filepath.Abs(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err == nil {
+		opts := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d", dev.Fd(), os.Getuid(), os.Getgid())
+		err = syscall.Mount("gpx2gp", abs, "fuse.gpx2gp", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_RDONLY, opts)
+		if err == nil {
+			return &fuseMount{dev: dev, mountPoint: abs}, nil
+		}
+		dev.Close()
+		debug("Mounting directly failed (%v), trying fusermount", err)
+	}
+	return mountWithHelper(abs)
+}
+
+// mountWithHelper has fusermount mount the file system and receives the
+// device from it, as passed with _FUSE_COMMFD
+func mountWithHelper(mountPoint string) (*fuseMount, error) {
+	helper := ""
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if path, err := exec.LookPath(name); err == nil {
+			helper = path
+			break
+		}
+	}
+	if helper == "" {
+		return nil, fmt.Errorf("cannot mount %s: not allowed to mount and fusermount is not installed", mountPoint)
+	}
+
+	pair, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, err
+	}
+	child := os.NewFile(uintptr(pair[0]), "fusermount socket")
+	parent := os.NewFile(uintptr(pair[1]), "gpx2gp socket")
+	defer parent.Close()
+
+	cmd := exec.Command(helper, "-o", "ro,nosuid,nodev,fsname=gpx2gp,subtype=gpx2gp", "--", mountPoint)
+	cmd.ExtraFiles = []*os.File{child} // descriptor 3 in the helper
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	child.Close()
+	if err != nil {
+		return nil, fmt.Errorf("starting %s: %v", helper, err)
+	}
+
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, recvErr := syscall.Recvmsg(int(parent.Fd()), buf, oob, 0)
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v", filepath.Base(helper), err)
+	}
+	if recvErr != nil {
+		return nil, fmt.Errorf("receiving the FUSE device: %v", recvErr)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return nil, fmt.Errorf("%s did not pass the FUSE device", filepath.Base(helper))
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return nil, fmt.Errorf("%s did not pass the FUSE device", filepath.Base(helper))
+	}
+	return &fuseMount{dev: os.NewFile(uintptr(fds[0]), "/dev/fuse"), mountPoint: mountPoint, helper: helper}, nil
+}
+
+func (m *fuseMount) unmount() error {
+	if m.helper != "" {
+		return exec.Command(m.helper, "-u", "-z", m.mountPoint).Run()
+	}
+	return syscall.Unmount(m.mountPoint, syscall.MNT_DETACH)
+}
+
+// serve answers the kernel until the file system is unmounted, by
+// Ctrl+C or from outside
+func (m *fuseMount) serve(files []mountedFile, modified time.Time) error {
+	m.files, m.modified = files, modified
+	defer m.dev.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		for range signals {
+			if err := m.unmount(); err != nil {
+				fmt.Printf("Error: unmounting %s: %v\n", m.mountPoint, err)
+			}
+		}
+	}()
+
+	fd := int(m.dev.Fd())
+	buf := make([]byte, fuseBufferSize)
+	for {
+		n, err := syscall.Read(fd, buf)
+		switch err {
+		case nil:
+		case syscall.EINTR, syscall.EAGAIN, syscall.ENOENT:
+			// Interrupted, or the request was aborted before it was read
+			continue
+		case syscall.ENODEV:
+			return nil
+		default:
+			return fmt.Errorf("reading from the FUSE device: %v", err)
+		}
+		if n < fuseInHeaderSize {
+			return fmt.Errorf("short FUSE request of %d bytes", n)
+		}
+		opcode := binary.NativeEndian.Uint32(buf[4:])
+		unique := binary.NativeEndian.Uint64(buf[8:])
+		node := binary.NativeEndian.Uint64(buf[16:])
+		if opcode == fuseDestroy {
+			m.reply(unique, 0, nil)
+			return nil
+		}
+		m.handle(opcode, unique, node, buf[fuseInHeaderSize:n])
+	}
+}
+
+// reply answers request unique with errno or, when it is 0, with payload
+func (m *fuseMount) reply(unique uint64, errno syscall.Errno, payload []byte) {
+	out := make([]byte, 16, 16+len(payload))
+	binary.NativeEndian.PutUint32(out[0:], uint32(16+len(payload)))
+	binary.NativeEndian.PutUint32(out[4:], uint32(-int32(errno)))
+	binary.NativeEndian.PutUint64(out[8:], unique)
+	if _, err := syscall.Write(int(m.dev.Fd()), append(out, payload...)); err != nil {
+		// ENOENT: the request was interrupted meanwhile
+		debug("FUSE reply to request %d failed: %v", unique, err)
+	}
+}
+
+// file returns the file of a node id, nil for the root and unknown ids
+func (m *fuseMount) file(node uint64) *mountedFile {
+	if node < 2 || node-2 >= uint64(len(m.files)) {
+		return nil
+	}
+	return &m.files[node-2]
+}
+
+// attr encodes the attributes of a node as struct fuse_attr
+func (m *fuseMount) attr(node uint64) []byte {
+	size, mode, nlink := uint64(0), uint32(syscall.S_IFDIR|0555), uint32(2)
+	if f := m.file(node); f != nil {
+		size, mode, nlink = uint64(len(f.data)), syscall.S_IFREG|0444, 1
+	}
+	sec, nsec := uint64(m.modified.Unix()), uint32(m.modified.Nanosecond())
+	b := binary.NativeEndian.AppendUint64(nil, node)
+	b = binary.NativeEndian.AppendUint64(b, size)
+	b = binary.NativeEndian.AppendUint64(b, (size+511)/512)
+	for i := 0; i < 3; i++ { // atime, mtime, ctime
+		b = binary.NativeEndian.AppendUint64(b, sec)
+	}
+	for i := 0; i < 3; i++ {
+		b = binary.NativeEndian.AppendUint32(b, nsec)
+	}
+	for _, v := range []uint32{mode, nlink, uint32(os.Getuid()), uint32(os.Getgid()), 0, 4096, 0} {
+		b = binary.NativeEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+func (m *fuseMount) handle(opcode uint32, unique, node uint64, in []byte) {
+	switch opcode {
+	case fuseInit:
+		m.reply(unique, 0, fuseInitReply(in))
+
+	case fuseForget, fuseBatchForget, fuseInterrupt:
+		// No reply expected
+
+	case fuseLookup:
+		name := string(in)
+		if i := bytes.IndexByte(in, 0); i >= 0 {
+			name = string(in[:i])
+		}
+		if node != fuseRootID {
+			m.reply(unique, syscall.ENOENT, nil)
+			return
+		}
+		for i, f := range m.files {
+			if f.name == name {
+				id := uint64(i + 2)
+				b := binary.NativeEndian.AppendUint64(nil, id)
+				b = binary.NativeEndian.AppendUint64(b, 0) // generation
+				b = binary.NativeEndian.AppendUint64(b, fuseTTL)
+				b = binary.NativeEndian.AppendUint64(b, fuseTTL)
+				b = binary.NativeEndian.AppendUint64(b, 0) // both nanoseconds
+				m.reply(unique, 0, append(b, m.attr(id)...))
+				return
+			}
+		}
+		m.reply(unique, syscall.ENOENT, nil)
+
+	case fuseGetattr:
+		if node != fuseRootID && m.file(node) == nil {
+			m.reply(unique, syscall.ENOENT, nil)
+			return
+		}
+		b := binary.NativeEndian.AppendUint64(nil, fuseTTL)
+		b = binary.NativeEndian.AppendUint64(b, 0) // nanoseconds and padding
+		m.reply(unique, 0, append(b, m.attr(node)...))
+
+	case fuseOpen, fuseOpendir:
+		isDir := node == fuseRootID
+		switch {
+		case !isDir && m.file(node) == nil:
+			m.reply(unique, syscall.ENOENT, nil)
+		case opcode == fuseOpen && isDir:
+			m.reply(unique, syscall.EISDIR, nil)
+		case opcode == fuseOpendir && !isDir:
+			m.reply(unique, syscall.ENOTDIR, nil)
+		case len(in) >= 4 && binary.NativeEndian.Uint32(in)&syscall.O_ACCMODE != syscall.O_RDONLY:
+			m.reply(unique, syscall.EROFS, nil)
+		default:
+			b := binary.NativeEndian.AppendUint64(nil, 0) // handle
+			m.reply(unique, 0, binary.NativeEndian.AppendUint64(b, fuseKeepCache))
+		}
+
+	case fuseRead:
+		f := m.file(node)
+		if f == nil || len(in) < 20 {
+			m.reply(unique, syscall.EBADF, nil)
+			return
+		}
+		offset := binary.NativeEndian.Uint64(in[8:])
+		size := uint64(binary.NativeEndian.Uint32(in[16:]))
+		if offset >= uint64(len(f.data)) {
+			m.reply(unique, 0, nil)
+			return
+		}
+		m.reply(unique, 0, f.data[offset:min(offset+size, uint64(len(f.data)))])
+
+	case fuseReaddir:
+		if node != fuseRootID || len(in) < 20 {
+			m.reply(unique, syscall.ENOTDIR, nil)
+			return
+		}
+		m.reply(unique, 0, m.readdir(binary.NativeEndian.Uint64(in[8:]), int(binary.NativeEndian.Uint32(in[16:]))))
+
+	case fuseRelease, fuseReleasedir, fuseFlush:
+		m.reply(unique, 0, nil)
+
+	case fuseAccess:
+		if len(in) >= 4 && binary.NativeEndian.Uint32(in)&2 != 0 { // W_OK
+			m.reply(unique, syscall.EROFS, nil)
+			return
+		}
+		m.reply(unique, 0, nil)
+
+	case fuseStatfs:
+		var b []byte
+		for i := 0; i < 5; i++ { // blocks, free, available, files, free files
+			b = binary.NativeEndian.AppendUint64(b, 0)
+		}
+		for _, v := range []uint32{4096, 255, 4096, 0, 0, 0, 0, 0, 0, 0} { // block size, name length, fragment size, padding, spare
+			b = binary.NativeEndian.AppendUint32(b, v)
+		}
+		m.reply(unique, 0, b)
+
+	default:
+		m.reply(unique, syscall.ENOSYS, nil)
+	}
+}
+
+// readdir encodes the root's entries from index offset on as struct
+// fuse_dirent records, as many as fit in size bytes
+func (m *fuseMount) readdir(offset uint64, size int) []byte {
+	type entry struct {
+		ino  uint64
+		name string
+		typ  uint32
+	}
+	entries := []entry{{fuseRootID, ".", syscall.DT_DIR}, {fuseRootID, "..", syscall.DT_DIR}}
+	for i, f := range m.files {
+		entries = append(entries, entry{uint64(i + 2), f.name, syscall.DT_REG})
+	}
+	var b []byte
+	for i := offset; i < uint64(len(entries)); i++ {
+		e := entries[i]
+		record := binary.NativeEndian.AppendUint64(nil, e.ino)
+		record = binary.NativeEndian.AppendUint64(record, i+1) // offset of the next entry
+		record = binary.NativeEndian.AppendUint32(record, uint32(len(e.name)))
+		record = binary.NativeEndian.AppendUint32(record, e.typ)
+		record = append(record, e.name...)
+		for len(record)%8 != 0 {
+			record = append(record, 0)
+		}
+		if len(b)+len(record) > size {
+			break
+		}
+		b = append(b, record...)
+	}
+	return b
+}
+
+// fuseInitReply negotiates protocol 7 at the kernel's minor version, up to
+// the 7.31 structure layout used here, without optional features
+func fuseInitReply(in []byte) []byte {
+	major, minor, readahead := uint32(7), uint32(31), uint32(0)
+	if len(in) >= 12 {
+		major = binary.NativeEndian.Uint32(in)
+		minor = min(minor, binary.NativeEndian.Uint32(in[4:]))
+		readahead = binary.NativeEndian.Uint32(in[8:])
+	}
+	b := binary.NativeEndian.AppendUint32(nil, 7)
+	if major != 7 {
+		// The kernel retries with major 7 when it supports it
+		return b
+	}
+	b = binary.NativeEndian.AppendUint32(b, minor)
+	b = binary.NativeEndian.AppendUint32(b, readahead)
+	b = binary.NativeEndian.AppendUint32(b, 0)  // flags
+	b = binary.NativeEndian.AppendUint16(b, 16) // max_background
+	b = binary.NativeEndian.AppendUint16(b, 12) // congestion_threshold
+	b = binary.NativeEndian.AppendUint32(b, fuseMaxWrite)
+	if minor < 23 {
+		return b
+	}
+	b = binary.NativeEndian.AppendUint32(b, 1) // time_gran
+	return append(b, make([]byte, 64-len(b)-4)...)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type fuseMount struct{}
+
+func mountFuse(mountPoint string) (*fuseMount, error) {
+	return nil, fmt.Errorf("mount needs FUSE, which gpx2gp supports on Linux only; use 'gpx2gp extract' instead")
+}
+
+func (m *fuseMount) serve(files []mountedFile, modified time.Time) error {
+	return nil
+}
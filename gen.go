@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Synthetic GPX containers with controlled layouts, for exercising the
+// reader without copyrighted tabs
+
+// GenFile is an inner file of a generated container
+type GenFile struct {
+	Name string
+	Data []byte
+}
+
+// GenOptions control the layout of a generated container
+type GenOptions struct {
+	Shuffle bool  // scatter data sectors instead of writing them in order
+	Seed    int64 // for Shuffle
+	Corrupt string
+}
+
+// genCorruptions are the damages GenOptions.Corrupt can apply to the
+// file headers
+var genCorruptions = map[string]string{
+	"out-of-range":  "a sector pointer past the end of the container",
+	"shared-sector": "the second file claims a sector of the first",
+	"bad-name":      "the file name contains control characters",
+	"size-mismatch": "the declared size needs more sectors than are listed",
+}
+
+// buildBcfs lays out an uncompressed container: an unused sector 0, then per
+// file a header (continued into following sectors for long lists) and its
+// data sectors
+func buildBcfs(files []GenFile, opts GenOptions) []byte {
+	type layout struct {
+		header  int
+		headers int
+		data    []int
+	}
+	layouts := make([]layout, len(files))
+	next := 1
+	for i, f := range files {
+		n := (len(f.Data) + sectorSize - 1) / sectorSize
+		l := layout{header: next, headers: (fileHeaderSize + 4*(n+1) + sectorSize - 1) / sectorSize}
+		next += l.headers
+		for k := 0; k < n; k++ {
+			l.data = append(l.data, next)
+			next++
+		}
+		layouts[i] = l
+	}
+
+	if opts.Shuffle {
+		// Permute the data sectors among themselves, headers stay put
+		var pool []int
+		for _, l := range layouts {
+			pool = append(pool, l.data...)
+		}
+		rand.New(rand.NewSource(opts.Seed)).Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+		for i := range layouts {
+			layouts[i].data, pool = pool[:len(layouts[i].data)], pool[len(layouts[i].data):]
+		}
+	}
+
+	out := make([]byte, next*sectorSize)
+	for i, f := range files {
+		l := layouts[i]
+		size := len(f.Data)
+		pointers := append([]int(nil), l.data...)
+		name := []byte(f.Name)
+		if i == 1 && opts.Corrupt == "shared-sector" && len(pointers) > 0 && len(layouts[0].data) > 0 {
+			pointers[0] = layouts[0].data[0]
+		}
+		if i == 0 {
+			switch opts.Corrupt {
+			case "out-of-range":
+				pointers = append(pointers, next+7)
+			case "bad-name":
+				name = append([]byte{'\x01'}, name...)
+			case "size-mismatch":
+				size += 2 * sectorSize
+			}
+		}
+
+		h := out[l.header*sectorSize:]
+		binary.LittleEndian.PutUint32(h, 2)
+		copy(h[0x04:0x04+127], name)
+		binary.LittleEndian.PutUint32(h[0x8c:], uint32(size))
+		for k, p := range pointers {
+			binary.LittleEndian.PutUint32(h[fileHeaderSize+4*k:], uint32(p))
+		}
+		for k, sector := range l.data {
+			copy(out[sector*sectorSize:(sector+1)*sectorSize], f.Data[k*sectorSize:])
+		}
+	}
+	return append([]byte("BCFS"), out...)
+}
+
+// BCFZ encoder limits: word sizes are 4 bits, so offsets and lengths fit
+// in 15 bits, and a back-reference never copies more than its offset
+const (
+	bcfzMaxWord  = 15
+	bcfzWindow   = 1<<bcfzMaxWord - 1
+	bcfzMinMatch = 4
+	bcfzChain    = 32
+)
+
+// bitWriter is the counterpart of BitReader, most significant bit first
+type bitWriter struct {
+	out   []byte
+	nbits int
+}
+
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(byte(v>>i) & 1)
+	}
+}
+
+func (w *bitWriter) writeBitsReversed(v uint64, n int) {
+	for i := 0; i < n; i++ {
+		w.writeBit(byte(v>>i) & 1)
+	}
+}
+
+func (w *bitWriter) writeBit(b byte) {
+	if w.nbits%8 == 0 {
+		w.out = append(w.out, 0)
+	}
+	w.out[len(w.out)-1] |= b << (7 - w.nbits%8)
+	w.nbits++
+}
+
+// compressBcfz encodes data as a BCFZ stream, header and length included.
+// Matching is greedy over a hash chain of 4 byte prefixes.
+func compressBcfz(data []byte) []byte {
+	w := &bitWriter{}
+	chains := make(map[uint32][]int)
+	prefix := func(i int) uint32 { return binary.LittleEndian.Uint32(data[i:]) }
+	remember := func(i int) {
+		if i+bcfzMinMatch <= len(data) {
+			p := prefix(i)
+			c := append(chains[p], i)
+			if len(c) > bcfzChain {
+				c = c[1:]
+			}
+			chains[p] = c
+		}
+	}
+
+	for i := 0; i < len(data); {
+		bestOffset, bestLength := 0, 0
+		if i+bcfzMinMatch <= len(data) {
+			c := chains[prefix(i)]
+			for k := len(c) - 1; k >= 0; k-- {
+				offset := i - c[k]
+				if offset > bcfzWindow {
+					break
+				}
+				length := 0
+				for length < offset && i+length < len(data) && data[c[k]+length] == data[i+length] {
+					length++
+				}
+				if length > bestLength {
+					bestOffset, bestLength = offset, length
+				}
+			}
+		}
+
+		if bestLength >= bcfzMinMatch {
+			word := bits.Len(uint(max(bestOffset, bestLength)))
+			w.writeBits(1, 1)
+			w.writeBits(uint64(word), 4)
+			w.writeBitsReversed(uint64(bestOffset), word)
+			w.writeBitsReversed(uint64(bestLength), word)
+			for k := 0; k < bestLength; k++ {
+				remember(i + k)
+			}
+			i += bestLength
+			continue
+		}
+
+		n := min(3, len(data)-i)
+		w.writeBits(0, 1)
+		w.writeBitsReversed(uint64(n), 2)
+		for k := 0; k < n; k++ {
+			w.writeBits(uint64(data[i+k]), 8)
+			remember(i + k)
+		}
+		i += n
+	}
+
+	out := make([]byte, 8, 8+len(w.out))
+	copy(out, "BCFZ")
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+	return append(out, w.out...)
+}
+
+// genCase is one generated container
+type genCase struct {
+	name        string
+	description string
+	data        []byte
+}
+
+// genTestdata builds the suite written by gen-testdata around the score of
+// the self-test fixture
+func genTestdata(large bool) ([]genCase, error) {
+	fixture := &GpxFileSystem{Limits: limits}
+	if err := fixture.Load(selftestGpx); err != nil {
+		return nil, err
+	}
+	score := fixture.File("score.gpif").Data
+	part := fixture.File("PartConfiguration").Data
+	song := []GenFile{{"score.gpif", score}, {"PartConfiguration", part}}
+	with := func(extra GenFile) []GenFile {
+		return append(append([]GenFile(nil), song...), extra)
+	}
+	// Random filler barely compresses, keeping large cases within the
+	// expansion limit
+	rng := rand.New(rand.NewSource(1))
+	filler := func(n int) []byte {
+		b := make([]byte, n)
+		rng.Read(b)
+		return b
+	}
+
+	bcfs := buildBcfs(song, GenOptions{})
+	bcfz := compressBcfz(bcfs)
+	cases := []genCase{
+		{"minimal-bcfs.gpx", "uncompressed container", bcfs},
+		{"minimal-bcfz.gpx", "compressed container", bcfz},
+		{"shuffled.gpx", "data sectors out of order", compressBcfz(buildBcfs(song, GenOptions{Shuffle: true, Seed: 1}))},
+		{"empty-file.gpx", "an inner file of 0 bytes", compressBcfz(buildBcfs(with(GenFile{"LayoutConfiguration", nil}), GenOptions{}))},
+		{"exact-sector.gpx", "an inner file of exactly one sector", compressBcfz(buildBcfs(with(GenFile{"BinaryStylesheet", filler(sectorSize)}), GenOptions{}))},
+		{"sector-plus-one.gpx", "an inner file one byte into its second sector", compressBcfz(buildBcfs(with(GenFile{"BinaryStylesheet", filler(sectorSize + 1)}), GenOptions{}))},
+		{"truncated.gpx", "compressed stream cut at two thirds", bcfz[:len(bcfz)*2/3]},
+		{"trailing-garbage.gpx", "junk after the last sector", append(append([]byte(nil), bcfs...), "junk appended by another tool"...)},
+	}
+	for _, name := range sortedKeys(genCorruptions) {
+		cases = append(cases, genCase{"corrupt-" + name + ".gpx", genCorruptions[name], buildBcfs(song, GenOptions{Corrupt: name})})
+	}
+	if large {
+		// Over 989 data sectors, the pointer list leaves the header sector
+		cases = append(cases, genCase{"long-sector-list.gpx", "a 4 MiB inner file whose sector list continues past its header", compressBcfz(buildBcfs(with(GenFile{"BinaryStylesheet", filler(4 << 20)}), GenOptions{}))})
+	}
+	return cases, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runGenTestdata is the hidden gen-testdata command
+func runGenTestdata(args []string) {
+	cmd := flag.NewFlagSet("gen-testdata", flag.ExitOnError)
+	var outputDir string
+	var large bool
+	cmd.StringVar(&outputDir, "o", "", "Output directory")
+	cmd.StringVar(&outputDir, "out", "", "Output directory")
+	cmd.BoolVar(&large, "large", false, "Also write containers of several MiB")
+	cmd.Parse(args)
+
+	if outputDir == "" {
+		fmt.Println("Usage: gpx2gp gen-testdata -o <dir> [-large]")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	cases, err := genTestdata(large)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, c := range cases {
+		if err := os.WriteFile(filepath.Join(outputDir, c.name), c.data, 0644); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%-28s %s\n", c.name, c.description)
+	}
+}
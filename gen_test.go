@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// genSong is the inner files of the self-test fixture
+func genSong(t *testing.T) []GenFile {
+	t.Helper()
+	fixture := &GpxFileSystem{Limits: limits}
+	if err := fixture.Load(selftestGpx); err != nil {
+		t.Fatal(err)
+	}
+	return []GenFile{
+		{"score.gpif", fixture.File("score.gpif").Data},
+		{"PartConfiguration", fixture.File("PartConfiguration").Data},
+	}
+}
+
+// filler is random data of n bytes
+func filler(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestGeneratedContainers(t *testing.T) {
+	song := genSong(t)
+	with := func(extra GenFile) []GenFile {
+		return append(append([]GenFile(nil), song...), extra)
+	}
+	bcfz := compressBcfz(buildBcfs(song, GenOptions{}))
+
+	// strict, normal and lenient start the error expected in each mode, ""
+	// for none; files are the inner files expected where it loads
+	tests := []struct {
+		name                    string
+		data                    []byte
+		files                   []GenFile
+		strict, normal, lenient string
+		warning                 string // a warning expected when not strict
+	}{
+		{name: "uncompressed", data: buildBcfs(song, GenOptions{}), files: song},
+		{name: "compressed", data: bcfz, files: song},
+		{name: "shuffled", data: compressBcfz(buildBcfs(song, GenOptions{Shuffle: true, Seed: 1})), files: song},
+		{name: "empty file", data: compressBcfz(buildBcfs(with(GenFile{"LayoutConfiguration", nil}), GenOptions{})),
+			files: with(GenFile{"LayoutConfiguration", nil})},
+		{name: "exact sector", data: compressBcfz(buildBcfs(with(GenFile{"BinaryStylesheet", filler(sectorSize)}), GenOptions{})),
+			files: with(GenFile{"BinaryStylesheet", filler(sectorSize)})},
+		{name: "sector plus one", data: compressBcfz(buildBcfs(with(GenFile{"BinaryStylesheet", filler(sectorSize + 1)}), GenOptions{})),
+			files: with(GenFile{"BinaryStylesheet", filler(sectorSize + 1)})},
+		{name: "long sector list", data: buildBcfs(with(GenFile{"BinaryStylesheet", filler(4 << 20)}), GenOptions{}),
+			files: with(GenFile{"BinaryStylesheet", filler(4 << 20)})},
+		{name: "truncated", data: bcfz[:len(bcfz)*2/3],
+			strict: "decompression failed", normal: "decompression failed", warning: "compressed stream ended early"},
+		{name: "trailing garbage", data: append(buildBcfs(song, GenOptions{}), "junk appended by another tool"...), files: song,
+			strict: "ignored 29 bytes of trailing data", warning: "ignored 29 bytes of trailing data"},
+		{name: "out of range", data: buildBcfs(song, GenOptions{Corrupt: "out-of-range"}),
+			strict: "score.gpif: sector 13 is out of range", normal: "score.gpif: sector 13 is out of range", warning: "is out of range"},
+		{name: "shared sector", data: buildBcfs(song, GenOptions{Corrupt: "shared-sector"}),
+			strict: "PartConfiguration: unreadable data", warning: "sector 2 belongs to score.gpif"},
+		{name: "bad name", data: buildBcfs(song, GenOptions{Corrupt: "bad-name"}),
+			strict: "file header at sector 1", normal: "file header at sector 1", warning: "rebuilt from 2 sectors"},
+		{name: "size mismatch", data: buildBcfs(song, GenOptions{Corrupt: "size-mismatch"}),
+			strict: "score.gpif: size of", normal: "score.gpif: size of", warning: "truncated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, m := range []struct {
+				mode ParseMode
+				err  string
+			}{{ParseStrict, tt.strict}, {ParseDefault, tt.normal}, {ParseLenient, tt.lenient}} {
+				fs := &GpxFileSystem{Limits: limits, Mode: m.mode}
+				err := fs.Load(tt.data)
+				switch {
+				case m.err != "" && (err == nil || !strings.HasPrefix(err.Error(), m.err)):
+					t.Errorf("mode %d: error %v, expected %q", m.mode, err, m.err)
+					continue
+				case m.err == "" && err != nil:
+					t.Errorf("mode %d: %v", m.mode, err)
+					continue
+				case err != nil:
+					continue
+				}
+				if tt.warning != "" && m.mode != ParseStrict && !strings.Contains(strings.Join(fs.Warnings, "\n"), tt.warning) {
+					t.Errorf("mode %d: warnings %q, expected %q", m.mode, fs.Warnings, tt.warning)
+				}
+				if tt.files == nil {
+					continue
+				}
+				if len(fs.Files) != len(tt.files) {
+					t.Errorf("mode %d: %d files, expected %d", m.mode, len(fs.Files), len(tt.files))
+					continue
+				}
+				for _, want := range tt.files {
+					if got := fs.File(want.Name); got == nil || !bytes.Equal(got.Data, want.Data) {
+						t.Errorf("mode %d: %s differs from the file generated", m.mode, want.Name)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestGenTestdata checks that every case gen-testdata writes is built
+func TestGenTestdata(t *testing.T) {
+	cases, err := genTestdata(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range cases {
+		if seen[c.name] || len(c.data) < 4 {
+			t.Errorf("%s: duplicate or empty", c.name)
+		}
+		seen[c.name] = true
+	}
+	for name := range genCorruptions {
+		if !seen["corrupt-"+name+".gpx"] {
+			t.Errorf("corruption %s has no case", name)
+		}
+	}
+}
@@ -1,3 +1,5 @@
 module github.com/appexcoda/gpx2gp
 
 go 1.25.4
+
+require golang.org/x/text v0.41.0
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GPIF score model (read-only subset of score.gpif used by the exporters)
+type Gpif struct {
+	XMLName     xml.Name        `xml:"GPIF"`
+	GPVersion   string          `xml:"GPVersion"`
+	GPRevision  GpifRevision    `xml:"GPRevision"`
+	Score       GpifScore       `xml:"Score"`
+	MasterTrack GpifMasterTrack `xml:"MasterTrack"`
+	Tracks      []GpifTrack     `xml:"Tracks>Track"`
+	MasterBars  []GpifMasterBar `xml:"MasterBars>MasterBar"`
+	Bars        []GpifBar       `xml:"Bars>Bar"`
+	Voices      []GpifVoice     `xml:"Voices>Voice"`
+	Beats       []GpifBeat      `xml:"Beats>Beat"`
+	Notes       []GpifNote      `xml:"Notes>Note"`
+	Rhythms     []GpifRhythm    `xml:"Rhythms>Rhythm"`
+
+	barByID    map[int]*GpifBar
+	voiceByID  map[int]*GpifVoice
+	beatByID   map[int]*GpifBeat
+	noteByID   map[int]*GpifNote
+	rhythmByID map[int]*GpifRhythm
+}
+
+// GpifRevision is the build that saved the score; the attributes, when
+// present, name the oldest builds able to open it
+type GpifRevision struct {
+	Value       string `xml:",chardata"`
+	Required    string `xml:"required,attr"`
+	Recommended string `xml:"recommended,attr"`
+}
+
+type GpifScore struct {
+	Title     string `xml:"Title"`
+	SubTitle  string `xml:"SubTitle"`
+	Artist    string `xml:"Artist"`
+	Album     string `xml:"Album"`
+	Words     string `xml:"Words"`
+	Music     string `xml:"Music"`
+	Copyright string `xml:"Copyright"`
+	Tabber    string `xml:"Tabber"`
+}
+
+type GpifMasterTrack struct {
+	Tracks      string           `xml:"Tracks"`
+	Automations []GpifAutomation `xml:"Automations>Automation"`
+}
+
+type GpifAutomation struct {
+	Type     string  `xml:"Type"`
+	Linear   bool    `xml:"Linear"`
+	Bar      int     `xml:"Bar"`
+	Position float64 `xml:"Position"`
+	Value    string  `xml:"Value"`
+}
+
+type GpifTrack struct {
+	ID          int             `xml:"id,attr"`
+	Name        string          `xml:"Name"`
+	ShortName   string          `xml:"ShortName"`
+	Instrument  GpifRef         `xml:"Instrument"`
+	GeneralMidi GpifGeneralMidi `xml:"GeneralMidi"`
+	Properties  []GpifProperty  `xml:"Properties>Property"`
+	Staves      []GpifStaff     `xml:"Staves>Staff"`
+	Lyrics      []GpifLyricLine `xml:"Lyrics>Line"`
+}
+
+type GpifStaff struct {
+	Properties []GpifProperty `xml:"Properties>Property"`
+}
+
+type GpifGeneralMidi struct {
+	Program        int `xml:"Program"`
+	PrimaryChannel int `xml:"PrimaryChannel"`
+}
+
+type GpifLyricLine struct {
+	Text   string `xml:"Text"`
+	Offset int    `xml:"Offset"`
+}
+
+type GpifRef struct {
+	Ref string `xml:"ref,attr"`
+}
+
+type GpifProperty struct {
+	Name      string    `xml:"name,attr"`
+	Pitches   string    `xml:"Pitches"`
+	Label     string    `xml:"Label"`
+	Fret      string    `xml:"Fret"`
+	String    string    `xml:"String"`
+	Number    string    `xml:"Number"`
+	Step      string    `xml:"Step"`
+	Element   string    `xml:"Element"`
+	Variation string    `xml:"Variation"`
+	Flags     string    `xml:"Flags"`
+	Enable    *struct{} `xml:"Enable"`
+}
+
+type GpifMasterBar struct {
+	Key              GpifKey        `xml:"Key"`
+	Time             string         `xml:"Time"`
+	Repeat           GpifRepeat     `xml:"Repeat"`
+	AlternateEndings string         `xml:"AlternateEndings"`
+	Section          *GpifSection   `xml:"Section"`
+	Directions       GpifDirections `xml:"Directions"`
+	Bars             string         `xml:"Bars"`
+	TripletFeel      string         `xml:"TripletFeel"`
+}
+
+type GpifKey struct {
+	AccidentalCount int    `xml:"AccidentalCount"`
+	Mode            string `xml:"Mode"`
+}
+
+type GpifRepeat struct {
+	Start bool `xml:"start,attr"`
+	End   bool `xml:"end,attr"`
+	Count int  `xml:"count,attr"`
+}
+
+type GpifSection struct {
+	Letter string `xml:"Letter"`
+	Text   string `xml:"Text"`
+}
+
+type GpifDirections struct {
+	Targets []string `xml:"Target"`
+	Jumps   []string `xml:"Jump"`
+}
+
+type GpifBar struct {
+	ID     int    `xml:"id,attr"`
+	Clef   string `xml:"Clef"`
+	Voices string `xml:"Voices"`
+}
+
+type GpifVoice struct {
+	ID    int    `xml:"id,attr"`
+	Beats string `xml:"Beats"`
+}
+
+type GpifBeat struct {
+	ID         int            `xml:"id,attr"`
+	Rhythm     GpifRef        `xml:"Rhythm"`
+	Notes      string         `xml:"Notes"`
+	GraceNotes string         `xml:"GraceNotes"`
+	Dynamic    string         `xml:"Dynamic"`
+	FreeText   string         `xml:"FreeText"`
+	Lyrics     []string       `xml:"Lyrics>Line"`
+	Properties []GpifProperty `xml:"Properties>Property"`
+}
+
+type GpifNote struct {
+	ID         int            `xml:"id,attr"`
+	Tie        GpifTie        `xml:"Tie"`
+	Vibrato    string         `xml:"Vibrato"`
+	LetRing    *struct{}      `xml:"LetRing"`
+	Accent     int            `xml:"Accent"`
+	AntiAccent string         `xml:"AntiAccent"`
+	Properties []GpifProperty `xml:"Properties>Property"`
+}
+
+type GpifTie struct {
+	Origin      bool `xml:"origin,attr"`
+	Destination bool `xml:"destination,attr"`
+}
+
+type GpifRhythm struct {
+	ID              int        `xml:"id,attr"`
+	NoteValue       string     `xml:"NoteValue"`
+	AugmentationDot GpifDot    `xml:"AugmentationDot"`
+	PrimaryTuplet   GpifTuplet `xml:"PrimaryTuplet"`
+	SecondaryTuplet GpifTuplet `xml:"SecondaryTuplet"`
+}
+
+type GpifDot struct {
+	Count int `xml:"count,attr"`
+}
+
+type GpifTuplet struct {
+	Num int `xml:"num,attr"`
+	Den int `xml:"den,attr"`
+}
+
+func parseGpif(data []byte) (*Gpif, error) {
+	g := &Gpif{}
+	decoder := newGpifDecoder(data)
+	if err := decoder.Decode(g); err != nil {
+		return nil, fmt.Errorf("failed to parse score.gpif: %v", err)
+	}
+
+	g.barByID = make(map[int]*GpifBar, len(g.Bars))
+	for i := range g.Bars {
+		g.barByID[g.Bars[i].ID] = &g.Bars[i]
+	}
+	g.voiceByID = make(map[int]*GpifVoice, len(g.Voices))
+	for i := range g.Voices {
+		g.voiceByID[g.Voices[i].ID] = &g.Voices[i]
+	}
+	g.beatByID = make(map[int]*GpifBeat, len(g.Beats))
+	for i := range g.Beats {
+		g.beatByID[g.Beats[i].ID] = &g.Beats[i]
+	}
+	g.noteByID = make(map[int]*GpifNote, len(g.Notes))
+	for i := range g.Notes {
+		g.noteByID[g.Notes[i].ID] = &g.Notes[i]
+	}
+	g.rhythmByID = make(map[int]*GpifRhythm, len(g.Rhythms))
+	for i := range g.Rhythms {
+		g.rhythmByID[g.Rhythms[i].ID] = &g.Rhythms[i]
+	}
+	return g, nil
+}
+
+// loadScore parses the score.gpif found in the container
+func (fs *GpxFileSystem) loadScore() (*Gpif, error) {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return nil, fs.missingScore()
+	}
+	return parseGpif(file.Data)
+}
+
+// Validate checks that every reference in the score resolves and that the
+// fields the timeline depends on are well formed
+func (g *Gpif) Validate() []string {
+	var problems []string
+	for i, mb := range g.MasterBars {
+		bars := parseIDs(mb.Bars)
+		if len(bars) != len(g.Tracks) {
+			problems = append(problems, fmt.Sprintf("master bar %d: references %d bars for %d tracks", i, len(bars), len(g.Tracks)))
+		}
+		for _, id := range bars {
+			if g.barByID[id] == nil {
+				problems = append(problems, fmt.Sprintf("master bar %d: unknown bar %d", i, id))
+			}
+		}
+		if !validTimeSignature(mb.Time) {
+			problems = append(problems, fmt.Sprintf("master bar %d: invalid time signature %q", i, mb.Time))
+		}
+	}
+	for _, bar := range g.Bars {
+		for _, id := range parseIDs(bar.Voices) {
+			if id >= 0 && g.voiceByID[id] == nil {
+				problems = append(problems, fmt.Sprintf("bar %d: unknown voice %d", bar.ID, id))
+			}
+		}
+	}
+	for _, voice := range g.Voices {
+		for _, id := range parseIDs(voice.Beats) {
+			if g.beatByID[id] == nil {
+				problems = append(problems, fmt.Sprintf("voice %d: unknown beat %d", voice.ID, id))
+			}
+		}
+	}
+	for _, beat := range g.Beats {
+		id, err := strconv.Atoi(beat.Rhythm.Ref)
+		if err != nil || g.rhythmByID[id] == nil {
+			problems = append(problems, fmt.Sprintf("beat %d: unknown rhythm %q", beat.ID, beat.Rhythm.Ref))
+		}
+		for _, id := range parseIDs(beat.Notes) {
+			if g.noteByID[id] == nil {
+				problems = append(problems, fmt.Sprintf("beat %d: unknown note %d", beat.ID, id))
+			}
+		}
+	}
+	for i := range g.Tracks {
+		if problem := g.checkTrackStrings(i); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+func validTimeSignature(s string) bool {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return false
+	}
+	num, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	den, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	return err1 == nil && err2 == nil && num > 0 && den > 0
+}
+
+// checkTrackStrings reports the first note played on a string the track's
+// tuning does not have; alphaTab fails to resolve the pitch of such notes
+func (g *Gpif) checkTrackStrings(track int) string {
+	tuning := g.Tracks[track].Tuning()
+	if len(tuning) == 0 {
+		return ""
+	}
+	for m := range g.MasterBars {
+		bar := g.TrackBar(m, track)
+		if bar == nil {
+			continue
+		}
+		for _, voice := range g.BarVoices(bar) {
+			for _, beat := range g.VoiceBeats(voice) {
+				for _, note := range g.BeatNotes(beat) {
+					if str, _, ok := note.StringFret(); ok && (str < 0 || str >= len(tuning)) {
+						return fmt.Sprintf("track %d: note %d on string %d, tuning has %d strings", track, note.ID, str, len(tuning))
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// validateScore parses and validates score.gpif. Unreadable scores count as
+// corruption, unresolved references as minor anomalies.
+func (fs *GpxFileSystem) validateScore() error {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return nil
+	}
+	g, err := parseGpif(file.Data)
+	if err != nil {
+		return fs.corrupt("%v", err)
+	}
+	for _, problem := range g.Validate() {
+		if err := fs.warn("score.gpif: %s", problem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readScore loads the GPX container and parses its score
+func readScore(inputPath string) (*GpxFileSystem, *Gpif, error) {
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	score, err := fs.loadScore()
+	if err != nil {
+		return nil, nil, err
+	}
+	return fs, score, nil
+}
+
+// SourceVersion describes the Guitar Pro release that saved the score,
+// e.g. "Guitar Pro 6 r12025"
+func (g *Gpif) SourceVersion() string {
+	version := strings.TrimSpace(g.GPVersion)
+	if version == "" {
+		return "unknown Guitar Pro version"
+	}
+	s := "Guitar Pro " + version
+	if rev := strings.TrimSpace(g.GPRevision.Value); rev != "" {
+		s += " r" + rev
+	}
+	if req := strings.TrimSpace(g.GPRevision.Required); req != "" {
+		s += ", needs r" + req
+	}
+	return s
+}
+
+// parseIDs splits a whitespace separated id list, as used by GPIF for references
+func parseIDs(s string) []int {
+	fields := strings.Fields(s)
+	ids := make([]int, 0, len(fields))
+	for _, f := range fields {
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func findProperty(props []GpifProperty, name string) *GpifProperty {
+	for i := range props {
+		if props[i].Name == name {
+			return &props[i]
+		}
+	}
+	return nil
+}
+
+func propertyInt(props []GpifProperty, name string, value func(p *GpifProperty) string) (int, bool) {
+	p := findProperty(props, name)
+	if p == nil {
+		return 0, false
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(value(p)))
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// trackProperties returns the properties holding tuning and capo. GP6 keeps
+// them on the track, GP7 on the first staff.
+func (t *GpifTrack) trackProperties() []GpifProperty {
+	if findProperty(t.Properties, "Tuning") == nil && len(t.Staves) > 0 {
+		return t.Staves[0].Properties
+	}
+	return t.Properties
+}
+
+// Tuning returns the string pitches, lowest string first
+func (t *GpifTrack) Tuning() []int {
+	p := findProperty(t.trackProperties(), "Tuning")
+	if p == nil {
+		return nil
+	}
+	return parseIDs(p.Pitches)
+}
+
+func (t *GpifTrack) Capo() int {
+	capo, _ := propertyInt(t.trackProperties(), "CapoFret", func(p *GpifProperty) string { return p.Fret })
+	return capo
+}
+
+func (t *GpifTrack) IsPercussion() bool {
+	ref := strings.ToLower(t.Instrument.Ref)
+	return t.GeneralMidi.PrimaryChannel == 9 || strings.Contains(ref, "drum") || strings.Contains(ref, "perc")
+}
+
+// IsVocal guesses whether the track carries the vocal line
+func (t *GpifTrack) IsVocal() bool {
+	ref := strings.ToLower(t.Instrument.Ref)
+	if strings.Contains(ref, "voc") || strings.Contains(ref, "voice") {
+		return true
+	}
+	if p := t.GeneralMidi.Program; p >= 52 && p <= 54 {
+		return true
+	}
+	name := strings.ToLower(t.Name)
+	for _, word := range []string{"vocal", "voice", "vox", "singer", "lead vox"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Track and voice navigation
+
+func (g *Gpif) TrackBar(masterBar, track int) *GpifBar {
+	if masterBar < 0 || masterBar >= len(g.MasterBars) {
+		return nil
+	}
+	ids := parseIDs(g.MasterBars[masterBar].Bars)
+	if track < 0 || track >= len(ids) {
+		return nil
+	}
+	return g.barByID[ids[track]]
+}
+
+func (g *Gpif) BarVoices(bar *GpifBar) []*GpifVoice {
+	var voices []*GpifVoice
+	for _, id := range parseIDs(bar.Voices) {
+		if v := g.voiceByID[id]; v != nil {
+			voices = append(voices, v)
+		}
+	}
+	return voices
+}
+
+func (g *Gpif) VoiceBeats(voice *GpifVoice) []*GpifBeat {
+	var beats []*GpifBeat
+	for _, id := range parseIDs(voice.Beats) {
+		if b := g.beatByID[id]; b != nil {
+			beats = append(beats, b)
+		}
+	}
+	return beats
+}
+
+func (g *Gpif) BeatNotes(beat *GpifBeat) []*GpifNote {
+	var notes []*GpifNote
+	for _, id := range parseIDs(beat.Notes) {
+		if n := g.noteByID[id]; n != nil {
+			notes = append(notes, n)
+		}
+	}
+	return notes
+}
+
+// Note properties
+
+func (n *GpifNote) StringFret() (int, int, bool) {
+	str, okString := propertyInt(n.Properties, "String", func(p *GpifProperty) string { return p.String })
+	fret, okFret := propertyInt(n.Properties, "Fret", func(p *GpifProperty) string { return p.Fret })
+	return str, fret, okString && okFret
+}
+
+func (n *GpifNote) HasProperty(name string) bool {
+	return findProperty(n.Properties, name) != nil
+}
+
+// noteTechniques maps note properties to the technique names used by exports
+var noteTechniques = []struct{ property, name string }{
+	{"PalmMuted", "palm_mute"},
+	{"Muted", "dead_note"},
+	{"HopoOrigin", "hammer_pull"},
+	{"Bended", "bend"},
+	{"Slide", "slide"},
+	{"HarmonicType", "harmonic"},
+	{"Tapped", "tap"},
+}
+
+func (n *GpifNote) Techniques() []string {
+	techniques := []string{}
+	for _, t := range noteTechniques {
+		if n.HasProperty(t.property) {
+			techniques = append(techniques, t.name)
+		}
+	}
+	if n.Vibrato != "" {
+		techniques = append(techniques, "vibrato")
+	}
+	if n.LetRing != nil {
+		techniques = append(techniques, "let_ring")
+	}
+	if n.Accent != 0 {
+		techniques = append(techniques, "accent")
+	}
+	if n.AntiAccent != "" {
+		techniques = append(techniques, "ghost")
+	}
+	if n.Tie.Origin {
+		techniques = append(techniques, "tied")
+	}
+	return techniques
+}
+
+// gp6DrumMap maps GP6 percussion element/variation pairs to General MIDI notes
+var gp6DrumMap = [][3]int{
+	{35, 35, 35}, // Kick (hit)
+	{38, 40, 37}, // Snare (hit, rim shot, side stick)
+	{56, 56, 56}, // Cowbell low
+	{56, 56, 56}, // Cowbell medium
+	{56, 56, 56}, // Cowbell high
+	{43, 43, 43}, // Tom very low
+	{45, 45, 45}, // Tom low
+	{47, 47, 47}, // Tom medium
+	{48, 48, 48}, // Tom high
+	{50, 50, 50}, // Tom very high
+	{42, 46, 46}, // Hihat (closed, half, open)
+	{44, 44, 44}, // Pedal hihat
+	{57, 57, 57}, // Crash medium
+	{49, 49, 49}, // Crash high
+	{55, 55, 55}, // Splash
+	{51, 59, 53}, // Ride (middle, edge, bell)
+	{52, 52, 52}, // China
+}
+
+// Pitch returns the sounding MIDI pitch of a note played on the given track
+func (n *GpifNote) Pitch(track *GpifTrack) (int, bool) {
+	if midi, ok := propertyInt(n.Properties, "Midi", func(p *GpifProperty) string { return p.Number }); ok {
+		return midi, true
+	}
+	if element, ok := propertyInt(n.Properties, "Element", func(p *GpifProperty) string { return p.Element }); ok {
+		variation, _ := propertyInt(n.Properties, "Variation", func(p *GpifProperty) string { return p.Variation })
+		if element >= 0 && element < len(gp6DrumMap) && variation >= 0 && variation < 3 {
+			return gp6DrumMap[element][variation], true
+		}
+		return 0, false
+	}
+	if str, fret, ok := n.StringFret(); ok {
+		tuning := track.Tuning()
+		if str < 0 || str >= len(tuning) {
+			return 0, false
+		}
+		return tuning[str] + fret + track.Capo(), true
+	}
+	octave, okOctave := propertyInt(n.Properties, "Octave", func(p *GpifProperty) string { return p.Number })
+	tone, okTone := propertyInt(n.Properties, "Tone", func(p *GpifProperty) string { return p.Step })
+	if okOctave && okTone {
+		return octave*12 + tone, true
+	}
+	return 0, false
+}
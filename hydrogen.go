@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Hydrogen song export. Instruments follow the GM ordered layout of
+// Hydrogen's default GMRockKit, so loading that kit gives the right sounds.
+const hydrogenTicksPerQuarter = 48
+
+var hydrogenInstruments = []string{
+	"Kick", "Stick", "Snare Jazz", "Hand Clap", "Snare Rock", "Tom Low", "Closed HH", "Tom Mid",
+	"Pedal HH", "Tom Hi", "Open HH", "Cowbell", "Ride Jazz", "Crash", "Ride Rock", "Crash Jazz",
+}
+
+// hydrogenInstrument maps a General MIDI drum note to a GMRockKit instrument
+func hydrogenInstrument(pitch int) (int, bool) {
+	switch {
+	case pitch >= 36 && pitch <= 51:
+		return pitch - 36, true
+	case pitch == 35:
+		return 0, true
+	case pitch == 52 || pitch == 57:
+		return 13, true
+	case pitch == 53 || pitch == 59:
+		return 14, true
+	case pitch == 55:
+		return 15, true
+	case pitch == 56:
+		return 11, true
+	}
+	return 0, false
+}
+
+type h2Song struct {
+	XMLName      xml.Name        `xml:"song"`
+	Version      string          `xml:"version"`
+	Bpm          string          `xml:"bpm"`
+	Volume       string          `xml:"volume"`
+	Name         string          `xml:"name"`
+	Author       string          `xml:"author"`
+	Notes        string          `xml:"notes"`
+	Mode         string          `xml:"mode"`
+	Instruments  []h2Instrument  `xml:"instrumentList>instrument"`
+	Patterns     []h2Pattern     `xml:"patternList>pattern"`
+	Sequence     []h2Group       `xml:"patternSequence>group"`
+	TempoChanges []h2TempoChange `xml:"BPMTimeLine>newBPM"`
+	Tags         []h2Tag         `xml:"timeLineTag>newTAG"`
+}
+
+type h2Instrument struct {
+	ID       int    `xml:"id"`
+	Name     string `xml:"name"`
+	Volume   string `xml:"volume"`
+	IsMuted  bool   `xml:"isMuted"`
+	PanL     string `xml:"pan_L"`
+	PanR     string `xml:"pan_R"`
+	MidiNote int    `xml:"midiOutNote"`
+}
+
+type h2Pattern struct {
+	Name        string   `xml:"name"`
+	Info        string   `xml:"info"`
+	Category    string   `xml:"category"`
+	Size        int      `xml:"size"`
+	Denominator int      `xml:"denominator"`
+	Notes       []h2Note `xml:"noteList>note"`
+}
+
+type h2Note struct {
+	Position   int    `xml:"position"`
+	LeadLag    string `xml:"leadlag"`
+	Velocity   string `xml:"velocity"`
+	PanL       string `xml:"pan_L"`
+	PanR       string `xml:"pan_R"`
+	Pitch      string `xml:"pitch"`
+	Key        string `xml:"key"`
+	Length     int    `xml:"length"`
+	Instrument int    `xml:"instrument"`
+	NoteOff    bool   `xml:"note_off"`
+}
+
+type h2Group struct {
+	PatternID string `xml:"patternID"`
+}
+
+type h2TempoChange struct {
+	Bar int    `xml:"BAR"`
+	Bpm string `xml:"BPM"`
+}
+
+type h2Tag struct {
+	Bar int    `xml:"BAR"`
+	Tag string `xml:"TAG"`
+}
+
+// firstPercussionTrack returns the first drum track, or -1
+func (g *Gpif) firstPercussionTrack() int {
+	for i := range g.Tracks {
+		if g.Tracks[i].IsPercussion() {
+			return i
+		}
+	}
+	return -1
+}
+
+// hydrogenSong turns every played bar of the drum track into a pattern,
+// identical bars share one pattern, and sequences them in playback order
+func (g *Gpif) hydrogenSong(tl *Timeline, track int) (*h2Song, int) {
+	song := &h2Song{
+		Version: "1.2.0",
+		Bpm:     formatBpm(tl.Tempos[0].BPM),
+		Volume:  "0.5",
+		Name:    strings.TrimSpace(g.Score.Title),
+		Author:  strings.TrimSpace(g.Score.Artist),
+		Notes:   "Converted by gpx2gp. Load the GMRockKit drumkit for matching sounds.",
+		Mode:    "song",
+	}
+	for i, name := range hydrogenInstruments {
+		song.Instruments = append(song.Instruments, h2Instrument{ID: i, Name: name, Volume: "1", PanL: "1", PanR: "1", MidiNote: 36 + i})
+	}
+
+	notes := g.TrackNotes(tl, track)
+	unmapped := 0
+	patterns := make(map[string]string)
+	tempos := tl.Tempos[1:]
+	for column, tb := range tl.Bars {
+		num, den := g.MasterBars[tb.Index].TimeSignature()
+		pattern := h2Pattern{
+			Category:    "gpx2gp",
+			Size:        num * hydrogenTicksPerQuarter * 4 / den,
+			Denominator: den,
+		}
+		for len(notes) > 0 && notes[0].Tick < tb.Tick+tb.Ticks {
+			n := notes[0]
+			notes = notes[1:]
+			instrument, ok := hydrogenInstrument(n.Pitch)
+			if !ok {
+				unmapped++
+				continue
+			}
+			pattern.Notes = append(pattern.Notes, h2Note{
+				Position:   (n.Tick - tb.Tick) * hydrogenTicksPerQuarter / ticksPerQuarter,
+				LeadLag:    "0",
+				Velocity:   strconv.FormatFloat(float64(n.Velocity)/127, 'f', 2, 64),
+				PanL:       "0.5",
+				PanR:       "0.5",
+				Pitch:      "0",
+				Key:        "C0",
+				Length:     -1,
+				Instrument: instrument,
+			})
+		}
+
+		key := fmt.Sprintf("%d/%d %v", pattern.Size, den, pattern.Notes)
+		name, ok := patterns[key]
+		if !ok {
+			name = fmt.Sprintf("Pattern %d", len(song.Patterns)+1)
+			pattern.Name = name
+			patterns[key] = name
+			song.Patterns = append(song.Patterns, pattern)
+		}
+		song.Sequence = append(song.Sequence, h2Group{PatternID: name})
+
+		for len(tempos) > 0 && tempos[0].Tick < tb.Tick+tb.Ticks {
+			song.TempoChanges = append(song.TempoChanges, h2TempoChange{Bar: column, Bpm: formatBpm(tempos[0].BPM)})
+			tempos = tempos[1:]
+		}
+		if s := g.MasterBars[tb.Index].Section; s != nil && s.Name() != "" {
+			song.Tags = append(song.Tags, h2Tag{Bar: column, Tag: s.Name()})
+		}
+	}
+	return song, unmapped
+}
+
+func writeHydrogenSong(w io.Writer, song *h2Song) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(song); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func runExportHydrogen(args []string) {
+	cmd := flag.NewFlagSet("export hydrogen", flag.ExitOnError)
+	var inputPath, outputPath string
+	var trackIndex int
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output .h2song file")
+	cmd.StringVar(&outputPath, "out", "", "Output .h2song file")
+	cmd.IntVar(&trackIndex, "track", -1, "Drum track index (default: first percussion track)")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" || outputPath == "" {
+		fmt.Println("Usage: gpx2gp export hydrogen -f <input.gpx> -o <output.h2song> [-track N]")
+		os.Exit(1)
+	}
+	outputPath = withExtension(outputPath, ".h2song")
+	if err := checkOutputPath(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if trackIndex < 0 {
+		trackIndex = score.firstPercussionTrack()
+	}
+	if trackIndex < 0 || trackIndex >= len(score.Tracks) {
+		fmt.Println("Error: score has no drum track.")
+		os.Exit(1)
+	}
+
+	song, unmapped := score.hydrogenSong(score.BuildTimeline(), trackIndex)
+	if unmapped > 0 {
+		fmt.Printf("Warning: %d notes use percussion sounds without a GMRockKit instrument and were dropped.\n", unmapped)
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer out.Close()
+	if err := writeHydrogenSong(out, song); err != nil {
+		fmt.Printf("Error writing Hydrogen song: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s: %d patterns over %d bars.\n", outputPath, len(song.Patterns), len(song.Sequence))
+}
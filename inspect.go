@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func runInspect(args []string) {
+	cmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+	var inputPath string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" && cmd.NArg() > 0 {
+		inputPath = cmd.Arg(0)
+	}
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp inspect -f <input.gpx>")
+		os.Exit(1)
+	}
+
+	parseMode = ParseLenient
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("File:      %s\n", inputPath)
+	fmt.Printf("SHA-256:   %s\n", fs.Source)
+	fmt.Printf("Source:    %s\n", fs.SourceVersion())
+	if score, err := fs.loadScore(); err == nil {
+		fmt.Printf("Title:     %s\n", strings.TrimSpace(score.Score.Title))
+		fmt.Printf("Artist:    %s\n", strings.TrimSpace(score.Score.Artist))
+		fmt.Printf("Tracks:    %d\n", len(score.Tracks))
+		fmt.Printf("Bars:      %d\n", len(score.MasterBars))
+		tl := score.BuildTimeline()
+		fmt.Printf("Duration:  %s (%d bars played)\n", formatClock(tl.Duration()), len(tl.Bars))
+		if key, ok := score.EstimateKey(); ok {
+			line := fmt.Sprintf("%s (estimated, confidence %.2f)", key.Name(), key.Confidence)
+			if len(score.MasterBars) > 0 {
+				line += ", written " + score.MasterBars[0].Key.Name()
+			}
+			fmt.Printf("Key:       %s\n", line)
+		}
+	}
+	fmt.Printf("Files:\n")
+	for _, f := range fs.Files {
+		fmt.Printf("  %10d  %s\n", f.FileSize, f.FileName)
+	}
+	for _, w := range fs.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// The mirror journal records progress as the run goes: every source that
+// was converted, found touched but unchanged, or pruned is appended as one
+// JSON line and synced to disk before the next one starts. The state file
+// is only written at the end, so after a crash or Ctrl-C the journal is
+// what remains, and -resume replays it and skips those sources without
+// looking at them again. A finished run removes the journal.
+
+// mirrorJournalName is the journal kept next to the state file
+const mirrorJournalName = ".gpx2gp-journal.jsonl"
+
+// journalEntry is one line of the journal
+type journalEntry struct {
+	Source string        `json:"source"`
+	Record *mirrorSource `json:"record,omitempty"` // nil when the output was pruned
+}
+
+// mirrorJournal appends entries to the journal file
+type mirrorJournal struct {
+	f *os.File
+}
+
+// replayJournal applies the entries of an interrupted run to state and
+// returns the sources they cover. A torn last line, written when the run
+// was cut off, is ignored.
+func replayJournal(path string, state mirrorState) (map[string]bool, error) {
+	done := make(map[string]bool)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Source == "" {
+			debug("%s: skipping unreadable line %d", path, line)
+			continue
+		}
+		if e.Record != nil {
+			state[e.Source] = *e.Record
+		} else {
+			delete(state, e.Source)
+		}
+		done[e.Source] = true
+	}
+	return done, scanner.Err()
+}
+
+// openJournal starts appending to the journal at path, keeping what an
+// interrupted run wrote when resuming and discarding it otherwise
+func openJournal(path string, resume bool) (*mirrorJournal, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorJournal{f: f}, nil
+}
+
+// record appends an entry and waits until it is on disk
+func (j *mirrorJournal) record(source string, record *mirrorSource) error {
+	data, err := json.Marshal(journalEntry{Source: source, Record: record})
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %v", err)
+	}
+	return j.f.Sync()
+}
+
+// finish closes the journal and removes it, once the state file holds
+// everything it recorded
+func (j *mirrorJournal) finish() error {
+	j.f.Close()
+	return os.Remove(j.f.Name())
+}
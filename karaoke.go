@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// runKaraoke writes the .gp, the timed lyrics (.lrc) and a backing MIDI
+// without the vocal track side by side, for singers practicing along
+func runKaraoke(args []string) {
+	cmd := flag.NewFlagSet("karaoke", flag.ExitOnError)
+	var inputPath, outputPath string
+	var vocalTrack int
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output base filename")
+	cmd.StringVar(&outputPath, "out", "", "Output base filename")
+	cmd.IntVar(&vocalTrack, "vocal", -1, "Index of the vocal track to remove (default: detect)")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" || outputPath == "" {
+		fmt.Println("Usage: gpx2gp karaoke -f <input.gpx> -o <output_basename> [-vocal N] [-v]")
+		os.Exit(1)
+	}
+
+	base := outputPath
+	if strings.HasSuffix(strings.ToLower(base), ".gp") {
+		base = base[:len(base)-3]
+	}
+	gpPath, lrcPath, midPath := base+".gp", base+".lrc", base+".mid"
+	for _, p := range []string{gpPath, lrcPath, midPath} {
+		if err := checkOutputPath(inputPath, p); err != nil {
+			fmt.Printf("Error: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+
+	start := time.Now()
+	fmt.Printf("Reading: %s\n", inputPath)
+
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, _, err := fs.migrateScore(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	score, err := fs.loadScore()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if vocalTrack >= len(score.Tracks) {
+		fmt.Printf("Error: vocal track %d out of range (score has %d tracks).\n", vocalTrack, len(score.Tracks))
+		os.Exit(1)
+	}
+
+	lyricsTrack := score.LyricsTrack()
+	if vocalTrack < 0 {
+		vocalTrack = lyricsTrack
+		for i := range score.Tracks {
+			if score.Tracks[i].IsVocal() {
+				vocalTrack = i
+				break
+			}
+		}
+	}
+	if vocalTrack >= 0 {
+		fmt.Printf("Removing vocal track %d (%s) from backing MIDI.\n", vocalTrack, score.Tracks[vocalTrack].Name)
+	} else {
+		fmt.Println("Warning: no vocal track detected, backing MIDI keeps every track.")
+	}
+
+	tl := score.BuildTimeline()
+
+	var syllables []TimedSyllable
+	if lyricsTrack >= 0 {
+		syllables = score.TimedLyrics(tl, lyricsTrack)
+	}
+	if len(syllables) == 0 {
+		fmt.Println("Warning: score has no lyrics, writing an empty .lrc.")
+	}
+
+	written := []string{}
+	fail := func(err error) {
+		fmt.Printf("Error creating karaoke bundle: %v\n", err)
+		for _, p := range written {
+			os.Remove(p)
+		}
+		os.Exit(1)
+	}
+
+	written = append(written, gpPath)
+	if err := createGpArchive(gpPath, fs); err != nil {
+		fail(err)
+	}
+	if err := conformTarget(gpPath); err != nil {
+		fail(err)
+	}
+
+	written = append(written, lrcPath)
+	lrc, err := os.Create(lrcPath)
+	if err != nil {
+		fail(err)
+	}
+	err = writeLrc(lrc, &score.Score, syllables)
+	lrc.Close()
+	if err != nil {
+		fail(err)
+	}
+
+	written = append(written, midPath)
+	mid, err := os.Create(midPath)
+	if err != nil {
+		fail(err)
+	}
+	tracks := append([]*MidiTrack{score.conductorTrack(tl)}, score.noteTracks(tl, func(i int) bool { return i != vocalTrack })...)
+	err = writeMidiFile(mid, tracks)
+	mid.Close()
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("Wrote %s, %s (%d syllables) and %s.\n", gpPath, lrcPath, len(syllables), midPath)
+	fmt.Printf("Success! Converted in %v.\n", time.Since(start))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Key detection. Guitar Pro 6 files often leave the key signature at C
+// major whatever the song is in, so the key is estimated from the notes:
+// the time each pitch class sounds is correlated with the Krumhansl-Kessler
+// profile of every major and minor key, and the best match wins.
+
+var majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+var minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+
+// Tonic spellings by pitch class, as the key signatures spell them
+var majorTonics = [12]string{"C", "Db", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+var minorTonics = [12]string{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "G#", "A", "Bb", "B"}
+
+// KeyEstimate is the key the notes of a score suggest
+type KeyEstimate struct {
+	Tonic      int     `json:"tonic"` // pitch class, 0 is C
+	Minor      bool    `json:"minor"`
+	Confidence float64 `json:"confidence"` // correlation with the key's profile, from 0 to 1
+}
+
+// Name spells the key, e.g. "F# minor"
+func (k KeyEstimate) Name() string {
+	if k.Minor {
+		return minorTonics[k.Tonic] + " minor"
+	}
+	return majorTonics[k.Tonic] + " major"
+}
+
+// Tonic returns the pitch class of the key signature's tonic and whether
+// it is minor
+func (k GpifKey) Tonic() (int, bool) {
+	tonic := ((k.AccidentalCount*7)%12 + 12) % 12
+	if strings.EqualFold(strings.TrimSpace(k.Mode), "minor") {
+		return (tonic + 9) % 12, true
+	}
+	return tonic, false
+}
+
+// correlation is Pearson's r of two pitch class profiles
+func correlation(a, b [12]float64) float64 {
+	var meanA, meanB float64
+	for i := range a {
+		meanA += a[i] / 12
+		meanB += b[i] / 12
+	}
+	var cov, varA, varB float64
+	for i := range a {
+		da, db := a[i]-meanA, b[i]-meanB
+		cov += da * db
+		varA += da * da
+		varB += db * db
+	}
+	if varA == 0 || varB == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varA*varB)
+}
+
+// EstimateKey estimates the key from the pitched notes of all tracks,
+// weighted by how long they sound. It fails for scores without pitched
+// notes.
+func (g *Gpif) EstimateKey() (KeyEstimate, bool) {
+	var histogram [12]float64
+	tl := g.BuildTimeline()
+	notes := 0
+	for t := range g.Tracks {
+		if g.Tracks[t].IsPercussion() {
+			continue
+		}
+		for _, n := range g.TrackNotes(tl, t) {
+			histogram[((n.Pitch%12)+12)%12] += float64(n.Ticks)
+			notes++
+		}
+	}
+	if notes == 0 {
+		return KeyEstimate{}, false
+	}
+
+	best := KeyEstimate{Confidence: -2}
+	for tonic := 0; tonic < 12; tonic++ {
+		var rotated [12]float64
+		for i := range histogram {
+			rotated[i] = histogram[(i+tonic)%12]
+		}
+		for _, minor := range []bool{false, true} {
+			profile := majorProfile
+			if minor {
+				profile = minorProfile
+			}
+			if r := correlation(rotated, profile); r > best.Confidence {
+				best = KeyEstimate{Tonic: tonic, Minor: minor, Confidence: r}
+			}
+		}
+	}
+	best.Confidence = math.Round(max(best.Confidence, 0)*100) / 100
+	return best, true
+}
+
+// KeyReport compares the estimated and written keys of a library
+type KeyReport struct {
+	Songs []KeyReportSong `json:"songs"`
+}
+
+// KeyReportSong is one line of the key report
+type KeyReportSong struct {
+	File      string       `json:"file"`
+	Title     string       `json:"title"`
+	Written   string       `json:"written"`
+	Estimated *KeyEstimate `json:"estimated"` // nil without pitched notes
+	Key       string       `json:"key"`       // name of the estimated key
+	Differs   bool         `json:"differs"`   // from the written key
+}
+
+func (r *KeyReport) add(path string, score *Gpif) {
+	song := KeyReportSong{File: path, Title: strings.TrimSpace(score.Score.Title), Written: "none"}
+	if len(score.MasterBars) > 0 {
+		song.Written = score.MasterBars[0].Key.Name()
+	}
+	if key, ok := score.EstimateKey(); ok {
+		song.Estimated = &key
+		song.Key = key.Name()
+		if len(score.MasterBars) > 0 {
+			tonic, minor := score.MasterBars[0].Key.Tonic()
+			song.Differs = tonic != key.Tonic || minor != key.Minor
+		}
+	}
+	r.Songs = append(r.Songs, song)
+}
+
+func (r *KeyReport) writeText(w io.Writer) {
+	differ := 0
+	for _, s := range r.Songs {
+		name := s.File
+		if s.Title != "" {
+			name = s.Title + " (" + s.File + ")"
+		}
+		if s.Estimated == nil {
+			fmt.Fprintf(w, "%s: no pitched notes, written %s\n", name, s.Written)
+			continue
+		}
+		line := fmt.Sprintf("%s: %s, confidence %.2f", name, s.Key, s.Estimated.Confidence)
+		if s.Differs {
+			line += ", written " + s.Written
+			differ++
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "\n%s, %d with a written key that differs from the estimate\n", plural(len(r.Songs), "song"), differ)
+}
+
+func runReportKeys(args []string) {
+	cmd := flag.NewFlagSet("report keys", flag.ExitOnError)
+	var outputPath string
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	asJSON := cmd.Bool("json", false, "Write the report as JSON")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	report := KeyReport{Songs: []KeyReportSong{}}
+	reportScores(cmd.Args(), report.add)
+	writeReport(outputPath, *asJSON, report, report.writeText)
+}
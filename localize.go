@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Localization of the readable text in a score. export texts lists it in a
+// translation file, each text under an id naming where it sits; a
+// conversion with -translate writes the translated texts back into
+// score.gpif. Lyrics are left alone, a translation would not fit the
+// notes they are sung on.
+
+// TextItem is one translatable text of a score
+type TextItem struct {
+	ID          string `json:"id"`
+	Source      string `json:"source"`
+	Translation string `json:"translation"`
+}
+
+// TranslationFile is what export texts writes and -translate reads
+type TranslationFile struct {
+	Texts []TextItem `json:"texts"`
+}
+
+// translation holds the texts of -translate, translationSum the hash of
+// its file, which is part of the cache key
+var (
+	translation    []TextItem
+	translationSum string
+)
+
+// translatableScoreFields are the Score elements holding prose rather than
+// names
+var translatableScoreFields = map[string]bool{
+	"Title": true, "SubTitle": true, "Album": true, "Copyright": true, "Instructions": true, "Notices": true,
+}
+
+// textLocator follows a token stream and names the translatable text
+// under the current element
+type textLocator struct {
+	track string // id of the current track
+	bar   int    // number of the current master bar, from 1
+	beat  string // id of the current beat
+}
+
+func attrValue(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// see takes the next token, returning the text id when it is the content
+// of a translatable element
+func (l *textLocator) see(stack []string, tok xml.Token) string {
+	switch t := tok.(type) {
+	case xml.StartElement:
+		switch {
+		case t.Name.Local == "Track" && inside(stack, "Tracks"):
+			l.track = attrValue(t, "id")
+		case t.Name.Local == "MasterBar" && inside(stack, "MasterBars"):
+			l.bar++
+		case t.Name.Local == "Beat" && inside(stack, "Beats"):
+			l.beat = attrValue(t, "id")
+		}
+	case xml.CharData:
+		switch {
+		case len(stack) >= 2 && stack[len(stack)-2] == "Score" && translatableScoreFields[stack[len(stack)-1]]:
+			return "score/" + strings.ToLower(stack[len(stack)-1])
+		case inside(stack, "Tracks", "Track", "Name"):
+			return "track/" + l.track + "/name"
+		case inside(stack, "Tracks", "Track", "ShortName"):
+			return "track/" + l.track + "/shortname"
+		case inside(stack, "MasterBar", "Section", "Text"):
+			return "bar/" + strconv.Itoa(l.bar) + "/section"
+		case inside(stack, "Beat", "FreeText"):
+			return "beat/" + l.beat + "/text"
+		}
+	}
+	return ""
+}
+
+// extractTexts lists the translatable texts of a score in document order
+func extractTexts(gpif []byte) ([]TextItem, error) {
+	items := []TextItem{}
+	var l textLocator
+	_, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		if id := l.see(stack, tok); id != "" {
+			if text := strings.TrimSpace(string(tok.(xml.CharData))); text != "" {
+				items = append(items, TextItem{ID: id, Source: text})
+			}
+		}
+		return []xml.Token{tok}
+	})
+	return items, err
+}
+
+// translateScore writes the translations into a score. Texts whose source
+// no longer matches the score are left untranslated and reported, as are
+// ids the score does not have.
+func translateScore(gpif []byte, texts []TextItem) ([]byte, int, []string, error) {
+	pending := make(map[string]TextItem)
+	for _, t := range texts {
+		if strings.TrimSpace(t.Translation) != "" {
+			pending[t.ID] = t
+		}
+	}
+	var problems []string
+	translated := 0
+	var l textLocator
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		id := l.see(stack, tok)
+		t, ok := pending[id]
+		if id == "" || !ok {
+			return []xml.Token{tok}
+		}
+		delete(pending, id)
+		if current := strings.TrimSpace(string(tok.(xml.CharData))); current != strings.TrimSpace(t.Source) {
+			problems = append(problems, fmt.Sprintf("translation of %s is out of date: the score now reads %q, not %q", id, current, t.Source))
+			return []xml.Token{tok}
+		}
+		translated++
+		return []xml.Token{xml.CharData(t.Translation)}
+	})
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	for _, t := range texts {
+		if _, ok := pending[t.ID]; ok {
+			problems = append(problems, fmt.Sprintf("translation of %s has no text to replace in the score", t.ID))
+		}
+	}
+	return out, translated, problems, nil
+}
+
+// loadTranslation reads a translation file, returning its texts and the
+// SHA-256 of the file for the cache key
+func loadTranslation(path string) ([]TextItem, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var file TranslationFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, "", fmt.Errorf("%s: not a translation file: %v", path, err)
+	}
+	sum := sha256.Sum256(data)
+	return file.Texts, hex.EncodeToString(sum[:]), nil
+}
+
+// translate replaces score.gpif with its translation, recording stale or
+// unmatched texts as warnings
+func (fs *GpxFileSystem) translate(texts []TextItem) (int, error) {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return 0, fs.missingScore()
+	}
+	data, n, problems, err := translateScore(file.Data, texts)
+	if err != nil {
+		return 0, fmt.Errorf("translating score.gpif: %v", err)
+	}
+	for _, p := range problems {
+		if err := fs.warn("%s", p); err != nil {
+			return 0, err
+		}
+	}
+	file.Data, file.FileSize = data, len(data)
+	return n, nil
+}
+
+func runExportTexts(args []string) {
+	cmd := flag.NewFlagSet("export texts", flag.ExitOnError)
+	var inputPath, outputPath string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp export texts -f <input.gpx> [-o <texts.json>]")
+		os.Exit(1)
+	}
+	if err := checkExportOutput(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	file := fs.File("score.gpif")
+	if file == nil {
+		fmt.Printf("Error: %v\n", fs.missingScore())
+		os.Exit(1)
+	}
+	texts, err := extractTexts(file.Data)
+	if err != nil {
+		fmt.Printf("Error: score.gpif: %v\n", err)
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(TranslationFile{Texts: texts})
+
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		fmt.Printf("Error writing texts: %v\n", err)
+		os.Exit(1)
+	}
+}
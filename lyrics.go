@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Syllable is one lyric token sung on a beat
+type Syllable struct {
+	Text     string
+	Hyphen   bool // word continues on the next syllable
+	LineHead bool // first syllable of a lyric line
+}
+
+// TimedSyllable is a syllable placed on the timeline
+type TimedSyllable struct {
+	Syllable
+	Tick    int
+	Seconds float64
+}
+
+// splitLyrics tokenizes GP lyric text: whitespace and hyphens separate
+// syllables, '+' joins words on one beat and [..] marks comments.
+func splitLyrics(text string) []Syllable {
+	var syllables []Syllable
+	var current strings.Builder
+	lineHead := true
+	inComment := false
+
+	flush := func(hyphen bool) {
+		if current.Len() == 0 {
+			return
+		}
+		syllables = append(syllables, Syllable{Text: current.String(), Hyphen: hyphen, LineHead: lineHead})
+		current.Reset()
+		lineHead = false
+	}
+
+	for _, r := range text {
+		switch {
+		case inComment:
+			inComment = r != ']'
+		case r == '[':
+			inComment = true
+		case r == '\n' || r == '\r':
+			flush(false)
+			lineHead = true
+		case r == '-':
+			flush(true)
+		case r == '+':
+			current.WriteRune(' ')
+		case unicode.IsSpace(r):
+			flush(false)
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush(false)
+	return syllables
+}
+
+// LyricsTrack returns the index of the first track carrying lyrics, or -1
+func (g *Gpif) LyricsTrack() int {
+	for i := range g.Tracks {
+		for _, line := range g.Tracks[i].Lyrics {
+			if strings.TrimSpace(line.Text) != "" {
+				return i
+			}
+		}
+	}
+	for _, beat := range g.Beats {
+		for _, line := range beat.Lyrics {
+			if strings.TrimSpace(line) != "" {
+				return g.beatTrack(beat.ID)
+			}
+		}
+	}
+	return -1
+}
+
+func (g *Gpif) beatTrack(beatID int) int {
+	for m := range g.MasterBars {
+		for track := range g.Tracks {
+			bar := g.TrackBar(m, track)
+			if bar == nil {
+				continue
+			}
+			for _, voice := range g.BarVoices(bar) {
+				if containsInt(parseIDs(voice.Beats), beatID) {
+					return track
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// singable reports whether a lyric syllable can be attached to the beat:
+// rests, grace notes and beats that only continue ties are skipped.
+func (g *Gpif) singable(beat *GpifBeat) bool {
+	if beat.GraceNotes != "" {
+		return false
+	}
+	notes := g.BeatNotes(beat)
+	for _, n := range notes {
+		if !n.Tie.Destination {
+			return true
+		}
+	}
+	return false
+}
+
+// beatLyrics assigns lyric syllables to beats, one map per lyric line. Beat
+// level lyrics win over the track text which is dispatched bar by bar.
+func (g *Gpif) beatLyrics(track int) []map[*GpifBeat]Syllable {
+	var lines []map[*GpifBeat]Syllable
+	set := func(line int, beat *GpifBeat, s Syllable) {
+		for len(lines) <= line {
+			lines = append(lines, make(map[*GpifBeat]Syllable))
+		}
+		lines[line][beat] = s
+	}
+
+	for line, l := range g.Tracks[track].Lyrics {
+		syllables := splitLyrics(l.Text)
+		for m := l.Offset; m < len(g.MasterBars) && len(syllables) > 0; m++ {
+			bar := g.TrackBar(m, track)
+			if bar == nil {
+				continue
+			}
+			voices := g.BarVoices(bar)
+			if len(voices) == 0 {
+				continue
+			}
+			for _, beat := range g.VoiceBeats(voices[0]) {
+				if len(syllables) == 0 {
+					break
+				}
+				if g.singable(beat) {
+					set(line, beat, syllables[0])
+					syllables = syllables[1:]
+				}
+			}
+		}
+	}
+
+	lineHead := true
+	for m := range g.MasterBars {
+		if g.MasterBars[m].Section != nil {
+			lineHead = true
+		}
+		bar := g.TrackBar(m, track)
+		if bar == nil {
+			continue
+		}
+		for _, voice := range g.BarVoices(bar) {
+			for _, beat := range g.VoiceBeats(voice) {
+				for line, text := range beat.Lyrics {
+					text = strings.TrimSpace(text)
+					if text == "" {
+						continue
+					}
+					hyphen := strings.HasSuffix(text, "-")
+					set(line, beat, Syllable{Text: strings.TrimSuffix(text, "-"), Hyphen: hyphen, LineHead: lineHead})
+					lineHead = false
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// TimedLyrics lays the lyrics of a track out in playback order. On repeated
+// bars the n-th pass sings the n-th lyric line when it has text there.
+func (g *Gpif) TimedLyrics(tl *Timeline, track int) []TimedSyllable {
+	lines := g.beatLyrics(track)
+	if len(lines) == 0 {
+		return nil
+	}
+	var result []TimedSyllable
+	for _, tb := range g.TrackBeats(tl, track) {
+		var syllable Syllable
+		found := false
+		if tb.Bar.Occurrence < len(lines) {
+			syllable, found = lines[tb.Bar.Occurrence][tb.Beat]
+		}
+		for i := 0; !found && i < len(lines); i++ {
+			syllable, found = lines[i][tb.Beat]
+		}
+		if !found {
+			continue
+		}
+		result = append(result, TimedSyllable{Syllable: syllable, Tick: tb.Tick, Seconds: tl.Seconds(tb.Tick)})
+	}
+	return result
+}
+
+func lrcTimestamp(seconds float64) string {
+	centis := int(seconds*100 + 0.5)
+	return fmt.Sprintf("%02d:%02d.%02d", centis/6000, centis/100%60, centis%100)
+}
+
+// writeLrc writes enhanced LRC: one timestamped line per lyric line with
+// per-word timestamps for karaoke highlighting
+func writeLrc(w io.Writer, score *GpifScore, syllables []TimedSyllable) error {
+	var b strings.Builder
+	tags := []struct{ tag, value string }{
+		{"ti", score.Title}, {"ar", score.Artist}, {"al", score.Album}, {"au", score.Words},
+	}
+	for _, t := range tags {
+		if v := strings.TrimSpace(t.value); v != "" {
+			fmt.Fprintf(&b, "[%s:%s]\n", t.tag, v)
+		}
+	}
+	b.WriteString("[re:gpx2gp]\n")
+
+	lineOpen := false
+	joinNext := false
+	for i, s := range syllables {
+		if i == 0 || (s.LineHead && !joinNext) {
+			if lineOpen {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "[%s]", lrcTimestamp(s.Seconds))
+			lineOpen = true
+		} else if !joinNext {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "<%s>%s", lrcTimestamp(s.Seconds), s.Text)
+		joinNext = s.Hyphen
+	}
+	if lineOpen {
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -2,16 +2,27 @@ package main
 
 import (
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/binary"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"math"
+	"math/bits"
 	"os"
 	"path/filepath"
+	"runtime"
+	buildinfo "runtime/debug"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
 //go:embed score.gpss
@@ -25,54 +36,99 @@ func debug(format string, a ...interface{}) {
 	}
 }
 
-// BitReader implementation (MSB First)
+// BitReader implementation (MSB First). Reads fail with io.EOF when the
+// data is exhausted before the read starts and io.ErrUnexpectedEOF when it
+// runs out part way, in which case nothing is consumed.
+//
+// Bits are buffered in a 64-bit accumulator, refilled a byte at a time,
+// so multi-bit reads shift out whole runs instead of looping per bit.
 type BitReader struct {
-	data      []byte
-	byteIdx   int
-	bitOffset int
+	data []byte
+	next int    // index of the next byte to load into acc
+	acc  uint64 // buffered bits, the next one in the top bit
+	nacc int    // number of buffered bits
 }
 
 func NewBitReader(data []byte) *BitReader {
-	return &BitReader{data: data, byteIdx: 0, bitOffset: 0}
+	return &BitReader{data: data}
 }
 
-func (br *BitReader) ReadBit() (byte, error) {
-	if br.byteIdx >= len(br.data) {
-		return 0, io.EOF
+// refill tops up the accumulator to at least 57 bits while data lasts
+func (br *BitReader) refill() {
+	for br.nacc <= 56 && br.next < len(br.data) {
+		br.acc |= uint64(br.data[br.next]) << (56 - br.nacc)
+		br.next++
+		br.nacc += 8
+	}
+}
+
+// bitsLeft counts the unread bits
+func (br *BitReader) bitsLeft() int {
+	return (len(br.data)-br.next)*8 + br.nacc
+}
+
+// Tell returns the number of bits read so far
+func (br *BitReader) Tell() int {
+	return br.next*8 - br.nacc
+}
+
+// need checks that n bits can be read
+func (br *BitReader) need(n int) error {
+	switch left := br.bitsLeft(); {
+	case left >= n:
+		return nil
+	case left <= 0:
+		return io.EOF
 	}
-	bit := (br.data[br.byteIdx] >> (7 - br.bitOffset)) & 1
-	br.bitOffset++
-	if br.bitOffset == 8 {
-		br.bitOffset = 0
-		br.byteIdx++
+	return io.ErrUnexpectedEOF
+}
+
+func (br *BitReader) ReadBit() (byte, error) {
+	if br.nacc == 0 {
+		if br.next == len(br.data) {
+			return 0, io.EOF
+		}
+		br.refill()
 	}
+	bit := byte(br.acc >> 63)
+	br.acc <<= 1
+	br.nacc--
 	return bit, nil
 }
 
 func (br *BitReader) ReadBits(n int) (uint64, error) {
-	var value uint64 = 0
-	for i := 0; i < n; i++ {
-		bit, err := br.ReadBit()
-		if err != nil {
-			return value, err
+	if br.nacc < n {
+		if err := br.need(n); err != nil {
+			return 0, err
 		}
-		value = (value << 1) | uint64(bit)
+		br.refill()
+	}
+	if n <= br.nacc {
+		value := br.acc >> (64 - n)
+		br.acc <<= n
+		br.nacc -= n
+		return value, nil
+	}
+	// Wider than the accumulator holds
+	var value uint64
+	for n > 0 {
+		br.refill()
+		k := min(n, br.nacc)
+		value = value<<k | br.acc>>(64-k)
+		br.acc <<= k
+		br.nacc -= k
+		n -= k
 	}
 	return value, nil
 }
 
+// ReadBitsReversed reads n bits least significant first
 func (br *BitReader) ReadBitsReversed(n int) (uint64, error) {
-	var value uint64 = 0
-	for i := 0; i < n; i++ {
-		bit, err := br.ReadBit()
-		if err != nil && err != io.EOF {
-			return 0, err
-		}
-		if bit == 1 {
-			value |= 1 << i
-		}
+	value, err := br.ReadBits(n)
+	if err != nil || n == 0 {
+		return 0, err
 	}
-	return value, nil
+	return bits.Reverse64(value) >> (64 - n), nil
 }
 
 func (br *BitReader) ReadByte() (byte, error) {
@@ -80,42 +136,300 @@ func (br *BitReader) ReadByte() (byte, error) {
 	return byte(val), err
 }
 
+// ReadBytes copies n bytes in bulk from the input, shifting them into
+// place when the reader is not on a byte boundary
 func (br *BitReader) ReadBytes(n int) ([]byte, error) {
+	if err := br.need(n * 8); err != nil {
+		return nil, err
+	}
 	buf := make([]byte, n)
-	for i := 0; i < n; i++ {
-		if br.bitOffset == 0 && br.byteIdx < len(br.data) {
-			buf[i] = br.data[br.byteIdx]
-			br.byteIdx++
-		} else {
-			b, err := br.ReadByte()
-			if err != nil {
-				return nil, err
-			}
-			buf[i] = b
+	if n <= 7 {
+		// Short reads come out of the accumulator
+		if br.nacc < n*8 {
+			br.refill()
+		}
+		for i := range buf {
+			buf[i] = byte(br.acc >> 56)
+			br.acc <<= 8
+			br.nacc -= 8
+		}
+		return buf, nil
+	}
+	pos := br.Tell()
+	start, shift := pos/8, pos%8
+	if shift == 0 {
+		copy(buf, br.data[start:])
+	} else {
+		src := br.data[start : start+n+1]
+		for i := range buf {
+			buf[i] = src[i]<<shift | src[i+1]>>(8-shift)
 		}
 	}
+	br.seek(pos + 8*n)
 	return buf, nil
 }
 
-func (br *BitReader) ReadAll() []byte {
-	if br.byteIdx >= len(br.data) {
-		return []byte{}
+// seek moves to bit offset pos, dropping the buffered bits
+func (br *BitReader) seek(pos int) {
+	br.acc, br.nacc, br.next = 0, 0, pos/8
+	if r := pos % 8; r > 0 {
+		br.refill()
+		br.acc <<= r
+		br.nacc -= r
+	}
+}
+
+// Position locates the next bit to read, for error messages
+func (br *BitReader) Position() string {
+	return br.PositionAt(br.Tell())
+}
+
+// PositionAt describes the bit offset pos of the stream
+func (br *BitReader) PositionAt(pos int) string {
+	return fmt.Sprintf("byte %d bit %d %s", pos/8, pos%8, hexContext(br.data, pos/8))
+}
+
+// hexContext shows the bytes around pos, the one at pos in brackets
+func hexContext(data []byte, pos int) string {
+	var sb strings.Builder
+	sb.WriteString("(near")
+	for i := max(pos-4, 0); i < min(pos+8, len(data)); i++ {
+		if i == pos {
+			fmt.Fprintf(&sb, " [%02x]", data[i])
+		} else {
+			fmt.Fprintf(&sb, " %02x", data[i])
+		}
+	}
+	if pos >= len(data) {
+		sb.WriteString(" [end]")
 	}
-	return br.data[br.byteIdx:]
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// Remaining counts the whole bytes not read yet
+func (br *BitReader) Remaining() int {
+	return br.bitsLeft() / 8
+}
+
+// ReadAll returns the rest of the data from the byte holding the next bit
+func (br *BitReader) ReadAll() []byte {
+	start := br.Tell() / 8
+	br.seek(len(br.data) * 8)
+	return br.data[start:]
 }
 
 // GpxFileSystem logic
 type GpxFileSystem struct {
-	Files []GpxFile
+	Files    []GpxFile
+	Limits   Limits
+	Mode     ParseMode
+	Warnings []string
+	Partial  bool   // damaged data was recovered, the output may be incomplete
+	Source   string // SHA-256 of the container file, hex encoded
+	Format   string // BCFZ (compressed) or BCFS
+	Spill    bool   // write files other than the score to disk rather than exceed the memory limit
+	reserved int    // bytes accounted against Limits.MaxMemory
+	spillDir string
+	release  func() error    // unmaps the input the files may point into
+	raw      []byte          // the container as read, valid until Close
+	assets   []generatedFile // extra entries such as backing track audio, by archive path
+	cover    string          // entry of the cover image among assets, named in meta.json
+	tunings  []TuningLabel   // tuning labels written into the score, listed in meta.json
+}
+
+// ParseMode decides which anomalies abort parsing
+type ParseMode int
+
+const (
+	// ParseDefault fails on corruption and warns about minor anomalies
+	ParseDefault ParseMode = iota
+	// ParseStrict fails on any anomaly
+	ParseStrict
+	// ParseLenient warns about everything and recovers what it can
+	ParseLenient
+)
+
+// parseMode applies to every container read by the CLI
+var parseMode = ParseDefault
+
+// warn records a minor anomaly, which is only fatal in strict mode
+func (fs *GpxFileSystem) warn(format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if fs.Mode == ParseStrict {
+		return fmt.Errorf("%s", msg)
+	}
+	fs.Warnings = append(fs.Warnings, msg)
+	return nil
+}
+
+// corrupt records damaged data, which is fatal unless parsing leniently.
+// A nil result means the caller should recover as best it can.
+func (fs *GpxFileSystem) corrupt(format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if fs.Mode != ParseLenient {
+		return fmt.Errorf("%s", msg)
+	}
+	fs.Warnings = append(fs.Warnings, msg)
+	fs.Partial = true
+	return nil
+}
+
+// Limits bounds the resources a single container may consume, zero disables a limit
+type Limits struct {
+	MaxDecompressedSize int // bytes a BCFZ stream may declare and produce
+	MaxExpansionRatio   int // decompressed size over compressed size
+	MaxInputSize        int // bytes of the container file itself
+	MaxMemory           int // bytes buffered for the input, decompression and inner files together
+}
+
+// limits applies to every container read by the CLI
+var limits = Limits{
+	MaxDecompressedSize: 256 << 20,
+	MaxExpansionRatio:   200,
+	MaxInputSize:        64 << 20,
+	MaxMemory:           1 << 30,
+}
+
+// ArchiveOptions control how .gp archives are written
+type ArchiveOptions struct {
+	Manifest     bool      // add a SHA-256 manifest of the Content/ files
+	Reproducible bool      // byte-identical output for the same input
+	Modified     time.Time // entry timestamp, zero leaves entries undated
+	Provenance   bool      // record tool version, source hash and date in the zip comment
+	Method       uint16    // zip.Store or zip.Deflate for file entries
+	Level        int       // deflate level, flate.DefaultCompression leaves the library default
+	EmbedSource  bool      // store the untouched input container as sourceEntryName
+}
+
+// sourceEntryName holds the original .gpx in archives written with
+// -embed-source; Guitar Pro ignores it
+const sourceEntryName = "Content/Source/original.gpx"
+
+// archiveOptions applies to every archive written by the CLI
+var archiveOptions = ArchiveOptions{
+	Method: zip.Deflate,
+	Level:  flate.DefaultCompression,
 }
 
+// Reproducible archives use the earliest valid zip date and a fixed
+// deflate level, so equal inputs give byte-identical outputs
+var reproducibleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const reproducibleLevel = 6
+
 type GpxFile struct {
 	FileName string
 	FileSize int
-	Data     []byte
+	Data     []byte // nil when spilled to disk; may point into the container, replace rather than modify it
+
+	spill     string // temporary file holding the data
+	spillSize int
+}
+
+func (fs *GpxFileSystem) File(name string) *GpxFile {
+	for i := range fs.Files {
+		if fs.Files[i].FileName == name {
+			return &fs.Files[i]
+		}
+	}
+	return nil
+}
+
+// SourceVersion describes the release that wrote the container, as far
+// as the score and the container format tell
+func (fs *GpxFileSystem) SourceVersion() string {
+	version := "unknown Guitar Pro version"
+	if score, err := fs.loadScore(); err == nil {
+		version = score.SourceVersion()
+	}
+	return fmt.Sprintf("%s, %s container", version, fs.Format)
+}
+
+// at locates pos in the container data for error messages. The offset is
+// that of the file for BCFS, of the decompressed stream for BCFZ; both
+// count the 4 byte BCFS header.
+func (fs *GpxFileSystem) at(data []byte, pos int) string {
+	stream := "file"
+	if fs.Format == "BCFZ" {
+		stream = "decompressed"
+	}
+	return fmt.Sprintf("at %s offset 0x%x %s", stream, pos+4, hexContext(data, pos))
+}
+
+// optionalFiles are the inner files Guitar Pro 6 writes besides the score,
+// what their absence means, and for those that have one an empty form
+// Guitar Pro reads as "use the defaults"
+var optionalFiles = []struct {
+	name    string
+	effect  string
+	standIn []byte
+}{
+	{"PartConfiguration", "track views and part settings are reset to the defaults", []byte{0, 0, 0, 0}},
+	{"LayoutConfiguration", "page layout settings are reset to the defaults", nil},
+	{"BinaryStylesheet", "the score stylesheet is reset to the defaults", []byte{0, 0, 0, 0}},
+}
+
+// synthesizeMissing adds stand-ins for missing optional files
+var synthesizeMissing = false
+
+// checkOptional reports optional files the container lacks, adding empty
+// stand-ins where they exist when synthesize is set
+func (fs *GpxFileSystem) checkOptional(synthesize bool) error {
+	for _, opt := range optionalFiles {
+		if fs.File(opt.name) != nil {
+			continue
+		}
+		var err error
+		switch {
+		case synthesize && opt.standIn != nil:
+			fs.Files = append(fs.Files, GpxFile{FileName: opt.name, FileSize: len(opt.standIn), Data: opt.standIn})
+			err = fs.warn("%s is missing, wrote an empty one: %s", opt.name, opt.effect)
+		case synthesize:
+			err = fs.warn("%s is missing and has no empty form to write: %s", opt.name, opt.effect)
+		default:
+			err = fs.warn("%s is missing, the output has none: %s", opt.name, opt.effect)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingScore explains a container without score.gpif, listing what it
+// does hold and the likely causes
+func (fs *GpxFileSystem) missingScore() error {
+	if len(fs.Files) == 0 {
+		return fmt.Errorf("score.gpif not found: the container holds no files, it is probably truncated or encrypted")
+	}
+	var found []string
+	for _, f := range fs.Files {
+		found = append(found, fmt.Sprintf("%s (%d bytes)", f.FileName, f.FileSize))
+	}
+	hint := "the header of score.gpif is probably damaged or the file was truncated"
+	if fs.Mode != ParseLenient {
+		hint += ", -lenient searches the container for the score"
+	}
+	return fmt.Errorf("score.gpif not found among the %d inner files: %s; %s", len(fs.Files), strings.Join(found, ", "), hint)
+}
+
+// reserve accounts for a buffer of n bytes before it is allocated
+func (fs *GpxFileSystem) reserve(n int, what string) error {
+	fs.reserved += n
+	if fs.Limits.MaxMemory > 0 && fs.reserved > fs.Limits.MaxMemory {
+		return fmt.Errorf("buffering %s (%d bytes) would exceed the memory limit of %d bytes", what, n, fs.Limits.MaxMemory)
+	}
+	return nil
 }
 
 func (fs *GpxFileSystem) Load(data []byte) error {
+	if err := fs.Limits.checkInput(len(data)); err != nil {
+		return err
+	}
+	if err := fs.reserve(len(data), "input"); err != nil {
+		return err
+	}
 	reader := NewBitReader(data)
 	return fs.readBlock(reader)
 }
@@ -127,6 +441,7 @@ func (fs *GpxFileSystem) readBlock(src *BitReader) error {
 	}
 	header := string(headerBytes)
 	debug("Container Header: %s", header)
+	fs.Format = header
 
 	if header == "BCFZ" {
 		decompressed, err := fs.decompress(src)
@@ -138,92 +453,311 @@ func (fs *GpxFileSystem) readBlock(src *BitReader) error {
 	} else if header == "BCFS" {
 		return fs.readUncompressedBlock(src.ReadAll())
 	} else {
-		return fmt.Errorf("unsupported format header: %s", header)
+		return fmt.Errorf("unsupported format header: %q%s", header, formatHint(headerBytes, src.data))
+	}
+}
+
+// formatHint names the format of a file that is not a GPX container
+func formatHint(header, data []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK")):
+		return ", this is a zip archive, probably already a Guitar Pro 7 .gp file"
+	case bytes.Contains(data[:min(len(data), 32)], []byte("FICHIER GUITAR PRO")):
+		return ", this is a Guitar Pro 3-5 file, which is not a GPX container"
+	case bytes.HasPrefix(header, []byte("<?xm")) || bytes.HasPrefix(header, []byte("<GPI")):
+		return ", this is a bare XML score rather than a GPX container"
 	}
+	return ", the file may be encrypted, damaged or not a Guitar Pro 6 file"
 }
 
+// decompress decodes a BCFZ stream for the container parser, into memory
+// or, with Spill and too little memory left, into a mapped spill file
 func (fs *GpxFileSystem) decompress(src *BitReader) ([]byte, error) {
-	lenBytes, err := src.ReadBytes(4)
+	expectedLength, err := fs.declaredLength(src)
 	if err != nil {
 		return nil, err
 	}
+
+	var uncompressed []byte
+	if fs.Spill && !fs.fits(expectedLength) {
+		if uncompressed, err = fs.decompressToDisk(src, expectedLength); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := fs.reserve(expectedLength, "decompression"); err != nil {
+			return nil, err
+		}
+		out := bytes.NewBuffer(make([]byte, 0, expectedLength))
+		if err := fs.decompressTo(src, expectedLength, out); err != nil {
+			return nil, err
+		}
+		uncompressed = out.Bytes()
+	}
+
+	if len(uncompressed) < 4 || string(uncompressed[:4]) != "BCFS" {
+		head := uncompressed[:min(len(uncompressed), 4)]
+		if err := fs.corrupt("decompressed data starts with %q instead of BCFS %s", head, hexContext(uncompressed, 0)); err != nil {
+			return nil, err
+		}
+	}
+	if len(uncompressed) >= 4 {
+		return uncompressed[4:], nil
+	}
+	return uncompressed, nil
+}
+
+// declaredLength reads the decompressed size a BCFZ stream starts with and
+// checks it against the limits
+func (fs *GpxFileSystem) declaredLength(src *BitReader) (int, error) {
+	lenBytes, err := src.ReadBytes(4)
+	if err != nil {
+		return 0, fmt.Errorf("missing decompressed length at %s: %v", src.Position(), err)
+	}
 	expectedLength := int(binary.LittleEndian.Uint32(lenBytes))
+	if err := fs.Limits.checkDecompressed(expectedLength, len(src.data)); err != nil {
+		return 0, err
+	}
+	return expectedLength, nil
+}
+
+// bcfzWindowSize covers the farthest back-reference, offsets are at most 15 bits
+const bcfzWindowSize = 1 << 15
+
+// bcfzBuffers holds decoding buffers for reuse across conversions. Each
+// keeps the window plus room for output between flushes to the sink.
+var bcfzBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 4*bcfzWindowSize)
+		return &buf
+	},
+}
 
-	uncompressed := make([]byte, 0, expectedLength)
+// decompressTo decodes the BCFZ stream after its declared length into sink.
+// Only the window back-references can reach is kept, so memory does not
+// grow with the decompressed size; output past expectedLength is dropped.
+func (fs *GpxFileSystem) decompressTo(src *BitReader, expectedLength int, sink io.Writer) error {
+	pooled := bcfzBuffers.Get().(*[]byte)
+	defer bcfzBuffers.Put(pooled)
+	buf := *pooled
+
+	// buf[:n] is the output from offset base on, of which buf[:written]
+	// went to the sink already
+	n, base, written := 0, 0, 0
+	flush := func() error {
+		end := min(n, expectedLength-base)
+		var err error
+		if end > written {
+			_, err = sink.Write(buf[written:end])
+		}
+		written = n
+		return err
+	}
 
-	for len(uncompressed) < expectedLength {
+	// Running out of input is how a truncated stream ends, the shortfall
+	// is reported below; any other read error is fatal
+	exhausted := func(err error) bool {
+		return err == io.EOF || err == io.ErrUnexpectedEOF
+	}
+	// Bit offset of the current token, for messages
+	var token int
+
+stream:
+	for base+n < expectedLength {
+		if n > len(buf)-bcfzWindowSize {
+			// Keep the window, make room for the longest match
+			if err := flush(); err != nil {
+				return err
+			}
+			copy(buf, buf[n-bcfzWindowSize:n])
+			base += n - bcfzWindowSize
+			n, written = bcfzWindowSize, bcfzWindowSize
+		}
+		token = src.Tell()
 		flag, err := src.ReadBits(1)
 		if err != nil {
-			if err == io.EOF {
+			if exhausted(err) {
 				break
 			}
-			return nil, err
+			return err
 		}
 
 		if flag == 1 {
 			// Compressed ref
 			wordSize, err := src.ReadBits(4)
-			if err == io.EOF {
-				break
+			if err != nil {
+				if exhausted(err) {
+					break
+				}
+				return err
 			}
-
 			offset, err := src.ReadBitsReversed(int(wordSize))
-			if err == io.EOF {
-				break
+			if err != nil {
+				if exhausted(err) {
+					break
+				}
+				return err
 			}
-
 			size, err := src.ReadBitsReversed(int(wordSize))
-			if err == io.EOF {
-				break
+			if err != nil {
+				if exhausted(err) {
+					break
+				}
+				return err
 			}
 
-			sourcePosition := len(uncompressed) - int(offset)
+			sourcePosition := base + n - int(offset)
 			toRead := int(math.Min(float64(offset), float64(size)))
 
 			if sourcePosition < 0 {
-				for k := 0; k < toRead; k++ {
-					uncompressed = append(uncompressed, 0)
+				if err := fs.warn("back-reference %d bytes before the start of the stream at %s, output offset %d, filled with zeros", -sourcePosition, src.PositionAt(token), base+n); err != nil {
+					return err
 				}
+				clear(buf[n : n+toRead])
+				n += toRead
 				continue
 			}
 
-			for i := 0; i < toRead; i++ {
-				if sourcePosition+i < len(uncompressed) {
-					uncompressed = append(uncompressed, uncompressed[sourcePosition+i])
-				} else {
-					uncompressed = append(uncompressed, 0)
-				}
-			}
+			// toRead never exceeds offset, so source and target do not overlap
+			from := n - int(offset)
+			n += copy(buf[n:n+toRead], buf[from:from+toRead])
 		} else {
 			// Literal
 			size, err := src.ReadBitsReversed(2)
-			if err == io.EOF {
-				break
+			if err != nil {
+				if exhausted(err) {
+					break
+				}
+				return err
 			}
 
 			for i := 0; i < int(size); i++ {
 				b, err := src.ReadByte()
 				if err != nil {
-					if err == io.EOF {
-						break
+					if exhausted(err) {
+						break stream
 					}
-					return nil, err
+					return err
 				}
-				uncompressed = append(uncompressed, b)
+				buf[n] = b
+				n++
 			}
 		}
 	}
+	if err := flush(); err != nil {
+		return err
+	}
+	produced := base + n
+
+	// The declared length is all the header promises, check the stream agrees
+	debug("Decompressed %d of %d declared bytes", produced, expectedLength)
+	if produced < expectedLength {
+		recovered := float64(produced) * 100 / float64(expectedLength)
+		if err := fs.corrupt("compressed stream ended early at %s, recovered %d of %d bytes (%.1f%%)", src.Position(), produced, expectedLength, recovered); err != nil {
+			return fmt.Errorf("%v, use -lenient to convert the partial data", err)
+		}
+	}
+	if produced > expectedLength {
+		if err := fs.warn("compressed stream overruns its declared length of %d bytes by %d bytes at %s, the excess was dropped", expectedLength, produced-expectedLength, src.PositionAt(token)); err != nil {
+			return err
+		}
+	}
+	if left := src.Remaining(); left > 0 && produced >= expectedLength {
+		if err := fs.warn("%d bytes of compressed data follow the declared %d bytes at %s and were ignored", left, expectedLength, src.Position()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkInput rejects a container file by its size, before it is read
+func (l Limits) checkInput(size int) error {
+	if l.MaxInputSize > 0 && size > l.MaxInputSize {
+		return fmt.Errorf("input of %d bytes exceeds the limit of %d bytes", size, l.MaxInputSize)
+	}
+	return nil
+}
 
-	if len(uncompressed) > 4 {
-		return uncompressed[4:], nil
+// checkDecompressed rejects a declared size before anything is allocated for it.
+// The decompression loop stops at the declared size, so this bounds the output.
+func (l Limits) checkDecompressed(expectedLength, compressedLength int) error {
+	if l.MaxDecompressedSize > 0 && expectedLength > l.MaxDecompressedSize {
+		return fmt.Errorf("declared size of %d bytes exceeds the limit of %d bytes", expectedLength, l.MaxDecompressedSize)
 	}
-	return uncompressed, nil
+	if l.MaxExpansionRatio > 0 && compressedLength > 0 && expectedLength > l.MaxExpansionRatio*compressedLength {
+		return fmt.Errorf("declared size of %d bytes is more than %d times the %d compressed bytes", expectedLength, l.MaxExpansionRatio, compressedLength)
+	}
+	return nil
+}
+
+// Sector layout of the uncompressed container
+const (
+	sectorSize     = 0x1000
+	fileHeaderSize = 0x94 // type, name and size fields before the sector list
+)
+
+// validFileName extracts the NUL terminated name of a file header and
+// rejects names that cannot come from a real container
+func validFileName(raw []byte) (string, error) {
+	name := decodeFileName(raw)
+	if name == "" {
+		return "", fmt.Errorf("empty file name")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("file name %q contains control characters", name)
+		}
+	}
+	if err := safeEntryName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// plausibleHeader reports whether data starts with a complete file header
+func plausibleHeader(data []byte) bool {
+	if len(data) < fileHeaderSize || binary.LittleEndian.Uint32(data) != 2 {
+		return false
+	}
+	_, err := validFileName(data[0x04 : 0x04+127])
+	return err == nil
+}
+
+// safeEntryName rejects inner names that would escape the directory they
+// are written under, as in "Content/" + name
+func safeEntryName(name string) error {
+	if strings.ContainsRune(name, '\\') {
+		return fmt.Errorf("file name %q contains a backslash", name)
+	}
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return fmt.Errorf("file name %q is an absolute path", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." || part == "." || part == "" {
+			return fmt.Errorf("file name %q has an empty, . or .. path element", name)
+		}
+	}
+	return nil
+}
+
+// consecutiveSectors returns the offset of the sectors in data when they
+// follow each other without gaps, so the file can be sliced out whole
+func consecutiveSectors(sectors []int) (int, bool) {
+	if len(sectors) == 0 {
+		return 0, false
+	}
+	for k, sectorIndex := range sectors {
+		if sectorIndex != sectors[0]+k {
+			return 0, false
+		}
+	}
+	return sectors[0] * sectorSize, true
 }
 
 func (fs *GpxFileSystem) readUncompressedBlock(data []byte) error {
-	const sectorSize = 0x1000
 	offset := sectorSize
-	usedSectors := make(map[int]bool)
+	sectorCount := (len(data) + sectorSize - 1) / sectorSize
+	usedSectors := make(map[int]string) // sector -> owning file
+	headerSectors := make(map[int]bool)
 
 	getInt := func(pos int) int {
 		if pos+4 > len(data) {
@@ -232,96 +766,287 @@ func (fs *GpxFileSystem) readUncompressedBlock(data []byte) error {
 		return int(binary.LittleEndian.Uint32(data[pos : pos+4]))
 	}
 
-	getString := func(pos int, length int) string {
-		if pos+length > len(data) {
-			return ""
-		}
-		slice := data[pos : pos+length]
-		end := 0
-		for end < len(slice) {
-			if slice[end] == 0 {
-				break
-			}
-			end++
-		}
-		return string(slice[:end])
+	// Containers consist of whole sectors. A partial sector at the end is
+	// padding or junk appended by other tools, unless it holds a header.
+	tailSector := -1
+	if len(data)%sectorSize != 0 {
+		tailSector = len(data) / sectorSize
 	}
 
 	for offset+3 < len(data) {
 		currentSectorIdx := offset / sectorSize
-		if usedSectors[currentSectorIdx] {
+		if usedSectors[currentSectorIdx] != "" || headerSectors[currentSectorIdx] {
 			offset += sectorSize
 			continue
 		}
+		if currentSectorIdx == tailSector && !plausibleHeader(data[offset:]) {
+			break
+		}
 
 		entryType := getInt(offset)
 		if entryType == 2 {
-			fileName := getString(offset+0x04, 127)
-			fileSize := getInt(offset + 0x8c)
-
-			if fileName == "" || fileSize < 0 {
+			if offset+fileHeaderSize > len(data) {
+				if err := fs.corrupt("file header at sector %d is truncated %s", currentSectorIdx, fs.at(data, offset)); err != nil {
+					return err
+				}
+				break
+			}
+			fileName, err := validFileName(data[offset+0x04 : offset+0x04+127])
+			if err != nil {
+				if err := fs.corrupt("file header at sector %d: %v %s", currentSectorIdx, err, fs.at(data, offset+0x04)); err != nil {
+					return err
+				}
 				offset += sectorSize
 				continue
 			}
+			fileSize := getInt(offset + 0x8c)
+			headerSectors[currentSectorIdx] = true
 
 			debug("Found File Header at Sector %d: %s (%d bytes)", currentSectorIdx, fileName, fileSize)
 
-			file := GpxFile{
-				FileName: fileName,
-				FileSize: fileSize,
-			}
-
-			var fileData []byte
-			dataPointerOffset := offset + 0x94
-			sectorCount := 0
-
-			for {
-				sectorIndex := getInt(dataPointerOffset + 4*sectorCount)
-				sectorCount++
-				if sectorIndex == 0 {
+			// Unreadable sectors of other files are filled with zeros,
+			// the score is useless unless it is intact
+			quarantine := fileName != "score.gpif"
+			unreadable := make(map[int]string) // position in sectors -> problem
+
+			// Long sector lists continue into the sectors following the
+			// header, which then hold no file data of their own
+			var sectors []int
+			for i := 0; ; i++ {
+				if i >= sectorCount {
+					if err := fs.corrupt("%s: sector list at sector %d is not terminated %s", fileName, currentSectorIdx, fs.at(data, offset+fileHeaderSize)); err != nil {
+						return err
+					}
 					break
 				}
-
-				usedSectors[sectorIndex] = true
-				sectorPos := sectorIndex * sectorSize
-				if sectorPos >= len(data) {
+				pos := offset + fileHeaderSize + 4*i
+				if pos+4 > len(data) {
+					if err := fs.corrupt("%s: sector list runs past the end of the container %s", fileName, fs.at(data, pos)); err != nil {
+						return err
+					}
 					break
 				}
-				end := sectorPos + sectorSize
-				if end > len(data) {
-					end = len(data)
+				if continuation := pos / sectorSize; continuation != currentSectorIdx && !headerSectors[continuation] {
+					debug("Sector list of %s continues in sector %d", fileName, continuation)
+					headerSectors[continuation] = true
 				}
-
-				fileData = append(fileData, data[sectorPos:end]...)
+				sectorIndex := getInt(pos)
+				if sectorIndex == 0 {
+					break
+				}
+				if sectorIndex >= sectorCount && quarantine {
+					unreadable[len(sectors)] = fmt.Sprintf("sector %d is out of range", sectorIndex)
+					sectors = append(sectors, -1)
+					continue
+				}
+				if sectorIndex >= sectorCount {
+					if err := fs.corrupt("%s: sector %d is out of range, the container has %d sectors %s", fileName, sectorIndex, sectorCount, fs.at(data, pos)); err != nil {
+						return err
+					}
+					continue
+				}
+				if owner := usedSectors[sectorIndex]; quarantine && (owner != "" || headerSectors[sectorIndex]) {
+					if owner == "" {
+						owner = "a file header"
+					}
+					unreadable[len(sectors)] = fmt.Sprintf("sector %d belongs to %s", sectorIndex, owner)
+					sectors = append(sectors, -1)
+					continue
+				}
+				if usedSectors[sectorIndex] != "" || headerSectors[sectorIndex] {
+					if err := fs.corrupt("%s: sector %d is already in use %s", fileName, sectorIndex, fs.at(data, pos)); err != nil {
+						return err
+					}
+				}
+				usedSectors[sectorIndex] = fileName
+				sectors = append(sectors, sectorIndex)
 			}
 
-			if len(fileData) > fileSize {
-				fileData = fileData[:fileSize]
+			if fileSize > len(sectors)*sectorSize || (len(sectors) > 0 && fileSize <= (len(sectors)-1)*sectorSize) {
+				if err := fs.corrupt("%s: size of %d bytes does not match its %d sectors %s", fileName, fileSize, len(sectors), fs.at(data, offset+0x8c)); err != nil {
+					return err
+				}
 			}
-			file.Data = fileData
-			fs.Files = append(fs.Files, file)
-		}
-		offset += sectorSize
-	}
-	return nil
-}
 
-// Zip archive creation logic
-func createGpArchive(outputPath string, fs *GpxFileSystem) error {
-	zipFile, err := os.Create(outputPath)
-	if err != nil {
-		return err
-	}
-	defer zipFile.Close()
+			// The common layout, one run of sectors, needs no copy. The
+			// capacity is capped so appending to the slice copies it.
+			if start, ok := consecutiveSectors(sectors); ok && len(unreadable) == 0 && fileSize <= len(sectors)*sectorSize && start+fileSize <= len(data) {
+				debug("%s is stored in consecutive sectors, using it in place", fileName)
+				fs.Files = append(fs.Files, GpxFile{FileName: fileName, FileSize: fileSize, Data: data[start : start+fileSize : start+fileSize]})
+				offset += sectorSize
+				continue
+			}
 
-	zw := zip.NewWriter(zipFile)
-	defer zw.Close()
+			capacity := min(fileSize, len(sectors)*sectorSize)
+			file := &fileAssembler{}
+			if fs.Spill && fileName != "score.gpif" && !fs.fits(capacity) {
+				if file.spill, err = fs.spillFile(); err != nil {
+					return err
+				}
+				debug("Spilling %s (%d bytes) to %s", fileName, capacity, file.spill.Name())
+				file.w = bufio.NewWriterSize(file.spill, 64<<10)
+			} else {
+				if err := fs.reserve(capacity, fileName); err != nil {
+					return err
+				}
+				file.data = make([]byte, 0, capacity)
+			}
+			var damaged []string
+			zeroFill := func(n int, problem string) {
+				from, to := file.size, min(file.size+n, fileSize)
+				if from < to {
+					damaged = append(damaged, fmt.Sprintf("bytes %d-%d (%s)", from, to-1, problem))
+				}
+				file.write(make([]byte, n))
+			}
+			for k, sectorIndex := range sectors {
+				if problem, ok := unreadable[k]; ok {
+					zeroFill(sectorSize, problem)
+					continue
+				}
+				sectorPos := sectorIndex * sectorSize
+				end := min(sectorPos+sectorSize, len(data))
+				file.write(data[sectorPos:end])
+			}
+			if file.size < fileSize && quarantine && len(sectors)*sectorSize >= fileSize {
+				zeroFill(fileSize-file.size, "past the end of the container")
+			}
+			if len(damaged) > 0 {
+				fs.Partial = true
+				if err := fs.warn("%s: unreadable data filled with zeros: %s", fileName, strings.Join(damaged, ", ")); err != nil {
+					return err
+				}
+			}
+			if file.size < fileSize {
+				if err := fs.corrupt("%s: truncated, only %d of %d bytes present, header %s", fileName, file.size, fileSize, fs.at(data, offset)); err != nil {
+					return err
+				}
+			}
+
+			assembled, err := file.finish(fileName, fileSize, min(fileSize, file.size))
+			if err != nil {
+				return fmt.Errorf("spilling %s: %v", fileName, err)
+			}
+			fs.Files = append(fs.Files, assembled)
+		}
+		offset += sectorSize
+	}
+
+	if tailSector >= 0 && usedSectors[tailSector] == "" && !headerSectors[tailSector] {
+		tail := data[tailSector*sectorSize:]
+		if len(bytes.Trim(tail, "\x00")) == 0 {
+			debug("Ignored %d bytes of zero padding after the last sector", len(tail))
+		} else if err := fs.warn("ignored %d bytes of trailing data after the last sector %s", len(tail), fs.at(data, tailSector*sectorSize)); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.resolveDuplicates(); err != nil {
+		return err
+	}
+	if fs.Mode == ParseLenient {
+		fs.salvageScore(data, usedSectors, headerSectors)
+	}
+	return nil
+}
+
+// Zip archive creation logic
+func createGpArchive(outputPath string, fs *GpxFileSystem) error {
+	if fs.File("score.gpif") == nil {
+		return fs.missingScore()
+	}
+	if isRemotePath(outputPath) {
+		return putRemote(outputPath, func(w io.Writer) error { return writeGpArchive(w, fs) })
+	}
+
+	zipFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer zipFile.Close()
+	out := newAsyncWriter(zipFile)
+	if err := writeGpArchive(out, fs); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return zipFile.Close()
+}
+
+// zipDefaultLevel is the level archive/zip deflates with unless told otherwise
+const zipDefaultLevel = 5
+
+// archiveLevel is the deflate level archive entries are compressed with
+func archiveLevel() int {
+	switch {
+	case archiveOptions.Level != flate.DefaultCompression:
+		return archiveOptions.Level
+	case archiveOptions.Reproducible:
+		// Pin the level so the output does not follow library defaults
+		return reproducibleLevel
+	}
+	return zipDefaultLevel
+}
+
+// newArchiveWriter returns a zip writer compressing at the configured level
+func newArchiveWriter(w io.Writer) *zip.Writer {
+	zw := zip.NewWriter(w)
+	level := archiveLevel()
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	return zw
+}
 
+// archiveHeader describes an entry with the configured timestamp, and fixed
+// permissions in reproducible mode
+func archiveHeader(name string, method uint16) *zip.FileHeader {
+	h := &zip.FileHeader{Name: name, Method: method, Modified: archiveOptions.Modified}
+	if archiveOptions.Reproducible {
+		if h.Modified.IsZero() {
+			h.Modified = reproducibleTime
+		}
+		if strings.HasSuffix(name, "/") {
+			h.SetMode(os.ModeDir | 0755)
+		} else {
+			h.SetMode(0644)
+		}
+	}
+	return h
+}
+
+func writeGpArchive(w io.Writer, fs *GpxFileSystem) error {
+	zw := newArchiveWriter(w)
+	defer zw.Close()
+
+	var manifest strings.Builder
+	writeFile := func(name string, file *GpxFile) error {
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		f, err := zw.CreateHeader(archiveHeader(name, archiveOptions.Method))
+		if err != nil {
+			return err
+		}
+		sum := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(f, sum), r); err != nil {
+			return err
+		}
+		manifest.WriteString(manifestSumLine(name, sum.Sum(nil)))
+		return nil
+	}
 	writeEntry := func(name string, content []byte) error {
-		f, err := zw.Create(name)
+		f, err := zw.CreateHeader(archiveHeader(name, archiveOptions.Method))
 		if err != nil {
 			return err
 		}
+		if strings.HasPrefix(name, "Content/") {
+			manifest.WriteString(manifestLine(name, content))
+		}
 		_, err = f.Write(content)
 		return err
 	}
@@ -330,15 +1055,15 @@ func createGpArchive(outputPath string, fs *GpxFileSystem) error {
 		if !strings.HasSuffix(name, "/") {
 			name = name + "/"
 		}
-		_, err := zw.Create(name)
+		_, err := zw.CreateHeader(archiveHeader(name, zip.Store))
 		return err
 	}
 
 	// Static content
-	if err := writeEntry("meta.json", []byte("{}")); err != nil {
+	if err := writeEntry("meta.json", archiveMeta(fs)); err != nil {
 		return err
 	}
-	if err := writeEntry("VERSION", []byte("7.0")); err != nil {
+	if err := writeEntry("VERSION", []byte(target.Version)); err != nil {
 		return err
 	}
 	if err := writeEntry("Content/Preferences.json", []byte("{}")); err != nil {
@@ -362,11 +1087,40 @@ func createGpArchive(outputPath string, fs *GpxFileSystem) error {
 		"BinaryStylesheet":    true,
 	}
 
+	files := fs.Files
+	if archiveOptions.Reproducible {
+		// Inner files keep container order otherwise, which varies
+		files = append([]GpxFile(nil), files...)
+		sort.SliceStable(files, func(i, j int) bool { return files[i].FileName < files[j].FileName })
+	}
+
+	// Large entries are deflated by workers meanwhile and copied in raw
+	pending := make([]*precompressed, len(files))
+	if archiveOptions.Method == zip.Deflate {
+		sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+		for i, file := range files {
+			if allowedFiles[file.FileName] && file.spill == "" && len(file.Data) >= precompressMin {
+				pending[i] = precompress(file.Data, archiveLevel(), sem)
+			}
+		}
+	}
+
 	count := 0
-	for _, file := range fs.Files {
+	for i, file := range files {
 		if allowedFiles[file.FileName] {
+			if err := safeEntryName(file.FileName); err != nil {
+				return err
+			}
 			targetPath := "Content/" + file.FileName
-			if err := writeEntry(targetPath, file.Data); err != nil {
+			var err error
+			if p := pending[i]; p != nil {
+				if err = p.writeTo(zw, targetPath); err == nil {
+					manifest.WriteString(manifestSumLine(targetPath, p.sum))
+				}
+			} else {
+				err = writeFile(targetPath, &file)
+			}
+			if err != nil {
 				return fmt.Errorf("failed to write %s: %v", file.FileName, err)
 			}
 			count++
@@ -376,11 +1130,318 @@ func createGpArchive(outputPath string, fs *GpxFileSystem) error {
 	if count == 0 {
 		return fmt.Errorf("no valid content files found in GPX")
 	}
+	for _, asset := range fs.assets {
+		if err := writeEntry(asset.path, asset.data); err != nil {
+			return err
+		}
+	}
+	if archiveOptions.EmbedSource && fs.raw != nil {
+		if err := writeEntry(sourceEntryName, fs.raw); err != nil {
+			return err
+		}
+	}
+
+	if archiveOptions.Manifest {
+		if err := writeEntry(manifestName, []byte(manifest.String())); err != nil {
+			return err
+		}
+	}
+	if archiveOptions.Provenance {
+		return zw.SetComment(provenanceComment(fs))
+	}
+	return nil
+}
+
+// version is set for releases with -ldflags "-X main.version=..."
+var version = ""
+
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := buildinfo.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// provenanceComment describes where an archive came from. It survives
+// Guitar Pro rewriting meta.json, which the comment is not part of.
+func provenanceComment(fs *GpxFileSystem) string {
+	comment := fmt.Sprintf("Converted by gpx2gp %s from GPX sha256:%s (%s)", toolVersion(), fs.Source, fs.SourceVersion())
+	switch {
+	case !archiveOptions.Modified.IsZero():
+		comment += " on " + archiveOptions.Modified.UTC().Format(time.RFC3339)
+	case !archiveOptions.Reproducible:
+		comment += " on " + time.Now().UTC().Format(time.RFC3339)
+	}
+	return comment
+}
+
+// useMmap maps input files instead of reading them, so the OS pages in
+// only what the parser touches and drops it again afterwards
+var useMmap = false
+
+// readInput returns the content of the input file and a function that
+// releases it
+func readInput(path string) ([]byte, func() error, error) {
+	if isRemotePath(path) {
+		d, err := readRemote(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d.data, func() error { return nil }, nil
+	}
+	if !useMmap {
+		data, err := os.ReadFile(path)
+		return data, func() error { return nil }, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapFile(f, int(info.Size()))
+}
+
+func readGpx(inputPath string) (*GpxFileSystem, error) {
+	if info, err := os.Stat(inputPath); err == nil {
+		if err := limits.checkInput(int(info.Size())); err != nil {
+			return nil, err
+		}
+	}
+	rawData, release, err := readInput(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	// Hash the input while it is parsed
+	sums := make(chan string, 1)
+	go func() {
+		sum := sha256.Sum256(rawData)
+		sums <- hex.EncodeToString(sum[:])
+	}()
+	// Files stored in consecutive sectors point into the input, which
+	// therefore stays mapped until the file system is closed
+	fs := &GpxFileSystem{Limits: limits, Mode: parseMode, Spill: spillToDisk, release: release, raw: rawData}
+	err = fs.Load(rawData)
+	fs.Source = <-sums
+	if err == nil && fs.File("score.gpif") != nil {
+		err = fs.checkOptional(synthesizeMissing)
+	}
+	if err == nil && fs.Mode != ParseDefault {
+		err = fs.validateScore()
+	}
+	if err != nil {
+		fs.Close()
+		return nil, fmt.Errorf("failed to process GPX: %v", err)
+	}
+	return fs, nil
+}
+
+// migrateScore brings score.gpif up to what Guitar Pro 7 expects, as every
+// command writing a .gp does, returning the tunings labelled and the
+// triplet feel set
+func (fs *GpxFileSystem) migrateScore() ([]TuningLabel, string, error) {
+	labels, err := fs.labelTunings()
+	if err != nil {
+		return nil, "", err
+	}
+	feel, err := fs.setFeel()
+	return labels, feel, err
+}
 
+// withExtension appends ext unless the path already ends with it
+func withExtension(path, ext string) string {
+	if !strings.HasSuffix(strings.ToLower(path), ext) {
+		return path + ext
+	}
+	return path
+}
+
+// checkOutputPath refuses to overwrite the input or an existing file
+func checkOutputPath(inputPath, outputPath string) error {
+	absInput, _ := filepath.Abs(inputPath)
+	absOutput, _ := filepath.Abs(outputPath)
+	if absInput == absOutput {
+		return fmt.Errorf("output filename is the same as input filename")
+	}
+	if exists, err := outputExists(outputPath); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("output file '%s' already exists", outputPath)
+	}
 	return nil
 }
 
+// outputExists reports whether a file or object is stored at path
+func outputExists(path string) (bool, error) {
+	s, err := storageFor(path)
+	if err != nil {
+		return false, err
+	}
+	return s.Exists(path)
+}
+
+// derivedOutputPath names the output after the score's artist and title,
+// falling back to the input name, and numbers it to avoid existing files
+func derivedOutputPath(fs *GpxFileSystem, inputPath, dir string) string {
+	return namedOutputPath(fs.scoreFileName(), inputPath, dir)
+}
+
+// scoreFileName is the output name the score's metadata gives, if any
+func (fs *GpxFileSystem) scoreFileName() string {
+	if score, err := fs.loadScore(); err == nil {
+		return score.scoreFileName()
+	}
+	return ""
+}
+
+// namedOutputPath places an output called name, or after the input when
+// name is empty, in dir without overwriting anything
+func namedOutputPath(name, inputPath, dir string) string {
+	if name == "" {
+		name = safeFileName(strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)))
+	}
+	if name == "" {
+		name = "score"
+	}
+	join := filepath.Join
+	if isRemotePath(dir) {
+		join = func(elem ...string) string { return strings.Join(elem, "") }
+	}
+	path := join(dir, name+".gp")
+	for n := 2; ; n++ {
+		if exists, err := outputExists(path); err != nil || !exists {
+			return path
+		}
+		path = join(dir, fmt.Sprintf("%s (%d).gp", name, n))
+	}
+}
+
+// entryTime resolves the -timestamp flag: "now", "mtime" for the input
+// file's modification time, or a fixed date
+func entryTime(value, inputPath string) (time.Time, error) {
+	switch value {
+	case "":
+		return time.Time{}, nil
+	case "now":
+		return time.Now(), nil
+	case "mtime":
+		if isRemotePath(inputPath) {
+			d, err := readRemote(inputPath)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return d.modified, nil
+		}
+		info, err := os.Stat(inputPath)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if t.Year() < 1980 {
+				return time.Time{}, fmt.Errorf("timestamp %s is before 1980, which zip cannot store", value)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp '%s', use now, mtime or YYYY-MM-DD[THH:MM:SS]", value)
+}
+
+// createOutput opens the output file, or stdout when no path was given
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+// checkExportOutput is checkOutputPath for an output createOutput opens,
+// standard output needs no check
+func checkExportOutput(inputPath, outputPath string) error {
+	if outputPath == "" || outputPath == "-" {
+		return nil
+	}
+	return checkOutputPath(inputPath, outputPath)
+}
+
+// collectInputs expands directories into the .gpx files they contain
+func collectInputs(paths []string) ([]string, error) {
+	var inputs []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			inputs = append(inputs, p)
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && strings.EqualFold(filepath.Ext(e.Name()), ".gpx") {
+				inputs = append(inputs, filepath.Join(p, e.Name()))
+			}
+		}
+	}
+	return inputs, nil
+}
+
+var commands = map[string]func(args []string){
+	"karaoke":         runKaraoke,
+	"share":           runShare,
+	"songbook":        runSongbook,
+	"export":          runExport,
+	"extract":         runExtract,
+	"inspect":         runInspect,
+	"bench":           runBench,
+	"mirror":          runMirror,
+	"watch":           runWatch,
+	"install-shell":   runInstallShell,
+	"uninstall-shell": runUninstallShell,
+	"mount":           runMount,
+	"rpc":             runRPC,
+	"normalize":       runNormalize,
+	"setlist":         runSetlist,
+	"describe":        runDescribe,
+	"report":          runReport,
+	"check":           runCheck,
+	"verify":          runVerify,
+	"repair":          runRepair,
+	"selftest":        runSelftest,
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+		if os.Args[1] == "gen-testdata" {
+			runGenTestdata(os.Args[2:])
+			return
+		}
+	}
+
 	var inputPath string
 	var outputPath string
 
@@ -389,55 +1450,434 @@ func main() {
 	flag.StringVar(&outputPath, "o", "", "Output filename")
 	flag.StringVar(&outputPath, "out", "", "Output filename")
 	flag.BoolVar(&verbose, "v", false, "Verbose output")
+	flag.IntVar(&limits.MaxDecompressedSize, "max-decompressed-size", limits.MaxDecompressedSize, "Maximum decompressed size in bytes (0 = unlimited)")
+	flag.IntVar(&limits.MaxExpansionRatio, "max-expansion-ratio", limits.MaxExpansionRatio, "Maximum decompressed to compressed size ratio (0 = unlimited)")
+	flag.IntVar(&limits.MaxInputSize, "max-input-size", limits.MaxInputSize, "Maximum input file size in bytes (0 = unlimited)")
+	flag.IntVar(&limits.MaxMemory, "max-memory", limits.MaxMemory, "Maximum bytes buffered per conversion (0 = unlimited)")
+
+	strict := flag.Bool("strict", false, "Treat any anomaly in the container or score as fatal")
+	lenient := flag.Bool("lenient", false, "Recover what is possible from damaged files, reporting warnings")
+	jsonOutput := flag.Bool("json", false, "Print the conversion status as JSON")
+	sidecar := flag.Bool("sidecar", false, "Write song.gp.json next to the output, recording the source, options, warnings and file hashes")
+	warningsAsErrors := flag.Bool("warnings-as-errors", false, "Fail the conversion when anything was recovered, dropped or synthesized")
+	flag.BoolVar(&archiveOptions.Reproducible, "reproducible", false, "Write byte-identical archives for identical inputs (fixed order, timestamps and compression)")
+	timestamp := flag.String("timestamp", "", "Stamp archive entries with now, mtime (the input's) or a date YYYY-MM-DD[THH:MM:SS]")
+	flag.BoolVar(&archiveOptions.Provenance, "provenance", false, "Record the gpx2gp version, source hash and date in the zip comment")
+	zipMethod := flag.String("zip-method", "deflate", "Compression of archive entries: store or deflate")
+	flag.IntVar(&archiveOptions.Level, "zip-level", archiveOptions.Level, "Deflate level from 0 (none) to 9 (smallest), -1 for the default")
+	flag.BoolVar(&archiveOptions.Manifest, "manifest", false, "Add a SHA-256 manifest of the content files, checked by 'gpx2gp verify'")
+	flag.BoolVar(&archiveOptions.EmbedSource, "embed-source", false, "Store the original .gpx inside the output as "+sourceEntryName)
+	targetName := flag.String("target", defaultTarget, "Guitar Pro release the output must open in: "+strings.Join(rulesetNames(), ", "))
+	flag.StringVar(&cacheDir, "cache", "", "Reuse and store conversions in this directory, keyed by input hash and options")
+	flag.BoolVar(&cacheLink, "cache-link", false, "Hard-link outputs to the cache instead of copying them")
+	flag.BoolVar(&spillToDisk, "spill", false, "Keep inner files in temporary files when they would exceed -max-memory")
+	flag.BoolVar(&useMmap, "mmap", false, "Map the input file into memory instead of reading it")
+	flag.BoolVar(&synthesizeMissing, "synthesize-missing", false, "Write empty stand-ins for missing PartConfiguration and BinaryStylesheet files")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile to this file")
+	memProfile := flag.String("memprofile", "", "Write a heap profile to this file at exit")
+	traceFile := flag.String("trace", "", "Write an execution trace to this file")
+	audioPath := flag.String("audio", "", "Add this audio file as the backing track: "+strings.Join(sortedAudioExts(), ", "))
+	coverPath := flag.String("cover", "", "Store this PNG or JPEG image in the output as cover artwork")
+	flag.StringVar(&fileNameChars, "filename-chars", fileNameChars, "Characters in output names derived from the score: "+strings.Join(fileNameCharsModes, ", "))
+	flag.Var(&mixerFlags.mute, "mute", "Start with this track muted, by name or number; repeat for more tracks")
+	flag.Var(&mixerFlags.solo, "solo", "Start with this track soloed, by name or number; repeat for more tracks")
+	flag.Var(&mixerFlags.volume, "volume", "Start a track at a volume, as track=percent; repeat for more tracks")
+	splitSections := flag.Bool("split-sections", false, "Also write every section of the score to a file of its own, named after the output and the section")
+	simplify := flag.Bool("simplify", false, "Also write song.simple.gp, a practice version without ornaments, grace notes, dense tuplets and secondary voices")
+	flag.BoolVar(&verifyContent, "verify-content", false, "Compare the beats and notes of every bar before and after the conversion's transforms, reporting any track that differs")
+	flag.BoolVar(&repairContinuity, "repair-continuity", false, "Complete or remove ties whose other end is missing and remove grace notes with no note after them")
+	flag.BoolVar(&repairDirections, "repair-directions", false, "Remove D.C., D.S. and coda directions that do not resolve and make repeat counts sane")
+	flag.Var(&forcedFeel, "feel", "Set the triplet feel of every bar, or of bars first-last as feel:first-last: straight, triplet8, triplet16, dotted8, dotted16, scottish8 or scottish16")
+	flag.Var(&restringFlags, "strings", "Move a track onto this many strings, as track=count, refingering its notes; repeat for more tracks")
+	trackRulesPath := flag.String("track-rules", "", "Rename tracks by the regular expression rules in this JSON file")
+	translatePath := flag.String("translate", "", "Replace the score's texts with the translations in this file, as written by 'gpx2gp export texts'")
+	gpInput := flag.String("gp-input", "skip", "Inputs that are already .gp files: skip, copy, normalize or error")
+	flag.StringVar(&webhookURL, "webhook", "", "POST the conversion status as JSON to this URL when done")
 
 	flag.Parse()
 
-	if inputPath == "" || outputPath == "" {
-		fmt.Println("Usage: gpx2gp -f <input.gpx> -o <output_filename> [-v]")
+	if *strict && *lenient {
+		fmt.Println("Error: -strict and -lenient are mutually exclusive.")
 		os.Exit(1)
 	}
+	if *strict {
+		parseMode = ParseStrict
+	} else if *lenient {
+		parseMode = ParseLenient
+	}
 
-	// Ensure extension is .gp
-	if !strings.HasSuffix(strings.ToLower(outputPath), ".gp") {
-		outputPath += ".gp"
+	if inputPath == "" || outputPath == "" {
+		fmt.Println("Usage: gpx2gp -f <input.gpx> -o <output_filename|dir> [-strict|-lenient] [-json] [-v]")
+		fmt.Println("       gpx2gp <command> [options]")
+		fmt.Printf("Commands: %s\n", strings.Join(commandNames(), ", "))
+		os.Exit(1)
 	}
 
-	// Check for collision with input file
-	absInput, _ := filepath.Abs(inputPath)
-	absOutput, _ := filepath.Abs(outputPath)
-	if absInput == absOutput {
-		fmt.Println("Error: Output filename is the same as input filename.")
+	switch *zipMethod {
+	case "store":
+		archiveOptions.Method = zip.Store
+	case "deflate":
+		archiveOptions.Method = zip.Deflate
+	default:
+		fmt.Printf("Error: unknown zip method '%s', use store or deflate.\n", *zipMethod)
+		os.Exit(1)
+	}
+	if webhookURL != "" {
+		if err := checkWebhookURL(webhookURL); err != nil {
+			fmt.Printf("Error: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+	if cacheDir != "" && isRemotePath(outputPath) {
+		fmt.Println("Error: -cache needs a local output.")
 		os.Exit(1)
 	}
+	if *sidecar && isRemotePath(outputPath) {
+		fmt.Println("Error: -sidecar needs a local output.")
+		os.Exit(1)
+	}
+	if *translatePath != "" {
+		var err error
+		if translation, translationSum, err = loadTranslation(*translatePath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *audioPath != "" {
+		var err error
+		if backingAudio, audioSum, err = loadAudio(*audioPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := checkFileNameChars(fileNameChars); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+	if *trackRulesPath != "" {
+		var err error
+		if trackRules, trackRulesSum, err = loadTrackRules(*trackRulesPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *coverPath != "" {
+		var err error
+		if coverImage, coverSum, err = loadCover(*coverPath); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if archiveOptions.Level < flate.DefaultCompression || archiveOptions.Level > flate.BestCompression {
+		fmt.Printf("Error: zip level %d is out of range, use 0 to 9.\n", archiveOptions.Level)
+		os.Exit(1)
+	}
+	if target = rulesets[*targetName]; target == nil {
+		fmt.Printf("Error: unknown target '%s', use %s.\n", *targetName, strings.Join(rulesetNames(), ", "))
+		os.Exit(1)
+	}
+	if _, ok := gpInputModes[*gpInput]; !ok {
+		fmt.Printf("Error: unknown -gp-input mode '%s', use skip, copy, normalize or error.\n", *gpInput)
+		os.Exit(1)
+	}
+	if *timestamp == "now" && archiveOptions.Reproducible {
+		fmt.Println("Error: -timestamp now cannot be reproducible, use a fixed date or mtime.")
+		os.Exit(1)
+	}
+	modified, err := entryTime(*timestamp, inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	archiveOptions.Modified = modified
 
-	// Check if output file already exists
-	if _, err := os.Stat(outputPath); err == nil {
-		fmt.Printf("Error: Output file '%s' already exists.\n", outputPath)
+	if err := startProfiling(*cpuProfile, *memProfile, *traceFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	status := &ConversionStatus{Input: inputPath}
 	start := time.Now()
-	fmt.Printf("Reading: %s\n", inputPath)
+	fail := func(err error) {
+		status.Error = err.Error()
+		status.finish(start, *jsonOutput)
+	}
+	// Progress goes to stdout unless the summary is printed as JSON
+	var out io.Writer = os.Stdout
+	if *jsonOutput {
+		out = io.Discard
+	}
+
+	// A Guitar Pro 7 input needs no conversion; "error" lets it fail below
+	var gpData []byte
+	var gpArchive *zip.Reader
+	if *gpInput != "error" {
+		gpData, gpArchive, err = readGpInput(inputPath)
+		if err != nil {
+			fail(err)
+		}
+		if gpArchive != nil && *gpInput == "skip" {
+			fmt.Fprintf(out, "Skipping: %s is already a Guitar Pro 7 file.\n", inputPath)
+			status.Skipped = true
+			status.finish(start, *jsonOutput)
+		}
+	}
 
-	rawData, err := os.ReadFile(inputPath)
+	// An existing directory as output names the file after the score, as
+	// does a remote prefix ending in a slash
+	outputDir := ""
+	if info, err := os.Stat(outputPath); err == nil && info.IsDir() {
+		outputDir = outputPath
+	} else if isRemotePath(outputPath) && strings.HasSuffix(outputPath, "/") {
+		outputDir = outputPath
+	} else {
+		// Ensure extension is .gp
+		outputPath = withExtension(outputPath, ".gp")
+
+		// Check for collision with input file and existing output
+		if err := checkOutputPath(inputPath, outputPath); err != nil {
+			fail(err)
+		}
+		if *simplify {
+			if err := checkOutputPath(inputPath, simplifiedPath(outputPath)); err != nil {
+				fail(err)
+			}
+		}
+	}
+
+	if gpArchive != nil {
+		if *translatePath != "" {
+			fail(fmt.Errorf("-translate needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if len(mixerFlags.mute)+len(mixerFlags.solo)+len(mixerFlags.volume) > 0 {
+			fail(fmt.Errorf("-mute, -solo and -volume need a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if verifyContent {
+			fail(fmt.Errorf("-verify-content needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if repairContinuity {
+			fail(fmt.Errorf("-repair-continuity needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if repairDirections {
+			fail(fmt.Errorf("-repair-directions needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if forcedFeel.feel != "" {
+			fail(fmt.Errorf("-feel needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if len(restringFlags) > 0 {
+			fail(fmt.Errorf("-strings needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if *trackRulesPath != "" {
+			fail(fmt.Errorf("-track-rules needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if *coverPath != "" {
+			fail(fmt.Errorf("-cover needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if *audioPath != "" {
+			fail(fmt.Errorf("-audio needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if *splitSections {
+			fail(fmt.Errorf("-split-sections needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		if *simplify {
+			fail(fmt.Errorf("-simplify needs a .gpx input, %s is already a .gp file", inputPath))
+		}
+		status.Files = len(gpArchive.File)
+		if outputDir != "" {
+			outputPath = derivedOutputPath(gpScoreOnly(gpArchive), inputPath, outputDir)
+		}
+		fmt.Fprintf(out, "%s is already a Guitar Pro 7 file, %s it to: %s\n", inputPath, map[string]string{"copy": "copying", "normalize": "normalizing"}[*gpInput], outputPath)
+		if err := passGpInput(gpData, gpArchive, outputPath, *gpInput); err != nil {
+			os.Remove(outputPath)
+			fail(fmt.Errorf("writing archive: %v", err))
+		}
+		if err := conformTarget(outputPath); err != nil {
+			os.Remove(outputPath)
+			fail(err)
+		}
+		if *sidecar {
+			sum, err := hashFile(inputPath)
+			if err == nil {
+				err = writeSidecar(Sidecar{Input: inputPath, InputSHA256: sum, Output: outputPath})
+			}
+			if err != nil {
+				fail(fmt.Errorf("writing sidecar: %v", err))
+			}
+		}
+		status.Output = outputPath
+		status.finish(start, *jsonOutput)
+	}
+
+	report := func(warnings []string) {
+		for _, w := range warnings {
+			fmt.Fprintf(out, "Warning: %s\n", w)
+		}
+		if *warningsAsErrors && len(warnings) > 0 {
+			fail(fmt.Errorf("%d warning(s) treated as errors, the first: %s", len(warnings), warnings[0]))
+		}
+	}
+
+	// An unchanged input converted with the same options before. The cache
+	// holds one archive per input, so -simplify and -split-sections bypass it.
+	key := ""
+	if cacheDir != "" && !*simplify && !*splitSections {
+		sum, err := hashFile(inputPath)
+		if err != nil {
+			fail(fmt.Errorf("failed to read file: %v", err))
+		}
+		key = cacheKey(sum)
+		if entry := lookupCache(key); entry != nil {
+			status.Files, status.Partial, status.Warnings = entry.Files, entry.Partial, entry.Warnings
+			report(entry.Warnings)
+			if outputDir != "" {
+				outputPath = namedOutputPath(entry.Name, inputPath, outputDir)
+			}
+			fmt.Fprintf(out, "Unchanged since an earlier conversion, restoring the cached archive to: %s\n", outputPath)
+			if err := restoreCached(key, outputPath); err != nil {
+				fail(fmt.Errorf("restoring cached archive: %v", err))
+			}
+			if *sidecar {
+				if err := writeSidecar(Sidecar{Input: inputPath, InputSHA256: sum, Output: outputPath, Partial: entry.Partial, Warnings: entry.Warnings}); err != nil {
+					fail(fmt.Errorf("writing sidecar: %v", err))
+				}
+			}
+			status.Output = outputPath
+			status.finish(start, *jsonOutput)
+		}
+	}
+
+	fmt.Fprintf(out, "Reading: %s\n", inputPath)
+
+	fs, err := readGpx(inputPath)
 	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
-		os.Exit(1)
+		fail(err)
+	}
+	atExit(func() { fs.Close() })
+	var original *Gpif
+	if verifyContent {
+		if original, err = fs.loadScore(); err != nil {
+			fail(err)
+		}
 	}
+	if *translatePath != "" {
+		n, err := fs.translate(translation)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Fprintf(out, "Translated %d texts.\n", n)
+	}
+	if *trackRulesPath != "" {
+		changes, err := fs.renameTracks(trackRules)
+		if err != nil {
+			fail(err)
+		}
+		for _, c := range changes {
+			fmt.Fprintf(out, "Renamed track %s\n", c)
+		}
+	}
+	if restrung, err := fs.restring(); err != nil {
+		fail(err)
+	} else {
+		for _, r := range restrung {
+			fmt.Fprintf(out, "Strings: %s\n", r)
+		}
+	}
+	if fixes, err := fs.reviewDirections(repairDirections); err != nil {
+		fail(err)
+	} else {
+		for _, f := range fixes {
+			fmt.Fprintf(out, "Directions: %s\n", f)
+		}
+	}
+	if fixes, err := fs.reviewContinuity(repairContinuity); err != nil {
+		fail(err)
+	} else {
+		for _, f := range fixes {
+			fmt.Fprintf(out, "Ties and grace notes: %s\n", f)
+		}
+	}
+	if labels, feel, err := fs.migrateScore(); err != nil {
+		fail(err)
+	} else {
+		for _, l := range labels {
+			fmt.Fprintf(out, "Tuning: %s in %s\n", l.Track, l.Tuning)
+		}
+		if feel != "" {
+			fmt.Fprintf(out, "Triplet feel: %s\n", feel)
+		}
+	}
+	if mixed, err := fs.setMixer(); err != nil {
+		fail(err)
+	} else if len(mixed) > 0 {
+		fmt.Fprintf(out, "Mixer: %s\n", strings.Join(mixed, ", "))
+	}
+	if name, err := fs.carryAudio(backingAudio); err != nil {
+		fail(err)
+	} else if name != "" {
+		fmt.Fprintf(out, "Backing track: %s\n", name)
+	}
+	if coverImage != nil {
+		fs.setCover(coverImage)
+	}
+	if original != nil {
+		same, err := fs.checkContent(original)
+		if err != nil {
+			fail(err)
+		}
+		for _, t := range same {
+			fmt.Fprintf(out, "Content check: %s\n", t)
+		}
+	}
+	status.Files = len(fs.Files)
+	status.Partial = fs.Partial
+	status.Warnings = fs.Warnings
+	report(fs.Warnings)
 
-	fs := &GpxFileSystem{}
-	if err := fs.Load(rawData); err != nil {
-		fmt.Printf("Error processing GPX: %v\n", err)
-		os.Exit(1)
+	if outputDir != "" {
+		outputPath = derivedOutputPath(fs, inputPath, outputDir)
 	}
 
-	fmt.Printf("Found %d raw files. Writing archive to: %s\n", len(fs.Files), outputPath)
+	fmt.Fprintf(out, "Found %d raw files. Writing archive to: %s\n", len(fs.Files), outputPath)
 
 	if err := createGpArchive(outputPath, fs); err != nil {
-		fmt.Printf("Error creating archive: %v\n", err)
 		os.Remove(outputPath)
-		os.Exit(1)
+		fail(fmt.Errorf("creating archive: %v", err))
 	}
-
-	fmt.Printf("Success! Converted in %v.\n", time.Since(start))
+	if err := conformTarget(outputPath); err != nil {
+		os.Remove(outputPath)
+		fail(err)
+	}
+	if *simplify {
+		simplePath := simplifiedPath(outputPath)
+		p, err := writeSimplified(fs, simplePath)
+		if err != nil {
+			fail(fmt.Errorf("writing simplified version: %v", err))
+		}
+		fmt.Fprintf(out, "Wrote simplified version to: %s (%s)\n", simplePath, p.summary())
+	}
+	if *splitSections {
+		paths, err := writeSections(fs, inputPath, outputPath)
+		if err != nil {
+			fail(fmt.Errorf("writing sections: %v", err))
+		}
+		if len(paths) == 0 {
+			fmt.Fprintln(out, "Note: the score has no sections to split at")
+		}
+		for _, p := range paths {
+			fmt.Fprintf(out, "Wrote section to: %s\n", p)
+		}
+	}
+	if key != "" {
+		entry := cacheEntry{Name: fs.scoreFileName(), Files: len(fs.Files), Partial: fs.Partial, Warnings: fs.Warnings}
+		if err := storeCache(key, outputPath, entry); err != nil {
+			fmt.Fprintf(out, "Warning: the archive was not cached: %v\n", err)
+		}
+	}
+	if *sidecar {
+		if err := writeSidecar(Sidecar{Input: inputPath, InputSHA256: fs.Source, Output: outputPath, Partial: fs.Partial, Warnings: fs.Warnings}); err != nil {
+			fail(fmt.Errorf("writing sidecar: %v", err))
+		}
+	}
+	status.Output = outputPath
+	status.finish(start, *jsonOutput)
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// manifestName is the archive entry listing the SHA-256 of every Content/
+// file, in sha256sum format so it can also be checked after unzipping
+const manifestName = "gpx2gp.sha256"
+
+func manifestLine(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return manifestSumLine(name, sum[:])
+}
+
+// manifestSumLine formats a line for a hash computed elsewhere
+func manifestSumLine(name string, sum []byte) string {
+	return hex.EncodeToString(sum) + "  " + name + "\n"
+}
+
+// parseManifest reads "hash  name" lines into a name to hash map
+func parseManifest(data []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		hash, name, ok := strings.Cut(line, "  ")
+		if !ok || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s line %d is malformed", manifestName, n)
+		}
+		sums[name] = strings.ToLower(hash)
+	}
+	return sums, sc.Err()
+}
+
+// verifyManifest re-hashes the Content/ files of an archive against its
+// manifest, returning one message per problem
+func verifyManifest(zr *zip.Reader) ([]string, error) {
+	data, err := readZipEntry(zr, manifestName)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("archive has no %s manifest, convert with -manifest to add one", manifestName)
+	}
+	sums, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "Content/") || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		want, ok := sums[f.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: not listed in the manifest", f.Name))
+			continue
+		}
+		delete(sums, f.Name)
+		content, err := readZipEntry(zr, f.Name)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f.Name, err))
+			continue
+		}
+		sum := sha256.Sum256(content)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch, expected %s, found %s", f.Name, want, got))
+		}
+		debug("Verified %s", f.Name)
+	}
+	var missing []string
+	for name := range sums {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		problems = append(problems, fmt.Sprintf("%s: listed in the manifest but missing", name))
+	}
+	return problems, nil
+}
+
+func runVerify(args []string) {
+	cmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if cmd.NArg() == 0 {
+		fmt.Println("Usage: gpx2gp verify <song.gp>...")
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, path := range cmd.Args() {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			fmt.Printf("%s: cannot open archive: %v\n", path, err)
+			failed++
+			continue
+		}
+		problems, err := verifyManifest(&zr.Reader)
+		zr.Close()
+		switch {
+		case err != nil:
+			fmt.Printf("%s: %v\n", path, err)
+			failed++
+		case len(problems) > 0:
+			fmt.Printf("%s: FAILED, %d problem(s):\n", path, len(problems))
+			for _, p := range problems {
+				fmt.Printf("  - %s\n", p)
+			}
+			failed++
+		default:
+			fmt.Printf("%s: OK\n", path)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
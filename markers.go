@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Marker is a named position on the playback timeline
+type Marker struct {
+	Tick    int
+	Seconds float64
+	Name    string
+}
+
+// Name joins the rehearsal letter and the section text
+func (s *GpifSection) Name() string {
+	letter, text := strings.TrimSpace(s.Letter), strings.TrimSpace(s.Text)
+	switch {
+	case letter != "" && text != "":
+		return letter + " " + text
+	case text != "":
+		return text
+	}
+	return letter
+}
+
+// SectionMarkers lists every section start in playback order, repeated
+// sections get a marker on each pass
+func (g *Gpif) SectionMarkers(tl *Timeline) []Marker {
+	var markers []Marker
+	for _, tb := range tl.Bars {
+		s := g.MasterBars[tb.Index].Section
+		if s == nil || s.Name() == "" {
+			continue
+		}
+		markers = append(markers, Marker{Tick: tb.Tick, Seconds: tl.Seconds(tb.Tick), Name: s.Name()})
+	}
+	return markers
+}
+
+func formatBpm(bpm float64) string {
+	return strconv.FormatFloat(bpm, 'f', -1, 64)
+}
+
+func reaperTime(seconds float64) string {
+	millis := int(seconds*1000 + 0.5)
+	return fmt.Sprintf("%d:%02d.%03d", millis/60000, millis/1000%60, millis%1000)
+}
+
+// writeMarkersCsv writes the Region/Marker Manager CSV layout Reaper imports
+func writeMarkersCsv(w io.Writer, sections []Marker, tl *Timeline) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"#", "Name", "Start", "End", "Length"})
+	n := 0
+	tempos := tl.Tempos
+	emit := func(m Marker) {
+		n++
+		cw.Write([]string{"M" + strconv.Itoa(n), m.Name, reaperTime(m.Seconds), "", ""})
+	}
+	for _, s := range sections {
+		for len(tempos) > 0 && tempos[0].Tick <= s.Tick {
+			emit(Marker{Tick: tempos[0].Tick, Seconds: tl.Seconds(tempos[0].Tick), Name: "Tempo " + formatBpm(tempos[0].BPM)})
+			tempos = tempos[1:]
+		}
+		emit(s)
+	}
+	for _, t := range tempos {
+		emit(Marker{Tick: t.Tick, Seconds: tl.Seconds(t.Tick), Name: "Tempo " + formatBpm(t.BPM)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func runExportMarkers(args []string) {
+	cmd := flag.NewFlagSet("export markers", flag.ExitOnError)
+	var inputPath, outputPath, format string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout for csv)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout for csv)")
+	cmd.StringVar(&format, "format", "csv", "Output format: csv (Reaper marker list) or midi (markers and tempo map)")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" || (format == "midi" && outputPath == "") {
+		fmt.Println("Usage: gpx2gp export markers -f <input.gpx> [-o <output>] [-format csv|midi]")
+		os.Exit(1)
+	}
+	if format != "csv" && format != "midi" {
+		fmt.Printf("Error: unknown format '%s'.\n", format)
+		os.Exit(1)
+	}
+	if err := checkExportOutput(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	tl := score.BuildTimeline()
+	sections := score.SectionMarkers(tl)
+
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+
+	if format == "midi" {
+		conductor := score.conductorTrack(tl)
+		for _, m := range sections {
+			conductor.Marker(m.Tick, m.Name)
+		}
+		err = writeMidiFile(w, []*MidiTrack{conductor})
+	} else {
+		err = writeMarkersCsv(w, sections, tl)
+	}
+	if err != nil {
+		fmt.Printf("Error writing markers: %v\n", err)
+		os.Exit(1)
+	}
+}
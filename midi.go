@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// Standard MIDI file writer (format 1)
+type MidiTrack struct {
+	events []midiEvent
+}
+
+type midiEvent struct {
+	tick  int
+	order int // tie breaker at equal ticks, note-offs go first
+	data  []byte
+}
+
+func (t *MidiTrack) add(tick, order int, data ...byte) {
+	t.events = append(t.events, midiEvent{tick: tick, order: order, data: data})
+}
+
+func (t *MidiTrack) meta(tick int, kind byte, payload []byte) {
+	data := append([]byte{0xFF, kind}, varLen(len(payload))...)
+	t.add(tick, 0, append(data, payload...)...)
+}
+
+func (t *MidiTrack) Name(name string) {
+	t.meta(0, 0x03, []byte(name))
+}
+
+func (t *MidiTrack) Marker(tick int, text string) {
+	t.meta(tick, 0x06, []byte(text))
+}
+
+func (t *MidiTrack) Lyric(tick int, text string) {
+	t.meta(tick, 0x05, []byte(text))
+}
+
+func (t *MidiTrack) Tempo(tick int, bpm float64) {
+	usPerQuarter := int(60000000 / bpm)
+	t.meta(tick, 0x51, []byte{byte(usPerQuarter >> 16), byte(usPerQuarter >> 8), byte(usPerQuarter)})
+}
+
+func (t *MidiTrack) TimeSignature(tick, num, den int) {
+	power := 0
+	for d := den; d > 1; d >>= 1 {
+		power++
+	}
+	t.meta(tick, 0x58, []byte{byte(num), byte(power), 24, 8})
+}
+
+func (t *MidiTrack) Program(tick int, channel, program int) {
+	t.add(tick, 1, 0xC0|byte(channel&0x0F), byte(program&0x7F))
+}
+
+func (t *MidiTrack) Note(tick, ticks, channel, pitch, velocity int) {
+	if pitch < 0 || pitch > 127 {
+		return
+	}
+	t.add(tick, 2, 0x90|byte(channel&0x0F), byte(pitch), byte(velocity&0x7F))
+	t.add(tick+ticks, 0, 0x80|byte(channel&0x0F), byte(pitch), 0)
+}
+
+func varLen(v int) []byte {
+	buf := []byte{byte(v & 0x7F)}
+	for v >>= 7; v > 0; v >>= 7 {
+		buf = append([]byte{byte(v&0x7F) | 0x80}, buf...)
+	}
+	return buf
+}
+
+func (t *MidiTrack) encode() []byte {
+	sort.SliceStable(t.events, func(i, j int) bool {
+		if t.events[i].tick != t.events[j].tick {
+			return t.events[i].tick < t.events[j].tick
+		}
+		return t.events[i].order < t.events[j].order
+	})
+	var out []byte
+	last := 0
+	for _, e := range t.events {
+		out = append(out, varLen(e.tick-last)...)
+		out = append(out, e.data...)
+		last = e.tick
+	}
+	return append(out, 0x00, 0xFF, 0x2F, 0x00)
+}
+
+func writeMidiFile(w io.Writer, tracks []*MidiTrack) error {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 14)
+	copy(header, "MThd")
+	binary.BigEndian.PutUint32(header[4:], 6)
+	binary.BigEndian.PutUint16(header[8:], 1)
+	binary.BigEndian.PutUint16(header[10:], uint16(len(tracks)))
+	binary.BigEndian.PutUint16(header[12:], ticksPerQuarter)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	for _, t := range tracks {
+		data := t.encode()
+		chunk := make([]byte, 8)
+		copy(chunk, "MTrk")
+		binary.BigEndian.PutUint32(chunk[4:], uint32(len(data)))
+		if _, err := bw.Write(chunk); err != nil {
+			return err
+		}
+		if _, err := bw.Write(data); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// conductorTrack builds the tempo and time signature map of the score, tempo
+// ramps stepped a beat at a time
+func (g *Gpif) conductorTrack(tl *Timeline) *MidiTrack {
+	t := &MidiTrack{}
+	t.Name(g.Score.Title)
+	lastNum, lastDen := 0, 0
+	for _, tb := range tl.Bars {
+		num, den := g.MasterBars[tb.Index].TimeSignature()
+		if num != lastNum || den != lastDen {
+			t.TimeSignature(tb.Tick, num, den)
+			lastNum, lastDen = num, den
+		}
+	}
+	for _, tempo := range tl.TempoSteps() {
+		t.Tempo(tempo.Tick, tempo.BPM)
+	}
+	return t
+}
+
+// noteTracks renders the selected score tracks, one MIDI track each
+func (g *Gpif) noteTracks(tl *Timeline, include func(track int) bool) []*MidiTrack {
+	var tracks []*MidiTrack
+	channel := 0
+	for i := range g.Tracks {
+		if !include(i) {
+			continue
+		}
+		track := &g.Tracks[i]
+		t := &MidiTrack{}
+		t.Name(track.Name)
+
+		ch := 9
+		if !track.IsPercussion() {
+			ch = channel
+			channel++
+			if channel == 9 {
+				channel++
+			}
+			channel %= 16
+			t.Program(0, ch, track.GeneralMidi.Program)
+		}
+		for _, n := range g.TrackNotes(tl, i) {
+			t.Note(n.Tick, n.Ticks, ch, n.Pitch, n.Velocity)
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks
+}
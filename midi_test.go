@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// midiTempos reads the tempo events back out of an encoded track of meta
+// events, as microseconds per quarter note by tick
+func midiTempos(t *testing.T, data []byte) map[int]int {
+	t.Helper()
+	readVarLen := func() int {
+		v := 0
+		for len(data) > 0 {
+			b := data[0]
+			data = data[1:]
+			v = v<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		return v
+	}
+	tempos := make(map[int]int)
+	tick := 0
+	for len(data) > 0 {
+		tick += readVarLen()
+		if len(data) < 2 || data[0] != 0xFF {
+			t.Fatalf("not a meta event at tick %d: % x", tick, data)
+		}
+		kind := data[1]
+		data = data[2:]
+		n := readVarLen()
+		if kind == 0x51 {
+			tempos[tick] = int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		}
+		data = data[n:]
+	}
+	return tempos
+}
+
+func TestConductorTrackFollowsRamps(t *testing.T) {
+	fs, _ := fixtureScore(t)
+	score, err := fs.loadScore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture goes from 120 to 90 at bar 3; ramp there instead
+	ramped := false
+	for i, a := range score.MasterTrack.Automations {
+		if a.Type == "Tempo" && a.Bar == 0 {
+			score.MasterTrack.Automations[i].Linear, ramped = true, true
+		}
+	}
+	if !ramped {
+		t.Fatal("the fixture has no tempo on bar 1")
+	}
+	tl := score.BuildTimeline()
+	tempos := midiTempos(t, score.conductorTrack(tl).encode())
+	if len(tempos) <= len(tl.Tempos) {
+		t.Fatalf("%d tempo events for %d changes, the ramp is not stepped", len(tempos), len(tl.Tempos))
+	}
+
+	// Play the MIDI tempo map a tick at a time and compare on every beat
+	seconds, us := 0.0, 0
+	for tick := 0; tick <= tl.TotalTicks(); tick++ {
+		if tick%ticksPerQuarter == 0 {
+			if want := tl.Seconds(tick); math.Abs(seconds-want) > 0.001 {
+				t.Fatalf("tick %d plays at %.4fs in MIDI, %.4fs on the timeline", tick, seconds, want)
+			}
+		}
+		if v, ok := tempos[tick]; ok {
+			us = v
+		}
+		seconds += float64(us) / 1e6 / ticksPerQuarter
+	}
+}
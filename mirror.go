@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Incremental conversion of a library into a mirrored tree of .gp files.
+// A state file in the output tree remembers every source by size,
+// modification time and hash, so later runs only convert what changed.
+
+// mirrorStateName is the state file kept at the root of the output tree
+const mirrorStateName = ".gpx2gp-state.json"
+
+// mirrorSource is what a run knew about one source file
+type mirrorSource struct {
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	SHA256   string    `json:"sha256"`
+	Output   string    `json:"output"` // relative to the output tree
+}
+
+// mirrorState maps source paths, relative to the source tree and slash
+// separated, to what the last successful conversion saw
+type mirrorState map[string]mirrorSource
+
+func loadMirrorState(path string) (mirrorState, error) {
+	state := make(mirrorState)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return state, nil
+}
+
+// save replaces the state file through a temporary file
+func (s mirrorState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// mirrorSources lists the .gpx files below root, relative and slash separated
+func mirrorSources(root string) ([]string, error) {
+	var sources []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".gpx") {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(sources)
+	return sources, err
+}
+
+// mirrorConvert converts one source into outputPath, replacing any
+// previous output only once the new one passed the checks
+func mirrorConvert(inputPath, outputPath string) (*GpxFileSystem, error) {
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+	if _, _, err := fs.migrateScore(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return nil, err
+	}
+	tmp := outputPath + ".tmp"
+	if err := createGpArchive(tmp, fs); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("creating archive: %v", err)
+	}
+	if err := conformTarget(tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return fs, os.Rename(tmp, outputPath)
+}
+
+// convertTo runs mirrorConvert, describing the outcome as a finished
+// status, which is also sent to the webhook
+func convertTo(inputPath, outputPath string) *ConversionStatus {
+	start := time.Now()
+	status := &ConversionStatus{Input: inputPath, Warnings: []string{}}
+	if fs, err := mirrorConvert(inputPath, outputPath); err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Output, status.Files, status.Partial = outputPath, len(fs.Files), fs.Partial
+		status.Warnings = append(status.Warnings, fs.Warnings...)
+	}
+	status.exitCode()
+	status.Seconds = time.Since(start).Seconds()
+	status.notify()
+	return status
+}
+
+func runMirror(args []string) {
+	cmd := flag.NewFlagSet("mirror", flag.ExitOnError)
+	prune := cmd.Bool("prune-orphans", false, "Delete outputs whose source no longer exists")
+	resume := cmd.Bool("resume", false, "Continue an interrupted run, skipping the sources its journal records as done")
+	strict := cmd.Bool("strict", false, "Treat any anomaly in the container or score as fatal")
+	lenient := cmd.Bool("lenient", false, "Recover what is possible from damaged files, reporting warnings")
+	cmd.StringVar(&webhookURL, "webhook", "", "POST the status of every conversion as JSON to this URL")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 2 {
+		fmt.Println("Usage: gpx2gp mirror [-prune-orphans] [-resume] [-strict|-lenient] [-webhook URL] <source dir> <output dir>")
+		os.Exit(1)
+	}
+	if webhookURL != "" {
+		if err := checkWebhookURL(webhookURL); err != nil {
+			fmt.Printf("Error: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+	if *strict && *lenient {
+		fmt.Println("Error: -strict and -lenient are mutually exclusive.")
+		os.Exit(1)
+	}
+	if *strict {
+		parseMode = ParseStrict
+	} else if *lenient {
+		parseMode = ParseLenient
+	}
+	sourceDir, outputDir := cmd.Arg(0), cmd.Arg(1)
+
+	statePath := filepath.Join(outputDir, mirrorStateName)
+	state, err := loadMirrorState(statePath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	sources, err := mirrorSources(sourceDir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	journalPath := filepath.Join(outputDir, mirrorJournalName)
+	done := make(map[string]bool)
+	if *resume {
+		if done, err = replayJournal(journalPath, state); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Resuming: %s already done.\n", plural(len(done), "source"))
+	} else if _, err := os.Stat(journalPath); err == nil {
+		fmt.Println("Note: starting over, the journal of an interrupted run is discarded. -resume continues it instead.")
+	}
+	journal, err := openJournal(journalPath, *resume)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	record := func(rel string, source *mirrorSource) {
+		if err := journal.record(rel, source); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	start := time.Now()
+	var converted, unchanged, failed, warned, pruned, resumed int
+	present := make(map[string]bool)
+	for _, rel := range sources {
+		present[rel] = true
+		if done[rel] {
+			resumed++
+			continue
+		}
+		inputPath := filepath.Join(sourceDir, filepath.FromSlash(rel))
+		output := strings.TrimSuffix(rel, filepath.Ext(rel)) + ".gp"
+		outputPath := filepath.Join(outputDir, filepath.FromSlash(output))
+
+		info, err := os.Stat(inputPath)
+		if err != nil {
+			fmt.Printf("Error: %s: %v\n", rel, err)
+			failed++
+			continue
+		}
+		prev, known := state[rel]
+		_, statErr := os.Stat(outputPath)
+		outputExists := statErr == nil
+		if known && outputExists && prev.Size == info.Size() && prev.Modified.Equal(info.ModTime()) {
+			unchanged++
+			continue
+		}
+		sum, err := hashFile(inputPath)
+		if err != nil {
+			fmt.Printf("Error: %s: %v\n", rel, err)
+			failed++
+			continue
+		}
+		current := mirrorSource{Size: info.Size(), Modified: info.ModTime(), SHA256: sum, Output: output}
+		if known && outputExists && prev.SHA256 == sum {
+			// Touched but not changed
+			state[rel] = current
+			record(rel, &current)
+			unchanged++
+			continue
+		}
+
+		status := convertTo(inputPath, outputPath)
+		if status.Error != "" {
+			fmt.Printf("Error: %s: %s\n", rel, status.Error)
+			failed++
+			continue
+		}
+		for _, w := range status.Warnings {
+			fmt.Printf("Warning: %s: %s\n", rel, w)
+		}
+		if status.Status == "warnings" {
+			warned++
+		}
+		if known && prev.Output != output {
+			os.Remove(filepath.Join(outputDir, filepath.FromSlash(prev.Output)))
+		}
+		state[rel] = current
+		record(rel, &current)
+		converted++
+		fmt.Printf("Converted %s\n", rel)
+	}
+
+	for _, rel := range sortedStateKeys(state) {
+		if present[rel] || done[rel] {
+			continue
+		}
+		if !*prune {
+			debug("Source %s is gone, keeping %s", rel, state[rel].Output)
+			continue
+		}
+		output := state[rel].Output
+		if err := os.Remove(filepath.Join(outputDir, filepath.FromSlash(output))); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Error: %s: %v\n", output, err)
+			failed++
+			continue
+		}
+		delete(state, rel)
+		record(rel, nil)
+		pruned++
+		fmt.Printf("Pruned %s\n", output)
+	}
+
+	if err := state.save(statePath); err != nil {
+		fmt.Printf("Error: saving %s: %v\n", statePath, err)
+		os.Exit(1)
+	}
+	if err := journal.finish(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if resumed > 0 {
+		fmt.Printf("%d done before resuming, ", resumed)
+	}
+	fmt.Printf("%d converted, %d unchanged, %d pruned, %d failed in %v.\n", converted, unchanged, pruned, failed, time.Since(start))
+	switch {
+	case failed > 0:
+		os.Exit(exitError)
+	case warned > 0:
+		os.Exit(exitWarnings)
+	}
+}
+
+func sortedStateKeys(state mirrorState) []string {
+	keys := make([]string, 0, len(state))
+	for k := range state {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMirrorConvertLeavesScore converts without options, which must carry
+// score.gpif over byte for byte, CDATA sections included
+func TestMirrorConvertLeavesScore(t *testing.T) {
+	_, before := fixtureScore(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "song.gpx")
+	if err := os.WriteFile(input, selftestGpx, 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out", "song.gp")
+	if _, err := mirrorConvert(input, output); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	after, err := readZipEntry(&zr.Reader, "Content/score.gpif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Error("score.gpif changed in a convert without options")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mixer presets set how tracks start out playing in the converted file:
+// muted, soloed, or at a given volume, for practice versions that are
+// ready to play. Guitar Pro keeps mute and solo in a track's
+// PlaybackState, the volume among the parameters of its channel strip.
+
+// listFlag collects the values of a flag given several times
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ", ") }
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// channelStripVolume is the index of the volume, from 0 to 1, among the
+// channel strip parameters
+const channelStripVolume = 12
+
+// mixerPreset is the mixer state to write, by track id
+type mixerPreset struct {
+	state  map[int]string  // PlaybackState: Mute or Solo
+	volume map[int]float64 // 0 to 1
+}
+
+// mixerFlags are the -mute, -solo and -volume values
+var mixerFlags struct {
+	mute, solo, volume listFlag
+}
+
+func (m *mixerPreset) empty() bool {
+	return len(m.state) == 0 && len(m.volume) == 0
+}
+
+// findTrack resolves a track given by number, from 1, or by name
+func (g *Gpif) findTrack(ref string) (*GpifTrack, error) {
+	ref = strings.TrimSpace(ref)
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(g.Tracks) {
+			return nil, fmt.Errorf("track %d does not exist, the score has %s", n, plural(len(g.Tracks), "track"))
+		}
+		return &g.Tracks[n-1], nil
+	}
+	var names []string
+	for i := range g.Tracks {
+		name := strings.TrimSpace(g.Tracks[i].Name)
+		if strings.EqualFold(name, ref) {
+			return &g.Tracks[i], nil
+		}
+		names = append(names, name)
+	}
+	return nil, fmt.Errorf("no track is called %q, the tracks are %s", ref, strings.Join(names, ", "))
+}
+
+// resolveMixer turns the mixer flags into a preset for a score
+func (g *Gpif) resolveMixer() (*mixerPreset, error) {
+	m := &mixerPreset{state: make(map[int]string), volume: make(map[int]float64)}
+	for _, ref := range mixerFlags.mute {
+		t, err := g.findTrack(ref)
+		if err != nil {
+			return nil, fmt.Errorf("-mute: %v", err)
+		}
+		m.state[t.ID] = "Mute"
+	}
+	for _, ref := range mixerFlags.solo {
+		t, err := g.findTrack(ref)
+		if err != nil {
+			return nil, fmt.Errorf("-solo: %v", err)
+		}
+		if m.state[t.ID] == "Mute" {
+			return nil, fmt.Errorf("-solo: %s is also muted", strings.TrimSpace(t.Name))
+		}
+		m.state[t.ID] = "Solo"
+	}
+	for _, v := range mixerFlags.volume {
+		// Track names may contain "=", the level follows the last one
+		i := strings.LastIndex(v, "=")
+		level, err := strconv.Atoi(strings.TrimSpace(v[i+1:]))
+		if i < 0 || err != nil || level < 0 || level > 100 {
+			return nil, fmt.Errorf("-volume %q: use track=percent, from 0 to 100", v)
+		}
+		ref := v[:i]
+		t, err := g.findTrack(ref)
+		if err != nil {
+			return nil, fmt.Errorf("-volume: %v", err)
+		}
+		m.volume[t.ID] = float64(level) / 100
+	}
+	return m, nil
+}
+
+// applyMixer writes the preset into a score. Tracks without a channel
+// strip keep their volume, they are returned.
+func applyMixer(gpif []byte, m *mixerPreset) ([]byte, []int, error) {
+	track := -1
+	stated := false
+	volumeSet := make(map[int]bool)
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "Track" && inside(stack, "Tracks") {
+				track, _ = strconv.Atoi(attrValue(t, "id"))
+				stated = false
+			}
+			if t.Name.Local == "PlaybackState" && inside(stack, "Tracks", "Track") {
+				stated = true
+			}
+		case xml.CharData:
+			if inside(stack, "Tracks", "Track", "PlaybackState") && m.state[track] != "" {
+				return []xml.Token{xml.CharData(m.state[track])}
+			}
+			if volume, ok := m.volume[track]; ok && inside(stack, "Track", "RSE", "ChannelStrip", "Parameters") {
+				params := strings.Fields(string(t))
+				if len(params) > channelStripVolume {
+					params[channelStripVolume] = strconv.FormatFloat(volume, 'f', 6, 64)
+					volumeSet[track] = true
+					return []xml.Token{xml.CharData(strings.Join(params, " "))}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "Track" && len(stack) >= 2 && stack[len(stack)-2] == "Tracks" && !stated && m.state[track] != "" {
+				start := xml.StartElement{Name: xml.Name{Local: "PlaybackState"}}
+				return []xml.Token{start, xml.CharData(m.state[track]), start.End(), tok}
+			}
+		}
+		return []xml.Token{tok}
+	})
+	var missed []int
+	for id := range m.volume {
+		if !volumeSet[id] {
+			missed = append(missed, id)
+		}
+	}
+	sort.Ints(missed)
+	return out, missed, err
+}
+
+// setMixer applies the mixer flags to score.gpif, describing the result
+func (fs *GpxFileSystem) setMixer() ([]string, error) {
+	score, err := fs.loadScore()
+	if err != nil {
+		return nil, err
+	}
+	m, err := score.resolveMixer()
+	if err != nil || m.empty() {
+		return nil, err
+	}
+	file := fs.File("score.gpif")
+	data, missed, err := applyMixer(file.Data, m)
+	if err != nil {
+		return nil, fmt.Errorf("setting the mixer in score.gpif: %v", err)
+	}
+	file.Data, file.FileSize = data, len(data)
+
+	var done []string
+	for i := range score.Tracks {
+		t := &score.Tracks[i]
+		name := strings.TrimSpace(t.Name)
+		switch m.state[t.ID] {
+		case "Mute":
+			done = append(done, name+" muted")
+		case "Solo":
+			done = append(done, name+" solo")
+		}
+		if v, ok := m.volume[t.ID]; ok && !containsInt(missed, t.ID) {
+			done = append(done, fmt.Sprintf("%s at %d%% volume", name, int(v*100+0.5)))
+		}
+	}
+	for _, id := range missed {
+		for i := range score.Tracks {
+			if score.Tracks[i].ID == id {
+				if err := fs.warn("track %s has no channel strip, its volume is left as it is", strings.TrimSpace(score.Tracks[i].Name)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return done, nil
+}
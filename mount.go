@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// gpx2gp mount exposes the inner files of a GPX container read-only as a
+// directory, so they can be inspected and copied with the usual tools.
+
+// mountedFile is one inner file as the mount shows it
+type mountedFile struct {
+	name string
+	data []byte
+}
+
+func runMount(args []string) {
+	cmd := flag.NewFlagSet("mount", flag.ExitOnError)
+	lenient := cmd.Bool("lenient", false, "Recover what is possible from damaged files, reporting warnings")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 2 {
+		fmt.Println("Usage: gpx2gp mount [-lenient] <input.gpx> <mount point>")
+		os.Exit(1)
+	}
+	if *lenient {
+		parseMode = ParseLenient
+	}
+	inputPath, mountPoint := cmd.Arg(0), cmd.Arg(1)
+	if info, err := os.Stat(mountPoint); err != nil || !info.IsDir() {
+		fmt.Printf("Error: mount point '%s' is not a directory.\n", mountPoint)
+		os.Exit(1)
+	}
+
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, w := range fs.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	var files []mountedFile
+	for i := range fs.Files {
+		f := &fs.Files[i]
+		if strings.Contains(f.FileName, "/") || safeEntryName(f.FileName) != nil {
+			fmt.Printf("Warning: %q cannot be shown as a file name, leaving it out.\n", f.FileName)
+			continue
+		}
+		data, err := f.Content()
+		if err != nil {
+			fmt.Printf("Error: %s: %v\n", f.FileName, err)
+			os.Exit(1)
+		}
+		files = append(files, mountedFile{name: f.FileName, data: data})
+	}
+	modified := time.Now()
+	if info, err := os.Stat(inputPath); err == nil {
+		modified = info.ModTime()
+	}
+
+	m, err := mountFuse(mountPoint)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Mounted %d files of %s at %s read-only. Press Ctrl+C or unmount the directory to stop.\n", len(files), inputPath, mountPoint)
+	err = m.serve(files, modified)
+	fs.Close()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Unmounted %s.\n", mountPoint)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gpx2gp normalize keeps a repository of tabs consistent, typically as a
+// pre-commit hook: every .gpx gets a reproducible .gp and a canonical text
+// export next to it, and the command fails when it had to change any of
+// them, so the commit is retried with the regenerated files.
+
+// canonicalExt names the canonical text export of song.gpx song.canonical.txt
+const canonicalExt = ".canonical.txt"
+
+// normalizeInputs lists the .gpx files among paths, walking directories
+// and skipping hidden ones such as .git
+func normalizeInputs(paths []string) ([]string, error) {
+	var inputs []string
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.EqualFold(filepath.Ext(path), ".gpx") {
+				inputs = append(inputs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inputs, nil
+}
+
+// generatedFile is an output built in memory before it is written
+type generatedFile struct {
+	path string
+	data []byte
+}
+
+// normalizedFiles generates the .gp archive and canonical text of a .gpx
+func normalizedFiles(inputPath string) ([]generatedFile, error) {
+	gpx, err := readGpx(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer gpx.Close()
+	if _, _, err := gpx.migrateScore(); err != nil {
+		return nil, err
+	}
+	score, err := gpx.loadScore()
+	if err != nil {
+		return nil, err
+	}
+
+	var archive, text bytes.Buffer
+	if err := writeGpArchive(&archive, gpx); err != nil {
+		return nil, fmt.Errorf("creating archive: %v", err)
+	}
+	if err := conformData(archive.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := score.writeCanonical(&text); err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(inputPath, filepath.Ext(inputPath))
+	return []generatedFile{
+		{base + ".gp", archive.Bytes()},
+		{base + canonicalExt, text.Bytes()},
+	}, nil
+}
+
+// writeIfChanged replaces path with data unless it already holds exactly
+// that, reporting whether it differed
+func writeIfChanged(path string, data []byte, dryRun bool) (bool, error) {
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+	if dryRun {
+		return true, nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return true, err
+	}
+	return true, os.Rename(tmp, path)
+}
+
+func runNormalize(args []string) {
+	cmd := flag.NewFlagSet("normalize", flag.ExitOnError)
+	check := cmd.Bool("check", false, "Only report what would change, write nothing")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	paths := cmd.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	inputs, err := normalizeInputs(paths)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Outputs must only depend on the inputs for the comparison to mean
+	// anything
+	archiveOptions.Reproducible = true
+
+	changed, failed := 0, 0
+	for _, inputPath := range inputs {
+		files, err := normalizedFiles(inputPath)
+		if err != nil {
+			fmt.Printf("Error: %s: %v\n", inputPath, err)
+			failed++
+			continue
+		}
+		for _, f := range files {
+			differs, err := writeIfChanged(f.path, f.data, *check)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				failed++
+				continue
+			}
+			if differs {
+				changed++
+				if *check {
+					fmt.Printf("Out of date: %s\n", f.path)
+				} else {
+					fmt.Printf("Updated %s\n", f.path)
+				}
+			} else {
+				debug("Up to date: %s", f.path)
+			}
+		}
+	}
+
+	switch {
+	case failed > 0:
+		fmt.Printf("Finished with %d errors.\n", failed)
+	case changed > 0 && *check:
+		fmt.Printf("%d files are out of date, run gpx2gp normalize.\n", changed)
+	case changed > 0:
+		fmt.Printf("Updated %d files, review and add them.\n", changed)
+	default:
+		fmt.Printf("%d files up to date.\n", len(inputs))
+	}
+	if failed > 0 || changed > 0 {
+		os.Exit(1)
+	}
+}
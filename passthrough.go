@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Inputs that are already Guitar Pro 7 files, common when a batch runs
+// over a folder of mixed downloads
+
+// gpInputModes are the values of -gp-input
+var gpInputModes = map[string]string{
+	"skip":      "leave the file alone and report it as skipped",
+	"copy":      "copy the file to the output unchanged",
+	"normalize": "rewrite the archive with the output options",
+	"error":     "fail as for any unsupported input",
+}
+
+// openGpInput returns the archive when data is a zip that passes the
+// Guitar Pro 7 checks, nil when it is not a zip at all
+func openGpInput(data []byte) (*zip.Reader, error) {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		return nil, nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("input is a damaged zip archive: %v", err)
+	}
+	if problems := checkArchive(zr, target); len(problems) > 0 {
+		return nil, fmt.Errorf("input is a zip archive but not a valid Guitar Pro 7 file: %s", strings.Join(problems, "; "))
+	}
+	return zr, nil
+}
+
+// gpScoreOnly wraps the score of a .gp archive for naming the output
+func gpScoreOnly(zr *zip.Reader) *GpxFileSystem {
+	fs := &GpxFileSystem{Limits: limits}
+	if data, err := readZipEntry(zr, "Content/score.gpif"); err == nil && data != nil {
+		fs.Files = []GpxFile{{FileName: "score.gpif", FileSize: len(data), Data: data}}
+	}
+	return fs
+}
+
+// normalizeGpArchive rewrites every entry of a .gp archive with the
+// configured compression, timestamps and order, refreshing the manifest
+func normalizeGpArchive(w io.Writer, zr *zip.Reader) error {
+	zw := newArchiveWriter(w)
+	defer zw.Close()
+
+	files := append([]*zip.File(nil), zr.File...)
+	if archiveOptions.Reproducible {
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	}
+	var manifest strings.Builder
+	for _, f := range files {
+		if f.Name == manifestName {
+			continue
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			if _, err := zw.CreateHeader(archiveHeader(f.Name, zip.Store)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := safeEntryName(f.Name); err != nil {
+			return err
+		}
+		data, err := readZipEntry(zr, f.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		h := archiveHeader(f.Name, archiveOptions.Method)
+		if h.Modified.IsZero() {
+			h.Modified = f.Modified
+		}
+		out, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		if strings.HasPrefix(f.Name, "Content/") {
+			manifest.WriteString(manifestLine(f.Name, data))
+		}
+	}
+	if archiveOptions.Manifest {
+		out, err := zw.CreateHeader(archiveHeader(manifestName, archiveOptions.Method))
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write([]byte(manifest.String())); err != nil {
+			return err
+		}
+	}
+	return zw.SetComment(zr.Comment)
+}
+
+// passGpInput writes a valid .gp input to outputPath as mode asks
+func passGpInput(data []byte, zr *zip.Reader, outputPath, mode string) error {
+	if isRemotePath(outputPath) {
+		return putRemote(outputPath, func(w io.Writer) error {
+			if mode == "copy" {
+				_, err := w.Write(data)
+				return err
+			}
+			return normalizeGpArchive(w, zr)
+		})
+	}
+	if mode == "copy" {
+		return os.WriteFile(outputPath, data, 0644)
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if err := normalizeGpArchive(f, zr); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readGpInput loads the input when it is a .gp archive, returning a nil
+// archive for anything else without reading it
+func readGpInput(path string) ([]byte, *zip.Reader, error) {
+	if isRemotePath(path) {
+		d, err := readRemote(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		if !bytes.HasPrefix(d.data, []byte("PK")) {
+			return nil, nil, nil
+		}
+		zr, err := openGpInput(d.data)
+		return d.data, zr, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	defer f.Close()
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != "PK" {
+		return nil, nil, nil
+	}
+	if info, err := f.Stat(); err == nil {
+		if err := limits.checkInput(int(info.Size())); err != nil {
+			return nil, nil, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	zr, err := openGpInput(data)
+	return data, zr, err
+}
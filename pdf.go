@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Minimal PDF writer for monospaced text pages using the built-in Courier font
+const (
+	pdfPageWidth  = 595 // A4 in points
+	pdfPageHeight = 842
+	pdfMargin     = 50
+	pdfFontSize   = 9
+	pdfLeading    = 11
+)
+
+// pdfLinesPerPage is how many text lines fit between the margins
+const pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+
+type PdfDocument struct {
+	Title string
+	pages [][]string
+}
+
+func (d *PdfDocument) AddPage(lines []string) {
+	d.pages = append(d.pages, lines)
+}
+
+func (d *PdfDocument) PageCount() int {
+	return len(d.pages)
+}
+
+// pdfText encodes a string as a PDF literal string in WinAnsiEncoding
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7F:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+func (d *PdfDocument) Write(w io.Writer) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are fixed, each page then adds a page and a content object
+	const firstPage = 5
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title %s /Producer (gpx2gp) >>", pdfText(d.Title)))
+
+	for i, lines := range d.pages {
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "%s '\n", pdfText(line))
+		}
+		content.WriteString("ET")
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
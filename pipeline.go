@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// Work a conversion can do alongside its main stages. Reading, decoding
+// and parsing a container stay one sequential stage, the sector parser
+// needs the whole decompressed container; around it the input is hashed
+// in a goroutine, large archive entries are deflated by one worker per
+// CPU, and the archive is written to disk by a goroutine while the next
+// entries are compressed.
+
+// asyncChunk is the amount of archive data handed to the writer at once
+const asyncChunk = 256 << 10
+
+// asyncWriter passes writes in chunks to a goroutine writing them to w.
+// Errors from w are returned by Close.
+type asyncWriter struct {
+	buf  []byte
+	ch   chan []byte
+	free chan []byte
+	done chan error
+}
+
+func newAsyncWriter(w io.Writer) *asyncWriter {
+	aw := &asyncWriter{
+		buf:  make([]byte, 0, asyncChunk),
+		ch:   make(chan []byte, 2),
+		free: make(chan []byte, 2),
+		done: make(chan error, 1),
+	}
+	go func() {
+		var err error
+		for chunk := range aw.ch {
+			if err == nil {
+				_, err = w.Write(chunk)
+			}
+			select {
+			case aw.free <- chunk[:0]:
+			default:
+			}
+		}
+		aw.done <- err
+	}()
+	return aw
+}
+
+func (aw *asyncWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := min(len(p), asyncChunk-len(aw.buf))
+		aw.buf = append(aw.buf, p[:k]...)
+		p = p[k:]
+		if len(aw.buf) == asyncChunk {
+			aw.ch <- aw.buf
+			select {
+			case aw.buf = <-aw.free:
+			default:
+				aw.buf = make([]byte, 0, asyncChunk)
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close hands over the last chunk and waits for the writes to finish
+func (aw *asyncWriter) Close() error {
+	if len(aw.buf) > 0 {
+		aw.ch <- aw.buf
+	}
+	close(aw.ch)
+	return <-aw.done
+}
+
+// precompressMin is the size from which Content entries are deflated ahead
+// of the archive writer, smaller ones are not worth a goroutine
+const precompressMin = 64 << 10
+
+// precompressed is an entry deflated by a worker, done is closed when the
+// other fields are set
+type precompressed struct {
+	done chan struct{}
+	data []byte // raw deflate stream
+	size int
+	crc  uint32
+	sum  []byte // SHA-256 of the uncompressed data, for the manifest
+	err  error
+}
+
+// precompress deflates data at level once a slot of sem is free
+func precompress(data []byte, level int, sem chan struct{}) *precompressed {
+	p := &precompressed{done: make(chan struct{}), size: len(data)}
+	go func() {
+		defer close(p.done)
+		sem <- struct{}{}
+		defer func() { <-sem }()
+
+		var buf bytes.Buffer
+		buf.Grow(len(data) / 4)
+		fw, err := flate.NewWriter(&buf, level)
+		if err == nil {
+			_, err = fw.Write(data)
+		}
+		if err == nil {
+			err = fw.Close()
+		}
+		if err != nil {
+			p.err = err
+			return
+		}
+		sum := sha256.Sum256(data)
+		p.data, p.crc, p.sum = buf.Bytes(), crc32.ChecksumIEEE(data), sum[:]
+	}()
+	return p
+}
+
+// writeTo waits for the stream and copies it into zw as entry name
+func (p *precompressed) writeTo(zw *zip.Writer, name string) error {
+	<-p.done
+	if p.err != nil {
+		return p.err
+	}
+	h := archiveHeader(name, zip.Deflate)
+	h.CRC32 = p.crc
+	h.CompressedSize64 = uint64(len(p.data))
+	h.UncompressedSize64 = uint64(p.size)
+	rawHeader(h)
+	w, err := zw.CreateRaw(h)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(p.data)
+	return err
+}
+
+// rawHeader fills in what CreateHeader derives from a header and CreateRaw
+// leaves as it is, so raw entries look like the others
+func rawHeader(h *zip.FileHeader) {
+	h.CreatorVersion = h.CreatorVersion&0xff00 | 20
+	h.ReaderVersion = 20
+	if h.Modified.IsZero() {
+		return
+	}
+	h.ModifiedDate, h.ModifiedTime = msDosTime(h.Modified)
+	// Extended timestamp, as CreateHeader writes it
+	extra := make([]byte, 9)
+	binary.LittleEndian.PutUint16(extra[0:], 0x5455)
+	binary.LittleEndian.PutUint16(extra[2:], 5)
+	extra[4] = 1
+	binary.LittleEndian.PutUint32(extra[5:], uint32(h.Modified.Unix()))
+	h.Extra = append(h.Extra, extra...)
+}
+
+// msDosTime encodes t in the date and time fields of zip headers
+func msDosTime(t time.Time) (date, clock uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A practice plan: every section of the score as a loop, with tempos
+// rising step by step to the written one, for speed trainer apps.
+
+// PracticePlan is the sidecar JSON export practice writes
+type PracticePlan struct {
+	Title  string         `json:"title"`
+	Artist string         `json:"artist"`
+	Loops  []PracticeLoop `json:"loops"`
+}
+
+// PracticeLoop is a bar range to repeat, bars numbered from 1 as Guitar
+// Pro shows them
+type PracticeLoop struct {
+	Name     string         `json:"name"`
+	StartBar int            `json:"start_bar"`
+	EndBar   int            `json:"end_bar"`
+	BPM      float64        `json:"bpm"`     // written tempo at the start of the loop
+	Seconds  float64        `json:"seconds"` // one pass at the written tempo
+	Steps    []PracticeStep `json:"steps"`
+}
+
+// PracticeStep is one tempo to practice the loop at
+type PracticeStep struct {
+	Percent int     `json:"percent"`
+	BPM     float64 `json:"bpm"`
+	Seconds float64 `json:"seconds"`
+}
+
+// masterBarTempos gives the tempo in effect at the start of every master
+// bar, in score order; changes within a bar count from the next bar
+func (g *Gpif) masterBarTempos() []float64 {
+	var tempos []GpifAutomation
+	for _, a := range g.MasterTrack.Automations {
+		if a.Type == "Tempo" {
+			tempos = append(tempos, a)
+		}
+	}
+	sort.SliceStable(tempos, func(i, j int) bool {
+		if tempos[i].Bar != tempos[j].Bar {
+			return tempos[i].Bar < tempos[j].Bar
+		}
+		return tempos[i].Position < tempos[j].Position
+	})
+
+	bpm := 120.0
+	result := make([]float64, len(g.MasterBars))
+	for i := range g.MasterBars {
+		start := bpm
+		for len(tempos) > 0 && tempos[0].Bar <= i {
+			if value, ok := parseTempo(tempos[0].Value); ok {
+				bpm = value
+				if tempos[0].Bar < i || tempos[0].Position == 0 {
+					start = value
+				}
+			}
+			tempos = tempos[1:]
+		}
+		result[i] = start
+	}
+	return result
+}
+
+// sectionRange is a section's master bars, from First to Last
+type sectionRange struct {
+	Name        string
+	First, Last int
+}
+
+// sectionRanges splits the score at its sections; bars before the first
+// section, or a score without any, make a section called Start
+func (g *Gpif) sectionRanges() []sectionRange {
+	var sections []sectionRange
+	for i, mb := range g.MasterBars {
+		if i == 0 || (mb.Section != nil && mb.Section.Name() != "") {
+			name := "Start"
+			if mb.Section != nil && mb.Section.Name() != "" {
+				name = mb.Section.Name()
+			}
+			sections = append(sections, sectionRange{Name: name, First: i})
+		}
+		sections[len(sections)-1].Last = i
+	}
+	return sections
+}
+
+// PracticeLoops splits the score at its sections, or makes one loop of it
+// when it has none, with steps from startPercent to 100 in stepPercent
+func (g *Gpif) PracticeLoops(startPercent, stepPercent int) []PracticeLoop {
+	tempos := g.masterBarTempos()
+	loops := []PracticeLoop{}
+	for _, s := range g.sectionRanges() {
+		loop := PracticeLoop{Name: s.Name, StartBar: s.First + 1, EndBar: s.Last + 1, BPM: tempos[s.First]}
+		for i := s.First; i <= s.Last; i++ {
+			loop.Seconds += float64(g.MasterBars[i].DurationTicks()) / ticksPerQuarter * 60 / tempos[i]
+		}
+		loops = append(loops, loop)
+	}
+
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	for i := range loops {
+		loop := &loops[i]
+		for p := startPercent; ; p += stepPercent {
+			p = min(p, 100)
+			loop.Steps = append(loop.Steps, PracticeStep{
+				Percent: p,
+				BPM:     math.Round(loop.BPM * float64(p) / 100),
+				Seconds: round(loop.Seconds * 100 / float64(p)),
+			})
+			if p == 100 {
+				break
+			}
+		}
+		loop.Seconds = round(loop.Seconds)
+	}
+	return loops
+}
+
+func runExportPractice(args []string) {
+	cmd := flag.NewFlagSet("export practice", flag.ExitOnError)
+	var inputPath, outputPath string
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	start := cmd.Int("start", 60, "First practice tempo, in percent of the written tempo")
+	step := cmd.Int("step", 10, "Tempo increase between steps, in percent")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp export practice -f <input.gpx> [-o <plan.json>] [-start 60] [-step 10]")
+		os.Exit(1)
+	}
+	if *start < 1 || *start > 100 || *step < 1 {
+		fmt.Println("Error: -start must be between 1 and 100, -step at least 1.")
+		os.Exit(1)
+	}
+	if err := checkExportOutput(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	plan := PracticePlan{
+		Title:  strings.TrimSpace(score.Score.Title),
+		Artist: strings.TrimSpace(score.Score.Artist),
+		Loops:  score.PracticeLoops(*start, *step),
+	}
+
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		fmt.Printf("Error writing practice plan: %v\n", err)
+		os.Exit(1)
+	}
+}
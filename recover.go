@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Salvage of damaged containers
+
+var (
+	gpifStart = []byte("<GPIF")
+	gpifEnd   = []byte("</GPIF>")
+)
+
+// salvageScore rebuilds score.gpif when its sector table is damaged. GP
+// writes file data to consecutive sectors, so starting at the sector that
+// opens the XML document, sectors are joined until the closing tag.
+func (fs *GpxFileSystem) salvageScore(data []byte, owners map[int]string, headers map[int]bool) {
+	existing := fs.File("score.gpif")
+	if existing != nil && looksLikeScore(existing.Data) {
+		return
+	}
+
+	sectorCount := (len(data) + sectorSize - 1) / sectorSize
+	sector := func(i int) []byte {
+		return data[i*sectorSize : min((i+1)*sectorSize, len(data))]
+	}
+	available := func(i int) bool {
+		return !headers[i] && (owners[i] == "" || owners[i] == "score.gpif")
+	}
+
+	for start := 1; start < sectorCount; start++ {
+		if !available(start) {
+			continue
+		}
+		if !startsXML(sector(start)) {
+			continue
+		}
+
+		var score []byte
+		used := 0
+		for i := start; i < sectorCount && available(i); i++ {
+			score = append(score, sector(i)...)
+			used++
+			if end := bytes.Index(score, gpifEnd); end >= 0 {
+				score = score[:end+len(gpifEnd)]
+				break
+			}
+		}
+		if !bytes.Contains(score, gpifStart) {
+			continue
+		}
+
+		complete := bytes.HasSuffix(score, gpifEnd)
+		if !complete {
+			score = bytes.TrimRight(score, "\x00")
+		}
+		if existing != nil && !complete && len(score) <= len(existing.Data) {
+			return
+		}
+
+		fs.Warnings = append(fs.Warnings, salvageMessage(start, used, len(score), complete))
+		fs.Partial = true
+		if existing != nil {
+			existing.Data = score
+			existing.FileSize = len(score)
+		} else {
+			fs.Files = append(fs.Files, GpxFile{FileName: "score.gpif", FileSize: len(score), Data: score})
+		}
+		return
+	}
+}
+
+func startsXML(data []byte) bool {
+	head := bytes.TrimLeft(data, "\ufeff \t\r\n")
+	return bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, gpifStart)
+}
+
+// looksLikeScore reports whether data holds a whole GPIF document
+func looksLikeScore(data []byte) bool {
+	return startsXML(data) && bytes.Contains(data, gpifEnd)
+}
+
+func salvageMessage(start, sectors, size int, complete bool) string {
+	state := "complete document"
+	if !complete {
+		state = "document is cut off"
+	}
+	return fmt.Sprintf("score.gpif rebuilt from %d sectors starting at sector %d (%d bytes, %s)", sectors, start, size, state)
+}
+
+// resolveDuplicates keeps one entry per file name. A score that parses wins
+// over one that does not, then the larger copy, then the later one.
+func (fs *GpxFileSystem) resolveDuplicates() error {
+	count := make(map[string]int)
+	for _, f := range fs.Files {
+		count[f.FileName]++
+	}
+
+	better := func(a, b *GpxFile) bool {
+		if a.FileName == "score.gpif" {
+			_, errA := parseGpif(a.Data)
+			_, errB := parseGpif(b.Data)
+			if (errA == nil) != (errB == nil) {
+				return errA == nil
+			}
+		}
+		return a.Len() >= b.Len()
+	}
+
+	keep := make(map[string]int) // name -> index of the kept copy
+	for i := range fs.Files {
+		name := fs.Files[i].FileName
+		if count[name] == 1 {
+			keep[name] = i
+			continue
+		}
+		if j, ok := keep[name]; !ok || better(&fs.Files[i], &fs.Files[j]) {
+			keep[name] = i
+		}
+	}
+
+	var files []GpxFile
+	for i, f := range fs.Files {
+		if keep[f.FileName] == i {
+			files = append(files, f)
+			continue
+		}
+		kept := fs.Files[keep[f.FileName]]
+		if err := fs.warn("duplicate entry %s: kept the %d byte copy, dropped a %d byte copy", f.FileName, kept.Len(), f.Len()); err != nil {
+			return err
+		}
+	}
+	fs.Files = files
+	return nil
+}
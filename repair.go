@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repair of damaged scores. Container level recovery is lenient parsing;
+// on top of that the GPIF document is made well formed again and references
+// to missing bars, voices, beats, notes and rhythms are removed.
+
+// xmlEdit rewrites one token of the document, given the names of the open
+// elements. Returning nil drops the token, and for a start element its
+// whole subtree.
+type xmlEdit func(stack []string, tok xml.Token) []xml.Token
+
+// rewriteXML copies a document token by token through edit. A document
+// that ends early is closed at the point of damage, which is reported.
+func rewriteXML(data []byte, edit xmlEdit) ([]byte, bool, error) {
+	var out bytes.Buffer
+	decoder := newGpifDecoder(data)
+	enc := xml.NewEncoder(&out)
+	var stack []string
+	skip := 0
+	truncated := false
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(stack) == 0 {
+				return nil, false, err
+			}
+			truncated = true
+			break
+		}
+		if skip > 0 {
+			switch tok.(type) {
+			case xml.StartElement:
+				skip++
+			case xml.EndElement:
+				skip--
+			}
+			continue
+		}
+		if pi, ok := tok.(xml.ProcInst); ok && pi.Target == "xml" {
+			// The text was transcoded, whatever the declaration said
+			tok = xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="utf-8"`)}
+		}
+
+		toks := edit(stack, xml.CopyToken(tok))
+		if toks == nil {
+			if _, ok := tok.(xml.StartElement); ok {
+				skip = 1
+			}
+			continue
+		}
+		for _, t := range toks {
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, false, err
+			}
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if err := enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: stack[i]}}); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, false, err
+	}
+	return out.Bytes(), truncated, nil
+}
+
+// inside reports whether the innermost open elements are path
+func inside(stack []string, path ...string) bool {
+	if len(stack) < len(path) {
+		return false
+	}
+	for i, name := range path {
+		if stack[len(stack)-len(path)+i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// keepIDs filters a whitespace separated id list, replacing unknown ids
+// with -1 or dropping them
+func keepIDs(list string, known func(int) bool, placeholder bool) (string, int) {
+	var kept []string
+	removed := 0
+	for _, id := range parseIDs(list) {
+		switch {
+		case id < 0 || known(id):
+			kept = append(kept, strconv.Itoa(id))
+		case placeholder:
+			kept = append(kept, "-1")
+			removed++
+		default:
+			removed++
+		}
+	}
+	return strings.Join(kept, " "), removed
+}
+
+// repairScore returns a well formed score whose references all resolve,
+// with one message per change made
+func repairScore(data []byte) ([]byte, []string, error) {
+	var actions []string
+	closed, truncated, err := rewriteXML(data, func(stack []string, tok xml.Token) []xml.Token { return []xml.Token{tok} })
+	if err != nil {
+		return nil, nil, fmt.Errorf("score.gpif cannot be read as XML: %v", err)
+	}
+	if truncated {
+		actions = append(actions, "closed the elements left open where score.gpif ends early")
+	}
+	g, err := parseGpif(closed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dropBars := make(map[int]bool)
+	for i, mb := range g.MasterBars {
+		bars := parseIDs(mb.Bars)
+		if len(bars) != len(g.Tracks) {
+			dropBars[i] = true
+			continue
+		}
+		for _, id := range bars {
+			if g.barByID[id] == nil {
+				dropBars[i] = true
+			}
+		}
+	}
+	if len(dropBars) > 0 {
+		actions = append(actions, fmt.Sprintf("removed %d master bars with missing track bars", len(dropBars)))
+	}
+	addRhythm := len(g.Rhythms) == 0 && len(g.Beats) > 0
+	fallbackRhythm := "0"
+	if len(g.Rhythms) > 0 {
+		fallbackRhythm = strconv.Itoa(g.Rhythms[0].ID)
+	}
+
+	masterBar, voices, beats, notes, rhythms, times := -1, 0, 0, 0, 0, 0
+	edited, _, err := rewriteXML(closed, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "MasterBar" && inside(stack, "MasterBars") {
+				masterBar++
+				if dropBars[masterBar] {
+					return nil
+				}
+			}
+			if t.Name.Local == "Rhythm" && inside(stack, "Beat") {
+				for i, a := range t.Attr {
+					id, err := strconv.Atoi(a.Value)
+					if a.Name.Local == "ref" && (err != nil || g.rhythmByID[id] == nil) {
+						t.Attr[i].Value = fallbackRhythm
+						rhythms++
+					}
+				}
+				return []xml.Token{t}
+			}
+		case xml.CharData:
+			var fixed string
+			var n int
+			switch {
+			case inside(stack, "Bar", "Voices"):
+				fixed, n = keepIDs(string(t), func(id int) bool { return g.voiceByID[id] != nil }, true)
+				voices += n
+			case inside(stack, "Voice", "Beats"):
+				fixed, n = keepIDs(string(t), func(id int) bool { return g.beatByID[id] != nil }, false)
+				beats += n
+			case inside(stack, "Beat", "Notes"):
+				fixed, n = keepIDs(string(t), func(id int) bool { return g.noteByID[id] != nil }, false)
+				notes += n
+			case inside(stack, "MasterBar", "Time") && !validTimeSignature(string(t)):
+				fixed, n = "4/4", 1
+				times++
+			}
+			if n > 0 {
+				return []xml.Token{xml.CharData(fixed)}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "GPIF" && len(stack) == 1 && addRhythm {
+				return []xml.Token{
+					xml.StartElement{Name: xml.Name{Local: "Rhythms"}},
+					xml.StartElement{Name: xml.Name{Local: "Rhythm"}, Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "0"}}},
+					xml.StartElement{Name: xml.Name{Local: "NoteValue"}},
+					xml.CharData("Quarter"),
+					xml.EndElement{Name: xml.Name{Local: "NoteValue"}},
+					xml.EndElement{Name: xml.Name{Local: "Rhythm"}},
+					xml.EndElement{Name: xml.Name{Local: "Rhythms"}},
+					t,
+				}
+			}
+		}
+		return []xml.Token{tok}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, c := range []struct {
+		n    int
+		what string
+	}{
+		{voices, "references to missing voices cleared"},
+		{beats, "references to missing beats removed"},
+		{notes, "references to missing notes removed"},
+		{rhythms, "beats with a missing rhythm set to rhythm " + fallbackRhythm},
+		{times, "invalid time signatures set to 4/4"},
+	} {
+		if c.n > 0 {
+			actions = append(actions, fmt.Sprintf("%d %s", c.n, c.what))
+		}
+	}
+	if addRhythm {
+		actions = append(actions, "added a quarter note rhythm for the beats, the rhythm table was lost")
+	}
+	if repaired, err := parseGpif(edited); err == nil {
+		if p := repaired.checkDirections(); len(p.fixes) > 0 {
+			if edited, err = applyDirections(edited, p); err != nil {
+				return nil, nil, err
+			}
+			actions = append(actions, p.fixes...)
+		}
+	}
+	if repaired, err := parseGpif(edited); err == nil {
+		if p := repaired.checkContinuity(); len(p.fixes) > 0 {
+			if edited, err = applyContinuity(edited, p); err != nil {
+				return nil, nil, err
+			}
+			actions = append(actions, p.fixes...)
+		}
+	}
+	if len(actions) == 0 {
+		return data, nil, nil
+	}
+	return edited, actions, nil
+}
+
+func runRepair(args []string) {
+	cmd := flag.NewFlagSet("repair", flag.ExitOnError)
+	var inputPath, outputPath string
+	cmd.StringVar(&inputPath, "f", "", "Damaged GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Damaged GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output .gp file")
+	cmd.StringVar(&outputPath, "out", "", "Output .gp file")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" && cmd.NArg() > 0 {
+		// Flags may follow the input path
+		inputPath = cmd.Arg(0)
+		cmd.Parse(cmd.Args()[1:])
+	}
+	if inputPath == "" || outputPath == "" {
+		fmt.Println("Usage: gpx2gp repair <broken.gpx> -o <fixed.gp>")
+		os.Exit(1)
+	}
+	outputPath = withExtension(outputPath, ".gp")
+	if err := checkOutputPath(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	// Every container level salvage strategy runs in lenient mode
+	start := time.Now()
+	parseMode = ParseLenient
+	fs, err := readGpx(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	actions := fs.Warnings
+
+	file := fs.File("score.gpif")
+	if file == nil {
+		fmt.Printf("Error: %v\n", fs.missingScore())
+		os.Exit(1)
+	}
+	repaired, changes, err := repairScore(file.Data)
+	if err != nil {
+		fmt.Printf("Error: cannot repair score.gpif: %v\n", err)
+		os.Exit(1)
+	}
+	if len(changes) > 0 {
+		file.Data, file.FileSize = repaired, len(repaired)
+		actions = append(actions, changes...)
+	}
+	if _, _, err := fs.migrateScore(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := createGpArchive(outputPath, fs); err != nil {
+		os.Remove(outputPath)
+		fmt.Printf("Error creating archive: %v\n", err)
+		os.Exit(1)
+	}
+	if err := conformTarget(outputPath); err != nil {
+		os.Remove(outputPath)
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(actions) == 0 {
+		fmt.Printf("%s: nothing to repair, converted to %s in %v.\n", inputPath, outputPath, time.Since(start))
+		return
+	}
+	fmt.Printf("Repaired %s into %s in %v:\n", inputPath, outputPath, time.Since(start))
+	for _, a := range actions {
+		fmt.Printf("  - %s\n", a)
+	}
+	os.Exit(exitWarnings)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Reports aggregate the scores of a whole library, walking directories
+// the way normalize does.
+
+var reporters = map[string]func(args []string){
+	"keys":    runReportKeys,
+	"tempo":   runReportTempo,
+	"tunings": runReportTunings,
+}
+
+func runReport(args []string) {
+	if len(args) == 0 || reporters[args[0]] == nil {
+		kinds := make([]string, 0, len(reporters))
+		for k := range reporters {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		fmt.Printf("Usage: gpx2gp report <%s> [options] <folder|input.gpx>...\n", strings.Join(kinds, "|"))
+		os.Exit(1)
+	}
+	reporters[args[0]](args[1:])
+}
+
+// reportScores loads every score under paths in turn. Scores that fail
+// to load are reported and left out.
+func reportScores(paths []string, each func(path string, score *Gpif)) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	inputs, err := normalizeInputs(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	for _, inputPath := range inputs {
+		fs, score, err := readScore(inputPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s: left out: %v\n", inputPath, err)
+			continue
+		}
+		each(inputPath, score)
+		fs.Close()
+	}
+}
+
+// writeReport writes a report as indented JSON, or as text through text.
+// It refuses to replace an existing file, which may be one of the inputs.
+func writeReport(outputPath string, asJSON bool, report interface{}, text func(w io.Writer)) {
+	if err := checkExportOutput("", outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+	if !asJSON {
+		text(w)
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		fmt.Printf("Error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// String-count migration moves a part onto an instrument with more or
+// fewer strings. Added strings go below the lowest one, a fourth apart as
+// on 7 and 8-string guitars and 5-string basses, and every note keeps its
+// string and fret. Removing strings takes them from the bottom: notes on
+// the strings that remain stay where they are, notes on the removed ones
+// are refingered onto a free string at the same pitch, and a part with
+// notes below the new range cannot be moved.
+
+// restringFlags are the -strings values, as track=count
+var restringFlags listFlag
+
+// String counts -strings accepts
+const (
+	minStrings = 4
+	maxStrings = 8
+	maxFret    = 24
+)
+
+// instrumentStrings is the string count at the end of an instrument
+// reference such as e-gtr6 or e-bass4
+var instrumentStrings = regexp.MustCompile(`^(.*\D)(\d)$`)
+
+// restringTuning returns the tuning with count strings, lowest first
+func restringTuning(tuning []int, count int) []int {
+	if count <= len(tuning) {
+		return append([]int(nil), tuning[len(tuning)-count:]...)
+	}
+	added := make([]int, count-len(tuning))
+	low := tuning[0]
+	for i := len(added) - 1; i >= 0; i-- {
+		low -= 5
+		added[i] = low
+	}
+	return append(added, tuning...)
+}
+
+// fingering is where a note is played
+type fingering struct {
+	str, fret int
+}
+
+// restringPlan is the new tuning of a track and the new place of its notes
+type restringPlan struct {
+	track      int // id
+	name       string
+	from, to   []int
+	notes      map[int]fingering // by note id
+	refingered int
+}
+
+// planRestring works out where the notes of a track go on count strings
+func (g *Gpif) planRestring(index, count int) (*restringPlan, error) {
+	track := &g.Tracks[index]
+	name := strings.TrimSpace(track.Name)
+	tuning := track.Tuning()
+	if len(tuning) == 0 || track.IsPercussion() {
+		return nil, fmt.Errorf("%s has no strings to change", name)
+	}
+	p := &restringPlan{track: track.ID, name: name, from: tuning, to: restringTuning(tuning, count), notes: make(map[int]fingering)}
+	shift := len(p.to) - len(p.from)
+
+	var unplayable []int // bars, from 1
+	for m := range g.MasterBars {
+		bar := g.TrackBar(m, index)
+		if bar == nil {
+			continue
+		}
+		for _, voice := range g.BarVoices(bar) {
+			for _, beat := range g.VoiceBeats(voice) {
+				used := make(map[int]bool)
+				var moved []*GpifNote
+				for _, note := range g.BeatNotes(beat) {
+					str, fret, ok := note.StringFret()
+					if !ok || str < 0 || str >= len(tuning) {
+						continue
+					}
+					if str+shift < 0 {
+						moved = append(moved, note)
+						continue
+					}
+					p.notes[note.ID] = fingering{str + shift, fret}
+					used[str+shift] = true
+				}
+				for _, note := range moved {
+					str, fret, _ := note.StringFret()
+					pitch := tuning[str] + fret
+					// The free string with the lowest fret
+					best := -1
+					for s := len(p.to) - 1; s >= 0; s-- {
+						if f := pitch - p.to[s]; !used[s] && f >= 0 && f <= maxFret && (best < 0 || f < pitch-p.to[best]) {
+							best = s
+						}
+					}
+					if best < 0 {
+						unplayable = append(unplayable, m+1)
+						continue
+					}
+					p.notes[note.ID] = fingering{best, pitch - p.to[best]}
+					used[best] = true
+					p.refingered++
+				}
+			}
+		}
+	}
+	if len(unplayable) > 0 {
+		return nil, fmt.Errorf("%s has %s that cannot be played on %d strings, the first in bar %d",
+			name, plural(len(unplayable), "note"), count, unplayable[0])
+	}
+	return p, nil
+}
+
+// resolveRestring turns the -strings flags into plans for a score
+func (g *Gpif) resolveRestring() ([]*restringPlan, error) {
+	var plans []*restringPlan
+	for _, v := range restringFlags {
+		i := strings.LastIndex(v, "=")
+		count, err := strconv.Atoi(strings.TrimSpace(v[i+1:]))
+		if i < 0 || err != nil || count < minStrings || count > maxStrings {
+			return nil, fmt.Errorf("-strings %q: use track=count, from %d to %d strings", v, minStrings, maxStrings)
+		}
+		t, err := g.findTrack(v[:i])
+		if err != nil {
+			return nil, fmt.Errorf("-strings: %v", err)
+		}
+		index := 0
+		for index < len(g.Tracks) && &g.Tracks[index] != t {
+			index++
+		}
+		p, err := g.planRestring(index, count)
+		if err != nil {
+			return nil, fmt.Errorf("-strings: %v", err)
+		}
+		plans = append(plans, p)
+	}
+	return plans, nil
+}
+
+// applyRestring writes the new tunings, instruments and fingerings into a
+// score
+func applyRestring(gpif []byte, plans []*restringPlan) ([]byte, error) {
+	byTrack := make(map[int]*restringPlan)
+	notes := make(map[int]fingering)
+	for _, p := range plans {
+		byTrack[p.track] = p
+		for id, f := range p.notes {
+			notes[id] = f
+		}
+	}
+	var plan *restringPlan
+	property := ""
+	note := -1
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "Track" && inside(stack, "Tracks"):
+				id, _ := strconv.Atoi(attrValue(t, "id"))
+				plan = byTrack[id]
+			case t.Name.Local == "Note" && inside(stack, "Notes"):
+				note, _ = strconv.Atoi(attrValue(t, "id"))
+			case t.Name.Local == "Property":
+				property = attrValue(t, "name")
+			case t.Name.Local == "Instrument" && inside(stack, "Tracks", "Track") && plan != nil:
+				for i, a := range t.Attr {
+					if m := instrumentStrings.FindStringSubmatch(a.Value); a.Name.Local == "ref" && m != nil && m[2] == strconv.Itoa(len(plan.from)) {
+						t.Attr[i].Value = m[1] + strconv.Itoa(len(plan.to))
+					}
+				}
+				return []xml.Token{t}
+			}
+		case xml.CharData:
+			switch {
+			case plan != nil && property == "Tuning" && inside(stack, "Property", "Pitches"):
+				pitches := make([]string, len(plan.to))
+				for i, pitch := range plan.to {
+					pitches[i] = strconv.Itoa(pitch)
+				}
+				return []xml.Token{xml.CharData(strings.Join(pitches, " "))}
+			case inside(stack, "Note", "Properties", "Property", "String") && property == "String":
+				if f, ok := notes[note]; ok {
+					return []xml.Token{xml.CharData(strconv.Itoa(f.str))}
+				}
+			case inside(stack, "Note", "Properties", "Property", "Fret") && property == "Fret":
+				if f, ok := notes[note]; ok {
+					return []xml.Token{xml.CharData(strconv.Itoa(f.fret))}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "Track" && len(stack) >= 2 && stack[len(stack)-2] == "Tracks" {
+				plan = nil
+			}
+		}
+		return []xml.Token{tok}
+	})
+	return out, err
+}
+
+// restring applies the -strings flags to score.gpif, describing the result
+func (fs *GpxFileSystem) restring() ([]string, error) {
+	if len(restringFlags) == 0 {
+		return nil, nil
+	}
+	score, err := fs.loadScore()
+	if err != nil {
+		return nil, err
+	}
+	plans, err := score.resolveRestring()
+	if err != nil {
+		return nil, err
+	}
+	file := fs.File("score.gpif")
+	data, err := applyRestring(file.Data, plans)
+	if err != nil {
+		return nil, fmt.Errorf("changing strings in score.gpif: %v", err)
+	}
+	file.Data, file.FileSize = data, len(data)
+
+	var done []string
+	for _, p := range plans {
+		s := fmt.Sprintf("%s: %d to %d strings, tuned %s", p.name, len(p.from), len(p.to), tuningName(p.to))
+		if p.refingered > 0 {
+			s += ", " + plural(p.refingered, "note") + " refingered"
+		}
+		done = append(done, s)
+	}
+	return done, nil
+}
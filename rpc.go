@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// gpx2gp rpc keeps the converter running as a subprocess, answering
+// JSON-RPC 2.0 requests, one per line on stdin, with one response per line
+// on stdout. Methods:
+//
+//	convert {"input", "output", "mode"}  the status -json prints
+//	inspect {"input", "mode"}            container and score summary
+//	extract {"input", "output", "mode"}  inner files written to a directory
+//
+// "mode" is "strict", "lenient" or empty for the default parse mode.
+// Requests are answered in order; the process exits at end of input.
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000 // the method ran and failed
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcParams are the parameters of every method, unused ones are ignored
+type rpcParams struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	Mode   string `json:"mode"`
+}
+
+// rpcFile is an inner file as inspect and extract list it
+type rpcFile struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+type rpcInspectResult struct {
+	Input    string    `json:"input"`
+	SHA256   string    `json:"sha256"`
+	Source   string    `json:"source"`
+	Title    string    `json:"title,omitempty"`
+	Artist   string    `json:"artist,omitempty"`
+	Tracks   int       `json:"tracks"`
+	Bars     int       `json:"bars"`
+	Files    []rpcFile `json:"files"`
+	Warnings []string  `json:"warnings"`
+}
+
+type rpcExtractResult struct {
+	Output   string    `json:"output"`
+	Files    []rpcFile `json:"files"`
+	Warnings []string  `json:"warnings"`
+}
+
+var rpcMethods = map[string]func(p rpcParams) (interface{}, error){
+	"convert": rpcConvert,
+	"inspect": rpcInspect,
+	"extract": rpcExtract,
+}
+
+func invalidParams(format string, a ...interface{}) error {
+	return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, a...)}
+}
+
+func rpcConvert(p rpcParams) (interface{}, error) {
+	if p.Input == "" || p.Output == "" {
+		return nil, invalidParams("convert needs input and output")
+	}
+	if isRemotePath(p.Output) {
+		return nil, invalidParams("convert writes local files only")
+	}
+	outputPath := p.Output
+	if info, err := os.Stat(outputPath); err == nil && info.IsDir() {
+		outputPath = namedOutputPath("", p.Input, outputPath)
+	} else {
+		outputPath = withExtension(outputPath, ".gp")
+		if err := checkOutputPath(p.Input, outputPath); err != nil {
+			return nil, err
+		}
+	}
+	return convertTo(p.Input, outputPath), nil
+}
+
+func rpcInspect(p rpcParams) (interface{}, error) {
+	if p.Input == "" {
+		return nil, invalidParams("inspect needs input")
+	}
+	if p.Mode == "" {
+		// As gpx2gp inspect, which looks at damaged files too
+		parseMode = ParseLenient
+	}
+	fs, err := readGpx(p.Input)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+	result := &rpcInspectResult{Input: p.Input, SHA256: fs.Source, Source: fs.SourceVersion(), Files: []rpcFile{}, Warnings: []string{}}
+	if score, err := fs.loadScore(); err == nil {
+		result.Title = strings.TrimSpace(score.Score.Title)
+		result.Artist = strings.TrimSpace(score.Score.Artist)
+		result.Tracks, result.Bars = len(score.Tracks), len(score.MasterBars)
+	}
+	for _, f := range fs.Files {
+		result.Files = append(result.Files, rpcFile{Name: f.FileName, Size: f.FileSize})
+	}
+	result.Warnings = append(result.Warnings, fs.Warnings...)
+	return result, nil
+}
+
+func rpcExtract(p rpcParams) (interface{}, error) {
+	if p.Input == "" || p.Output == "" {
+		return nil, invalidParams("extract needs input and output")
+	}
+	fs, err := readGpx(p.Input)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+	result := &rpcExtractResult{Output: p.Output, Files: []rpcFile{}, Warnings: []string{}}
+	err = extractFiles(fs, p.Output, func(name string, data []byte) {
+		result.Files = append(result.Files, rpcFile{Name: name, Size: len(data)})
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Warnings = append(result.Warnings, fs.Warnings...)
+	return result, nil
+}
+
+// rpcCall runs one request; the parse mode and remote inputs are reset
+// for every request, as each would be a process of its own
+func rpcCall(req *rpcRequest) (interface{}, error) {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"}
+	}
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+	}
+	var p rpcParams
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &p); err != nil {
+			return nil, invalidParams("params must be an object: %v", err)
+		}
+	}
+	switch p.Mode {
+	case "":
+		parseMode = ParseDefault
+	case "strict":
+		parseMode = ParseStrict
+	case "lenient":
+		parseMode = ParseLenient
+	default:
+		return nil, invalidParams("unknown mode %q", p.Mode)
+	}
+	remoteFiles = make(map[string]*remoteFile)
+	return method(p)
+}
+
+func runRPC(args []string) {
+	cmd := flag.NewFlagSet("rpc", flag.ExitOnError)
+	cmd.StringVar(&webhookURL, "webhook", "", "POST the status of every conversion as JSON to this URL")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		fmt.Println("Usage: gpx2gp rpc [-webhook URL]  (JSON-RPC 2.0 requests, one per line on stdin)")
+		os.Exit(1)
+	}
+	if webhookURL != "" {
+		if err := checkWebhookURL(webhookURL); err != nil {
+			fmt.Printf("Error: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	for {
+		line, readErr := in.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var req rpcRequest
+			resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+			if err := json.Unmarshal(line, &req); err != nil {
+				resp.Error = &rpcError{Code: rpcParseError, Message: err.Error()}
+				out.Encode(resp)
+			} else {
+				result, err := rpcCall(&req)
+				// Requests without an id are notifications, not answered
+				if len(req.ID) > 0 {
+					resp.ID = req.ID
+					if e, ok := err.(*rpcError); ok {
+						resp.Error = e
+					} else if err != nil {
+						resp.Error = &rpcError{Code: rpcFailed, Message: err.Error()}
+					} else {
+						resp.Result = result
+					}
+					out.Encode(resp)
+				}
+			}
+		}
+		if readErr != nil {
+			return
+		}
+	}
+}
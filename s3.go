@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The s3:// storage: objects in AWS S3 or compatible services, named
+// s3://bucket/key. Requests are signed with AWS Signature Version 4 from
+// the usual environment variables; AWS_ENDPOINT_URL points at MinIO and
+// other services, which are addressed path-style.
+
+// s3Object is a parsed s3:// path
+type s3Object struct {
+	Bucket, Key string
+}
+
+func parseS3Path(path string) (s3Object, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(path, "s3://"), "/")
+	if bucket == "" || key == "" {
+		return s3Object{}, fmt.Errorf("%s is not an s3://bucket/key path", path)
+	}
+	return s3Object{Bucket: bucket, Key: key}, nil
+}
+
+// s3Client signs and sends requests for objects
+type s3Client struct {
+	endpoint  *url.URL // nil for AWS itself
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+func newS3Client() (*s3Client, error) {
+	c := &s3Client{
+		region:    firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, fmt.Errorf("s3 paths need AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+		}
+		c.endpoint = u
+	}
+	return c, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// objectURL addresses obj virtual-hosted on AWS, path-style elsewhere
+func (c *s3Client) objectURL(obj s3Object) *url.URL {
+	u := &url.URL{Scheme: "https", Host: obj.Bucket + ".s3." + c.region + ".amazonaws.com"}
+	key := "/" + obj.Key
+	if c.endpoint != nil {
+		*u = *c.endpoint
+		key = strings.TrimSuffix(u.Path, "/") + "/" + obj.Bucket + key
+	}
+	// Signatures cover the path escaped as S3 escapes it, which is
+	// stricter than net/url
+	u.Path, u.RawPath = key, s3Escape(key)
+	return u
+}
+
+// s3Escape percent-encodes every byte of a path but unreserved characters
+// and slashes
+func s3Escape(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		b := path[i]
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// do sends a signed request for obj with body as the payload
+func (c *s3Client) do(method string, obj s3Object, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.objectURL(obj).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body, req.GetBody, req.ContentLength = nil, nil, 0
+	}
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+	c.sign(req, time.Now().UTC())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, fmt.Errorf("s3://%s/%s: %s %s", obj.Bucket, obj.Key, resp.Status, s3ErrorCode(detail))
+	}
+	return resp, nil
+}
+
+// s3ErrorCode picks the code out of an S3 error document
+func s3ErrorCode(doc []byte) string {
+	_, rest, ok := bytes.Cut(doc, []byte("<Code>"))
+	if !ok {
+		return ""
+	}
+	code, _, _ := bytes.Cut(rest, []byte("</Code>"))
+	return "(" + string(code) + ")"
+}
+
+// sign adds the Signature Version 4 authorization, covering the host and
+// every header already set on req
+func (c *s3Client) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonical.String(),
+		signed,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	requestSum := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+
+	key := []byte("AWS4" + c.secretKey)
+	for _, part := range []string{day, c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.accessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Storage is the backend of s3:// paths
+type s3Storage struct{}
+
+func init() {
+	registerStorage("s3", s3Storage{})
+}
+
+// open parses path and sets up a client from the environment
+func (s3Storage) open(path string) (*s3Client, s3Object, error) {
+	obj, err := parseS3Path(path)
+	if err != nil {
+		return nil, obj, err
+	}
+	c, err := newS3Client()
+	return c, obj, err
+}
+
+func (s s3Storage) Read(path string) ([]byte, time.Time, error) {
+	c, obj, err := s.open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := c.do(http.MethodGet, obj, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength > 0 {
+		if err := limits.checkInput(int(resp.ContentLength)); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	data, err := readLimited(resp.Body)
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return data, modified, err
+}
+
+func (s s3Storage) Exists(path string) (bool, error) {
+	c, obj, err := s.open(path)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(http.MethodHead, obj, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+func (s s3Storage) Write(path string, data []byte) error {
+	c, obj, err := s.open(path)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPut, obj, data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
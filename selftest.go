@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// selftest.gpx is a small synthetic song, compressed with back-references
+// so the whole BCFZ decoder is exercised
+//
+//go:embed selftest.gpx
+var selftestGpx []byte
+
+// selftestSum is the SHA-256 of the stored, reproducible archive the CLI
+// converts selftest.gpx to. Stored entries keep it independent of the deflate
+// implementation.
+const selftestSum = "42f12fae5e19896e331f43f0939ca80d52ae2d77d6385e496bc926a99da6897e"
+
+// selftest converts the embedded fixture in memory, returning one line per
+// step passed or the first failure
+func selftest() ([]string, error) {
+	var passed []string
+
+	fs := &GpxFileSystem{Limits: limits, Mode: ParseStrict}
+	if err := fs.Load(selftestGpx); err != nil {
+		return passed, fmt.Errorf("reading the fixture: %v", err)
+	}
+	if len(fs.Files) != 2 || fs.File("score.gpif") == nil {
+		return passed, fmt.Errorf("reading the fixture: found %d files", len(fs.Files))
+	}
+	passed = append(passed, fmt.Sprintf("decompressed and read the container, %d files", len(fs.Files)))
+
+	score, err := fs.loadScore()
+	if err != nil {
+		return passed, err
+	}
+	if problems := score.Validate(); len(problems) > 0 {
+		return passed, fmt.Errorf("validating the score: %s", strings.Join(problems, "; "))
+	}
+	tl := score.BuildTimeline()
+	notes := 0
+	for i := range score.Tracks {
+		notes += len(score.TrackNotes(tl, i))
+	}
+	if notes == 0 {
+		return passed, fmt.Errorf("building the timeline: no notes found")
+	}
+	passed = append(passed, fmt.Sprintf("parsed the score, %d bars and %d notes", len(tl.Bars), notes))
+
+	out, err := selftestConvert()
+	if err != nil {
+		return passed, fmt.Errorf("converting the fixture: %v", err)
+	}
+	sum := sha256.Sum256(out)
+	if got := hex.EncodeToString(sum[:]); got != selftestSum {
+		return passed, fmt.Errorf("archive hash is %s, expected %s", got, selftestSum)
+	}
+	passed = append(passed, "converted the fixture to the archive with the expected SHA-256")
+
+	zr, err := zip.NewReader(bytes.NewReader(out), int64(len(out)))
+	if err != nil {
+		return passed, fmt.Errorf("reopening the archive: %v", err)
+	}
+	if problems := append(checkArchive(zr, rulesets[defaultTarget]), checkAlphaTab(zr)...); len(problems) > 0 {
+		return passed, fmt.Errorf("checking the archive: %s", strings.Join(problems, "; "))
+	}
+	passed = append(passed, "archive passes the Guitar Pro 7 and alphaTab checks")
+	return passed, nil
+}
+
+// selftestConvert converts the fixture from a temporary file the way the
+// CLI converts a .gpx, migrations and conformance check included, into a
+// stored, reproducible archive it returns
+func selftestConvert() ([]byte, error) {
+	dir, err := os.MkdirTemp("", "gpx2gp-selftest-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input, output := filepath.Join(dir, "selftest.gpx"), filepath.Join(dir, "selftest.gp")
+	if err := os.WriteFile(input, selftestGpx, 0644); err != nil {
+		return nil, err
+	}
+
+	savedOptions, savedMode := archiveOptions, parseMode
+	archiveOptions = ArchiveOptions{Reproducible: true, Method: zip.Store, Level: savedOptions.Level}
+	parseMode = ParseDefault
+	defer func() { archiveOptions, parseMode = savedOptions, savedMode }()
+	if _, err := mirrorConvert(input, output); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(output)
+}
+
+func runSelftest(args []string) {
+	cmd := flag.NewFlagSet("selftest", flag.ExitOnError)
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	passed, err := selftest()
+	for _, p := range passed {
+		fmt.Printf("ok    %s\n", p)
+	}
+	if err != nil {
+		fmt.Printf("FAIL  %v\n", err)
+		fmt.Println("This build of gpx2gp does not work correctly on this platform.")
+		os.Exit(1)
+	}
+	fmt.Printf("gpx2gp %s works on this platform.\n", toolVersion())
+}
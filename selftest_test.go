@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestSelftest(t *testing.T) {
+	passed, err := selftest()
+	for _, p := range passed {
+		t.Log(p)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gpx2gp setlist converts the songs of a gig in order into one folder or
+// zip: numbered .gp files, a printable index with key, tempo and length,
+// and optionally a songbook PDF of them all.
+
+var majorKeys = []string{"Cb", "Gb", "Db", "Ab", "Eb", "Bb", "F", "C", "G", "D", "A", "E", "B", "F#", "C#"}
+var minorKeys = []string{"Ab", "Eb", "Bb", "F", "C", "G", "D", "A", "E", "B", "F#", "C#", "G#", "D#", "A#"}
+
+// Name spells the key signature, e.g. "G major", from its sharps
+// (positive) or flats (negative)
+func (k GpifKey) Name() string {
+	i := k.AccidentalCount + 7
+	if i < 0 || i >= len(majorKeys) {
+		return "?"
+	}
+	if strings.EqualFold(strings.TrimSpace(k.Mode), "minor") {
+		return minorKeys[i] + " minor"
+	}
+	return majorKeys[i] + " major"
+}
+
+// formatClock formats a duration in seconds as m:ss
+func formatClock(seconds float64) string {
+	s := int(seconds + 0.5)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// setlistSong is one converted song of the setlist
+type setlistSong struct {
+	file     string // name in the output
+	title    string
+	artist   string
+	key      string
+	bpm      float64
+	seconds  float64
+	archive  []byte
+	songbook *songbookEntry
+}
+
+// newSetlistSong converts the n-th song and gathers what the index shows
+func newSetlistSong(n int, inputPath string, withPages bool, width int) (*setlistSong, error) {
+	gpx, err := readGpx(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer gpx.Close()
+	if _, _, err := gpx.migrateScore(); err != nil {
+		return nil, err
+	}
+	for _, w := range gpx.Warnings {
+		fmt.Printf("Warning: %s: %s\n", inputPath, w)
+	}
+	score, err := gpx.loadScore()
+	if err != nil {
+		return nil, err
+	}
+
+	var archive bytes.Buffer
+	if err := writeGpArchive(&archive, gpx); err != nil {
+		return nil, fmt.Errorf("creating archive: %v", err)
+	}
+	if err := conformData(archive.Bytes()); err != nil {
+		return nil, err
+	}
+
+	name := score.scoreFileName()
+	if name == "" {
+		name = safeFileName(strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)))
+	}
+	song := &setlistSong{
+		file:    fmt.Sprintf("%02d %s.gp", n, name),
+		title:   strings.TrimSpace(score.Score.Title),
+		artist:  strings.TrimSpace(score.Score.Artist),
+		key:     "?",
+		bpm:     120,
+		archive: archive.Bytes(),
+	}
+	if song.title == "" {
+		song.title = filepath.Base(inputPath)
+	}
+	if len(score.MasterBars) > 0 {
+		song.key = score.MasterBars[0].Key.Name()
+		song.bpm = score.masterBarTempos()[0]
+	}
+	tl := score.BuildTimeline()
+	song.seconds = tl.Duration()
+
+	if withPages {
+		entry, err := newSongbookEntry(score, inputPath, -1, width)
+		if err != nil {
+			fmt.Printf("Warning: %s: left out of the songbook: %v\n", inputPath, err)
+		} else {
+			song.songbook = &entry
+		}
+	}
+	return song, nil
+}
+
+// setlistIndex is the printable running order
+func setlistIndex(title string, songs []*setlistSong) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n\n", title, strings.Repeat("=", len(title)))
+	total := 0.0
+	for i, s := range songs {
+		name := s.title
+		if s.artist != "" {
+			name += " - " + s.artist
+		}
+		fmt.Fprintf(&b, "%2d. %-44s %-10s %4s bpm %6s\n", i+1, name, s.key, formatBpm(s.bpm), formatClock(s.seconds))
+		total += s.seconds
+	}
+	fmt.Fprintf(&b, "\n%d songs, %s total\n", len(songs), formatClock(total))
+	return b.String()
+}
+
+func runSetlist(args []string) {
+	cmd := flag.NewFlagSet("setlist", flag.ExitOnError)
+	var outputPath, title string
+	var pdf bool
+	var width int
+	cmd.StringVar(&outputPath, "o", "", "Output folder, or a .zip file")
+	cmd.StringVar(&outputPath, "out", "", "Output folder, or a .zip file")
+	cmd.StringVar(&title, "title", "Setlist", "Title printed on the index and songbook")
+	cmd.BoolVar(&pdf, "pdf", false, "Also write songbook.pdf with the tab of every song")
+	cmd.IntVar(&width, "width", 80, "Wrap songbook tab lines at this many columns")
+	cmd.StringVar(&fileNameChars, "filename-chars", fileNameChars, "Characters in song file names: "+strings.Join(fileNameCharsModes, ", "))
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if outputPath == "" || cmd.NArg() == 0 {
+		fmt.Println("Usage: gpx2gp setlist -o <folder|setlist.zip> [-title T] [-pdf] <input.gpx>...")
+		os.Exit(1)
+	}
+	if _, err := os.Stat(outputPath); err == nil {
+		fmt.Printf("Error: Output '%s' already exists.\n", outputPath)
+		os.Exit(1)
+	}
+	if err := checkFileNameChars(fileNameChars); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+	inputs, err := collectInputs(cmd.Args())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// A setlist missing a song is no use on stage, any failure is fatal
+	var songs []*setlistSong
+	for i, inputPath := range inputs {
+		song, err := newSetlistSong(i+1, inputPath, pdf, width)
+		if err != nil {
+			fmt.Printf("Error: %s: %v\n", inputPath, err)
+			os.Exit(1)
+		}
+		songs = append(songs, song)
+		debug("Converted %s as %s", inputPath, song.file)
+	}
+
+	files := []generatedFile{{"index.txt", []byte(setlistIndex(title, songs))}}
+	for _, s := range songs {
+		files = append(files, generatedFile{s.file, s.archive})
+	}
+	if pdf {
+		var entries []songbookEntry
+		for _, s := range songs {
+			if s.songbook != nil {
+				entries = append(entries, *s.songbook)
+			}
+		}
+		if len(entries) > 0 {
+			var book bytes.Buffer
+			if err := buildSongbook(title, entries, width).Write(&book); err != nil {
+				fmt.Printf("Error writing songbook: %v\n", err)
+				os.Exit(1)
+			}
+			files = append(files, generatedFile{"songbook.pdf", book.Bytes()})
+		}
+	}
+
+	if strings.EqualFold(filepath.Ext(outputPath), ".zip") {
+		err = writeSetlistZip(outputPath, files)
+	} else {
+		err = writeSetlistDir(outputPath, files)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s: %d songs.\n", outputPath, len(songs))
+}
+
+func writeSetlistDir(dir string, files []generatedFile) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.path), f.data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSetlistZip stores the files under a folder named after the zip, so
+// unpacking it gives the same layout as writing a folder
+func writeSetlistZip(path string, files []generatedFile) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	folder := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	zw := zip.NewWriter(out)
+	for _, f := range files {
+		// .gp files are zips themselves, compressing them again gains nothing
+		method := zip.Deflate
+		if strings.HasSuffix(f.path, ".gp") {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: folder + "/" + f.path, Method: method, Modified: time.Now()})
+		if err == nil {
+			_, err = w.Write(f.data)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(path)
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The sftp:// storage: files on servers reachable over SSH, named
+// sftp://user@host:port/path, with /~/ starting a path in the user's home
+// directory. The system ssh client opens the connection, so keys, agents,
+// known hosts and ~/.ssh/config apply as for any other ssh command, and
+// its sftp subsystem is spoken here in protocol version 3.
+
+// sftpCommand starts the transport, GPX2GP_SSH replaces "ssh"
+var sftpCommand = func(user, host, port string) *exec.Cmd {
+	ssh := os.Getenv("GPX2GP_SSH")
+	if ssh == "" {
+		ssh = "ssh"
+	}
+	args := []string{"-s"}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	if user != "" {
+		host = user + "@" + host
+	}
+	return exec.Command(ssh, append(args, "--", host, "sftp")...)
+}
+
+// SFTP packet types and flags of protocol version 3
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpOpen    = 3
+	sftpClose   = 4
+	sftpRead    = 5
+	sftpWrite   = 6
+	sftpStat    = 17
+	sftpStatus  = 101
+	sftpHandle  = 102
+	sftpData    = 103
+	sftpAttrs   = 105
+
+	sftpOpenRead   = 0x01
+	sftpOpenWrite  = 0x02
+	sftpOpenCreate = 0x08
+	sftpOpenTrunc  = 0x10
+
+	sftpAttrSize   = 0x01
+	sftpAttrUIDGID = 0x02
+	sftpAttrPerms  = 0x04
+	sftpAttrTimes  = 0x08
+
+	sftpEOF        = 1
+	sftpNoSuchFile = 2
+
+	// sftpChunk is the data moved per read or write request
+	sftpChunk = 32 << 10
+)
+
+// errSftpNotFound is a status of no such file
+var errSftpNotFound = errors.New("no such file")
+
+// sftpSession is one connection to a server
+type sftpSession struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    io.ReadCloser
+	nextID uint32
+}
+
+// sftpAttributes are the parts of a file's attributes used here
+type sftpAttributes struct {
+	size     int64
+	modified time.Time
+}
+
+// parseSftpPath splits path by hand, file names may hold # and ? which
+// are not URL syntax here
+func parseSftpPath(path string) (user, host, port, file string, err error) {
+	authority, file, _ := strings.Cut(strings.TrimPrefix(path, "sftp://"), "/")
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		user, authority = authority[:at], authority[at+1:]
+	}
+	host = authority
+	if h, p, err := net.SplitHostPort(authority); err == nil {
+		host, port = h, p
+	}
+	if host == "" || file == "" || file == "~/" {
+		return "", "", "", "", fmt.Errorf("%s is not an sftp://[user@]host[:port]/path path", path)
+	}
+	// ssh would take a user or host starting with - as an option
+	for _, part := range []string{user, host, port} {
+		if strings.HasPrefix(part, "-") || strings.IndexFunc(part, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+			return "", "", "", "", fmt.Errorf("%s: %q is not a valid user, host or port", path, part)
+		}
+	}
+	if !strings.HasPrefix(file, "~/") {
+		file = "/" + file
+	} else {
+		file = file[2:]
+	}
+	return user, host, port, file, nil
+}
+
+// dialSftp connects to the server of path, returning the file on it
+func dialSftp(path string) (*sftpSession, string, error) {
+	user, host, port, file, err := parseSftpPath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &sftpSession{cmd: sftpCommand(user, host, port)}
+	s.cmd.Stderr = os.Stderr
+	if s.in, err = s.cmd.StdinPipe(); err != nil {
+		return nil, "", err
+	}
+	if s.out, err = s.cmd.StdoutPipe(); err != nil {
+		return nil, "", err
+	}
+	if err := s.cmd.Start(); err != nil {
+		return nil, "", fmt.Errorf("starting ssh: %v", err)
+	}
+	init := binary.BigEndian.AppendUint32(nil, 3)
+	if err := s.send(sftpInit, init); err != nil {
+		s.Close()
+		return nil, "", err
+	}
+	typ, payload, err := s.receive()
+	if err == nil && (typ != sftpVersion || len(payload) < 4) {
+		err = fmt.Errorf("unexpected packet %d", typ)
+	}
+	if err != nil {
+		s.Close()
+		return nil, "", fmt.Errorf("%s: sftp handshake failed: %v", host, err)
+	}
+	return s, file, nil
+}
+
+// Close ends the session and waits for ssh to exit
+func (s *sftpSession) Close() error {
+	s.in.Close()
+	s.out.Close()
+	return s.cmd.Wait()
+}
+
+func (s *sftpSession) send(typ byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)))
+	packet = append(packet, typ)
+	_, err := s.in.Write(append(packet, payload...))
+	return err
+}
+
+func (s *sftpSession) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(s.out, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:4])
+	if n == 0 || n > 1<<20 {
+		return 0, nil, fmt.Errorf("invalid packet length %d", n)
+	}
+	payload := make([]byte, n-1)
+	if _, err := io.ReadFull(s.out, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+// request sends a request and returns the type and payload of its
+// response, with the request id checked and removed
+func (s *sftpSession) request(typ byte, fields ...[]byte) (byte, []byte, error) {
+	s.nextID++
+	payload := binary.BigEndian.AppendUint32(nil, s.nextID)
+	for _, f := range fields {
+		payload = append(payload, f...)
+	}
+	if err := s.send(typ, payload); err != nil {
+		return 0, nil, err
+	}
+	rtyp, resp, err := s.receive()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 || binary.BigEndian.Uint32(resp) != s.nextID {
+		return 0, nil, fmt.Errorf("response to an unexpected request")
+	}
+	return rtyp, resp[4:], nil
+}
+
+// sftpStatusError turns a status payload into an error, nil for success
+func sftpStatusError(payload []byte) error {
+	if len(payload) < 4 {
+		return fmt.Errorf("truncated status")
+	}
+	code := binary.BigEndian.Uint32(payload)
+	if code == 0 {
+		return nil
+	}
+	if code == sftpNoSuchFile {
+		return errSftpNotFound
+	}
+	if code == sftpEOF {
+		return io.EOF
+	}
+	msg, _, _ := sftpString(payload[4:])
+	return fmt.Errorf("sftp error %d: %s", code, msg)
+}
+
+// sftpExpect checks that a response has type want, mapping statuses to errors
+func sftpExpect(typ byte, payload []byte, err error, want byte) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if typ == sftpStatus {
+		if err := sftpStatusError(payload); err != nil {
+			return nil, err
+		}
+	}
+	if typ != want {
+		return nil, fmt.Errorf("unexpected packet %d", typ)
+	}
+	return payload, nil
+}
+
+func sftpStr(s string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(s))), s...)
+}
+
+func sftpUint32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func sftpUint64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// sftpString splits a length-prefixed string off b
+func sftpString(b []byte) (string, []byte, error) {
+	if len(b) < 4 || uint64(len(b)-4) < uint64(binary.BigEndian.Uint32(b)) {
+		return "", nil, fmt.Errorf("truncated string")
+	}
+	n := binary.BigEndian.Uint32(b)
+	return string(b[4 : 4+n]), b[4+n:], nil
+}
+
+func parseSftpAttrs(b []byte) (sftpAttributes, error) {
+	var a sftpAttributes
+	if len(b) < 4 {
+		return a, fmt.Errorf("truncated attributes")
+	}
+	flags := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	take := func(n int) []byte {
+		if len(b) < n {
+			return nil
+		}
+		v := b[:n]
+		b = b[n:]
+		return v
+	}
+	if flags&sftpAttrSize != 0 {
+		v := take(8)
+		if v == nil {
+			return a, fmt.Errorf("truncated attributes")
+		}
+		a.size = int64(binary.BigEndian.Uint64(v))
+		if a.size < 0 {
+			return a, fmt.Errorf("invalid file size")
+		}
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		take(8)
+	}
+	if flags&sftpAttrPerms != 0 {
+		take(4)
+	}
+	if flags&sftpAttrTimes != 0 {
+		if v := take(8); v != nil {
+			a.modified = time.Unix(int64(binary.BigEndian.Uint32(v[4:])), 0)
+		}
+	}
+	return a, nil
+}
+
+func (s *sftpSession) stat(file string) (sftpAttributes, error) {
+	typ, payload, err := s.request(sftpStat, sftpStr(file))
+	payload, err = sftpExpect(typ, payload, err, sftpAttrs)
+	if err != nil {
+		return sftpAttributes{}, err
+	}
+	return parseSftpAttrs(payload)
+}
+
+func (s *sftpSession) open(file string, flags uint32) (string, error) {
+	typ, payload, err := s.request(sftpOpen, sftpStr(file), sftpUint32(flags), sftpUint32(0))
+	payload, err = sftpExpect(typ, payload, err, sftpHandle)
+	if err != nil {
+		return "", err
+	}
+	handle, _, err := sftpString(payload)
+	return handle, err
+}
+
+func (s *sftpSession) close(handle string) error {
+	typ, payload, err := s.request(sftpClose, sftpStr(handle))
+	_, err = sftpExpect(typ, payload, err, sftpStatus)
+	return err
+}
+
+// sftpStorage is the backend of sftp:// paths
+type sftpStorage struct{}
+
+func init() {
+	registerStorage("sftp", sftpStorage{})
+}
+
+func (sftpStorage) Read(path string) ([]byte, time.Time, error) {
+	s, file, err := dialSftp(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer s.Close()
+	attrs, err := s.stat(file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := limits.checkInput(int(attrs.size)); err != nil {
+		return nil, time.Time{}, err
+	}
+	handle, err := s.open(file, sftpOpenRead)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %v", path, err)
+	}
+	defer s.close(handle)
+
+	data := make([]byte, 0, attrs.size)
+	for limits.MaxInputSize <= 0 || len(data) <= limits.MaxInputSize {
+		typ, payload, err := s.request(sftpRead, sftpStr(handle), sftpUint64(uint64(len(data))), sftpUint32(sftpChunk))
+		payload, err = sftpExpect(typ, payload, err, sftpData)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %v", path, err)
+		}
+		chunk, _, err := sftpString(payload)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %v", path, err)
+		}
+		data = append(data, chunk...)
+	}
+	return data, attrs.modified, nil
+}
+
+func (sftpStorage) Exists(path string) (bool, error) {
+	s, file, err := dialSftp(path)
+	if err != nil {
+		return false, err
+	}
+	defer s.Close()
+	_, err = s.stat(file)
+	if err == errSftpNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (sftpStorage) Write(path string, data []byte) error {
+	s, file, err := dialSftp(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	handle, err := s.open(file, sftpOpenWrite|sftpOpenCreate|sftpOpenTrunc)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for offset := 0; offset < len(data); offset += sftpChunk {
+		chunk := data[offset:min(offset+sftpChunk, len(data))]
+		typ, payload, err := s.request(sftpWrite, sftpStr(handle), sftpUint64(uint64(offset)), sftpStr(string(chunk)))
+		if _, err := sftpExpect(typ, payload, err, sftpStatus); err != nil {
+			s.close(handle)
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := s.close(handle); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+func pitchName(pitch int) string {
+	return noteNames[((pitch%12)+12)%12]
+}
+
+// beatColumn renders one beat as a column of fret numbers, top string first
+func (g *Gpif) beatColumn(beat *GpifBeat, count int) []string {
+	column := make([]string, count)
+	for _, note := range g.BeatNotes(beat) {
+		str, fret, ok := note.StringFret()
+		if !ok || str < 0 || str >= count || note.Tie.Destination {
+			continue
+		}
+		text := fmt.Sprint(fret)
+		switch {
+		case note.HasProperty("Muted"):
+			text = "x"
+		case note.HasProperty("Bended"):
+			text += "b"
+		case note.HasProperty("HopoOrigin"):
+			text += "h"
+		case note.HasProperty("Slide"):
+			text += "/"
+		case note.Vibrato != "":
+			text += "~"
+		}
+		column[count-1-str] = text
+	}
+	return column
+}
+
+func beatPadding(ticks int) int {
+	switch {
+	case ticks >= ticksPerQuarter*4:
+		return 6
+	case ticks >= ticksPerQuarter*2:
+		return 4
+	case ticks >= ticksPerQuarter:
+		return 2
+	}
+	return 1
+}
+
+// renderTab draws the track as ASCII tab systems wrapped at width columns
+func (g *Gpif) renderTab(trackIndex, width int) []string {
+	track := &g.Tracks[trackIndex]
+	tuning := track.Tuning()
+	n := len(tuning)
+	if n == 0 {
+		return nil
+	}
+
+	labels := make([]string, n)
+	labelWidth := 0
+	for i := range tuning {
+		labels[i] = pitchName(tuning[n-1-i])
+		if len(labels[i]) > labelWidth {
+			labelWidth = len(labels[i])
+		}
+	}
+	if labels[0] == labels[n-1] {
+		labels[0] = strings.ToLower(labels[0])
+	}
+
+	var out []string
+	var header strings.Builder
+	lines := make([]strings.Builder, n)
+	startSystem := func() {
+		header.Reset()
+		header.WriteString(strings.Repeat(" ", labelWidth+1))
+		for i := range lines {
+			lines[i].Reset()
+			lines[i].WriteString(fmt.Sprintf("%-*s|", labelWidth, labels[i]))
+		}
+	}
+	flushSystem := func() {
+		if lines[0].Len() <= labelWidth+1 {
+			return
+		}
+		if h := strings.TrimRight(header.String(), " "); h != "" {
+			out = append(out, h)
+		}
+		for i := range lines {
+			out = append(out, lines[i].String())
+		}
+		out = append(out, "")
+	}
+
+	startSystem()
+	for m := range g.MasterBars {
+		bar := g.TrackBar(m, trackIndex)
+		if bar == nil {
+			continue
+		}
+		cols := make([]string, n)
+		if voices := g.BarVoices(bar); len(voices) > 0 {
+			for _, beat := range g.VoiceBeats(voices[0]) {
+				column := g.beatColumn(beat, n)
+				w := 0
+				for _, c := range column {
+					w = max(w, len(c))
+				}
+				w += beatPadding(g.BeatTicks(beat))
+				for i, c := range column {
+					cols[i] += "-" + c + strings.Repeat("-", w-len(c)-1)
+				}
+			}
+		}
+		barWidth := len(cols[0]) + 1
+		if lines[0].Len()+barWidth > width && lines[0].Len() > labelWidth+1 {
+			flushSystem()
+			startSystem()
+		}
+		label := ""
+		if s := g.MasterBars[m].Section; s != nil {
+			label = "[" + strings.TrimSpace(s.Text) + "]"
+		}
+		if header.Len() < lines[0].Len() {
+			header.WriteString(strings.Repeat(" ", lines[0].Len()-header.Len()))
+		}
+		header.WriteString(label)
+		for i := range lines {
+			lines[i].WriteString(cols[i] + "|")
+		}
+	}
+	flushSystem()
+	return out
+}
+
+func writeShareText(w io.Writer, g *Gpif, trackIndex, width int, format string) error {
+	track := &g.Tracks[trackIndex]
+	var b strings.Builder
+
+	title := strings.TrimSpace(g.Score.Title)
+	if title == "" {
+		title = "Untitled"
+	}
+	artist := strings.TrimSpace(g.Score.Artist)
+	switch format {
+	case "markdown":
+		fmt.Fprintf(&b, "**%s**", title)
+	case "bbcode":
+		fmt.Fprintf(&b, "[b]%s[/b]", title)
+	default:
+		b.WriteString(title)
+	}
+	if artist != "" {
+		fmt.Fprintf(&b, " by %s", artist)
+	}
+	b.WriteString("\n")
+
+	var names []string
+	for _, p := range track.Tuning() {
+		names = append(names, pitchName(p))
+	}
+	capo := "none"
+	if c := track.Capo(); c > 0 {
+		capo = fmt.Sprintf("fret %d", c)
+	}
+	fmt.Fprintf(&b, "Track: %s | Tuning: %s | Capo: %s\n\n", track.Name, strings.Join(names, " "), capo)
+
+	tab := strings.TrimRight(strings.Join(g.renderTab(trackIndex, width), "\n"), "\n")
+	switch format {
+	case "markdown":
+		fmt.Fprintf(&b, "```\n%s\n```\n", tab)
+	case "bbcode":
+		fmt.Fprintf(&b, "[code]\n%s\n[/code]\n", tab)
+	default:
+		fmt.Fprintf(&b, "%s\n", tab)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func runShare(args []string) {
+	cmd := flag.NewFlagSet("share", flag.ExitOnError)
+	var inputPath, outputPath, format string
+	var trackIndex, width int
+	cmd.StringVar(&inputPath, "f", "", "Input GPX file")
+	cmd.StringVar(&inputPath, "file", "", "Input GPX file")
+	cmd.StringVar(&outputPath, "o", "", "Output text file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output text file (default: stdout)")
+	cmd.IntVar(&trackIndex, "track", 0, "Track index to render")
+	cmd.IntVar(&width, "width", 80, "Wrap tab lines at this many columns")
+	cmd.StringVar(&format, "format", "markdown", "Code block style: markdown, bbcode or plain")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if inputPath == "" {
+		fmt.Println("Usage: gpx2gp share -f <input.gpx> [-o <output.txt>] [-track N] [-width 80] [-format markdown|bbcode|plain]")
+		os.Exit(1)
+	}
+	if format != "markdown" && format != "bbcode" && format != "plain" {
+		fmt.Printf("Error: unknown format '%s'.\n", format)
+		os.Exit(1)
+	}
+	if err := checkExportOutput(inputPath, outputPath); err != nil {
+		fmt.Printf("Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	_, score, err := readScore(inputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if trackIndex < 0 || trackIndex >= len(score.Tracks) {
+		fmt.Printf("Error: track %d out of range (score has %d tracks).\n", trackIndex, len(score.Tracks))
+		os.Exit(1)
+	}
+	if len(score.Tracks[trackIndex].Tuning()) == 0 {
+		fmt.Printf("Error: track %d (%s) is not a fretted instrument.\n", trackIndex, score.Tracks[trackIndex].Name)
+		os.Exit(1)
+	}
+
+	w, err := createOutput(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+	if err := writeShareText(w, score, trackIndex, width, format); err != nil {
+		fmt.Printf("Error writing share text: %v\n", err)
+		os.Exit(1)
+	}
+}
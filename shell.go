@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Explorer integration on Windows: a "Convert to .gp" entry in the context
+// menu of .gpx files. It is registered for the current user under the
+// system file associations, so it needs no administrator rights and does
+// not take the .gpx association away from Guitar Pro.
+
+// shellKey is the registry key of the context menu entry
+const shellKey = `HKCU\Software\Classes\SystemFileAssociations\.gpx\shell\gpx2gp`
+
+// shellValue is one registry value, Name "" is the key's default value
+type shellValue struct {
+	Key, Name, Data string
+}
+
+// shellValues describe the entry for the converter at exe. The output is
+// named after the score in the file's folder, and the console window
+// stays open when there is something to read.
+func shellValues(exe string) []shellValue {
+	command := fmt.Sprintf(`cmd.exe /c ""%s" -f "%%1" -o "%%W" || pause"`, exe)
+	return []shellValue{
+		{shellKey, "", "Convert to .gp"},
+		{shellKey, "Icon", exe},
+		{shellKey + `\command`, "", command},
+	}
+}
+
+func runInstallShell(args []string) {
+	cmd := flag.NewFlagSet("install-shell", flag.ExitOnError)
+	cmd.Parse(args)
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.Abs(exe)
+	}
+	if err != nil {
+		fmt.Printf("Error: cannot locate gpx2gp: %v\n", err)
+		os.Exit(1)
+	}
+	if err := installShell(shellValues(exe)); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Added \"Convert to .gp\" to the context menu of .gpx files, running %s.\n", exe)
+	fmt.Println("Run 'gpx2gp uninstall-shell' before moving or deleting gpx2gp.")
+}
+
+func runUninstallShell(args []string) {
+	cmd := flag.NewFlagSet("uninstall-shell", flag.ExitOnError)
+	cmd.Parse(args)
+
+	if err := uninstallShell(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Removed \"Convert to .gp\" from the context menu of .gpx files.")
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+func installShell(values []shellValue) error {
+	return fmt.Errorf("install-shell registers an Explorer context menu entry and is only available on Windows")
+}
+
+func uninstallShell() error {
+	return fmt.Errorf("uninstall-shell is only available on Windows")
+}
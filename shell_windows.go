@@ -0,0 +1,43 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// reg runs reg.exe, which ships with every Windows release
+func reg(args ...string) error {
+	out, err := exec.Command("reg.exe", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("reg %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// installShell writes the values, replacing earlier ones
+func installShell(values []shellValue) error {
+	for _, v := range values {
+		args := []string{"add", v.Key, "/t", "REG_SZ", "/d", v.Data, "/f"}
+		if v.Name == "" {
+			args = append(args, "/ve")
+		} else {
+			args = append(args, "/v", v.Name)
+		}
+		if err := reg(args...); err != nil {
+			uninstallShell()
+			return err
+		}
+	}
+	return nil
+}
+
+// uninstallShell removes the entry with everything below it
+func uninstallShell() error {
+	if err := exec.Command("reg.exe", "query", shellKey).Run(); err != nil {
+		return fmt.Errorf("the context menu entry is not installed")
+	}
+	return reg("delete", shellKey, "/f")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"strings"
+)
+
+// Sidecar is the song.gp.json -sidecar writes next to song.gp, recording
+// how the output was produced for catalog tools and later reconversions
+type Sidecar struct {
+	Input       string            `json:"input"`
+	InputSHA256 string            `json:"input_sha256"`
+	Output      string            `json:"output"`
+	Converter   string            `json:"converter"`
+	Target      string            `json:"target"`  // Guitar Pro release the output was checked against
+	Options     map[string]string `json:"options"` // command line options given, other than input and output
+	Partial     bool              `json:"partial"`
+	Warnings    []string          `json:"warnings"`
+	Files       []SidecarFile     `json:"files"` // entries of the output archive
+}
+
+// SidecarFile is one entry of the output archive
+type SidecarFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// sidecarOptions lists the command line options that were set, leaving out
+// the input and output and those that only change what is printed
+func sidecarOptions() map[string]string {
+	options := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "f", "file", "o", "out", "v", "json", "sidecar":
+			return
+		}
+		options[f.Name] = f.Value.String()
+	})
+	return options
+}
+
+// archiveFiles hashes the entries of a written archive
+func archiveFiles(path string) ([]SidecarFile, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	files := []SidecarFile{}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, SidecarFile{Name: f.Name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+	return files, nil
+}
+
+// writeSidecar completes s from the archive at s.Output and writes it as
+// s.Output + ".json"
+func writeSidecar(s Sidecar) error {
+	files, err := archiveFiles(s.Output)
+	if err != nil {
+		return err
+	}
+	s.Files = files
+	s.Converter = "gpx2gp " + toolVersion()
+	s.Target = target.Target
+	s.Options = sidecarOptions()
+	if s.Warnings == nil {
+		s.Warnings = []string{}
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := s.Output + ".json"
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
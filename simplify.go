@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Simplification writes an easier practice version of a score next to the
+// faithful conversion: ornaments and grace notes are removed, tuplets
+// denser than triplets become plain notes, and only the first voice of
+// every bar is kept. The bars keep their length, so the version plays
+// along with the original.
+
+// ornamentElements are the note and beat elements removed as ornaments
+var ornamentElements = map[string]bool{
+	"Vibrato": true, "Ornament": true, "Trill": true, "AntiAccent": true,
+	"Whammy": true, "Arpeggio": true, "Tremolo": true, "Fadding": true,
+}
+
+// ornamentProperty reports whether a note or beat property is an
+// ornament: bends, slides, hammer-ons and pull-offs, harmonics, taps,
+// whammy bar, brushes and rasgueado
+func ornamentProperty(name string) bool {
+	switch {
+	case strings.HasPrefix(name, "Bend"), name == "Bended",
+		strings.HasPrefix(name, "Hopo"), strings.HasPrefix(name, "Harmonic"),
+		strings.HasPrefix(name, "WhammyBar"), name == "VibratoWTremBar",
+		name == "Slide", name == "Tapped", name == "LeftHandTapped",
+		name == "Brush", name == "BrushIsUp", name == "Rasgueado":
+		return true
+	}
+	return false
+}
+
+// simplifyPlan is what simplifying changes, worked out on the parsed score
+type simplifyPlan struct {
+	voices     map[int]string     // voice id to its new beat list
+	beatCopies map[int][]beatCopy // original beat id to copies with a plain rhythm
+	rhythms    []GpifRhythm       // plain rhythms to add
+	ornaments  int
+	graceNotes int
+	tuplets    int
+	dropped    int // secondary voices
+}
+
+// beatCopy is a beat repeated under a new id with another rhythm
+type beatCopy struct {
+	id, rhythm int
+}
+
+// denseTuplet reports whether a rhythm is a tuplet denser than a triplet,
+// with no nested tuplet
+func denseTuplet(r *GpifRhythm) bool {
+	return r != nil && r.PrimaryTuplet.Num > 3 && r.PrimaryTuplet.Den > 0 && r.SecondaryTuplet.Num == 0
+}
+
+// planSimplify works out the new voices, beats and rhythms
+func (g *Gpif) planSimplify() *simplifyPlan {
+	p := &simplifyPlan{voices: make(map[int]string), beatCopies: make(map[int][]beatCopy)}
+	nextBeat, nextRhythm := 0, 0
+	for _, b := range g.Beats {
+		nextBeat = max(nextBeat, b.ID+1)
+	}
+	for _, r := range g.Rhythms {
+		nextRhythm = max(nextRhythm, r.ID+1)
+	}
+
+	// plainRhythm finds or adds a rhythm of the same value without tuplet
+	plain := make(map[[2]int]int)
+	values := make(map[string]int)
+	plainRhythm := func(r *GpifRhythm) int {
+		if _, ok := values[r.NoteValue]; !ok {
+			values[r.NoteValue] = len(values)
+		}
+		key := [2]int{values[r.NoteValue], r.AugmentationDot.Count}
+		if id, ok := plain[key]; ok {
+			return id
+		}
+		for _, existing := range append(g.Rhythms, p.rhythms...) {
+			if existing.NoteValue == r.NoteValue && existing.AugmentationDot.Count == r.AugmentationDot.Count &&
+				existing.PrimaryTuplet.Num == 0 && existing.SecondaryTuplet.Num == 0 {
+				plain[key] = existing.ID
+				return existing.ID
+			}
+		}
+		id := nextRhythm
+		nextRhythm++
+		p.rhythms = append(p.rhythms, GpifRhythm{ID: id, NoteValue: r.NoteValue, AugmentationDot: r.AugmentationDot})
+		plain[key] = id
+		return id
+	}
+	rhythmOf := func(beat *GpifBeat) *GpifRhythm {
+		id, _ := strconv.Atoi(beat.Rhythm.Ref)
+		return g.rhythmByID[id]
+	}
+
+	for _, v := range g.Voices {
+		var beats []*GpifBeat
+		for _, id := range parseIDs(v.Beats) {
+			beat := g.beatByID[id]
+			if beat == nil {
+				continue
+			}
+			if beat.GraceNotes != "" {
+				p.graceNotes++
+				continue
+			}
+			beats = append(beats, beat)
+		}
+
+		var ids []string
+		for i := 0; i < len(beats); {
+			r := rhythmOf(beats[i])
+			if !denseTuplet(r) {
+				ids = append(ids, strconv.Itoa(beats[i].ID))
+				i++
+				continue
+			}
+			// A run of beats with the same tuplet rhythm, complete groups
+			// of which become den plain beats each, spread over the group
+			end := i + 1
+			for end < len(beats) && rhythmOf(beats[end]) != nil && *rhythmOf(beats[end]) == *r {
+				end++
+			}
+			num, den := r.PrimaryTuplet.Num, r.PrimaryTuplet.Den
+			for ; i+num <= end; i += num {
+				for k := 0; k < den; k++ {
+					beat := beats[i+k*num/den]
+					copyID := nextBeat
+					nextBeat++
+					p.beatCopies[beat.ID] = append(p.beatCopies[beat.ID], beatCopy{id: copyID, rhythm: plainRhythm(r)})
+					ids = append(ids, strconv.Itoa(copyID))
+				}
+				p.tuplets++
+			}
+			for ; i < end; i++ {
+				ids = append(ids, strconv.Itoa(beats[i].ID))
+			}
+		}
+		p.voices[v.ID] = strings.Join(ids, " ")
+	}
+	return p
+}
+
+// simplifyScore applies the plan to a score
+func simplifyScore(gpif []byte) ([]byte, *simplifyPlan, error) {
+	g, err := parseGpif(gpif)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := g.planSimplify()
+
+	voice := -1
+	copying := false                      // inside a beat that is copied
+	captured := make(map[int][]xml.Token) // such beats as written, by id
+	var order []int
+	beat := -1
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		keep := []xml.Token{tok}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "Voice" && inside(stack, "Voices"):
+				voice, _ = strconv.Atoi(attrValue(t, "id"))
+			case t.Name.Local == "Beat" && inside(stack, "Beats"):
+				beat, _ = strconv.Atoi(attrValue(t, "id"))
+				if copying = len(p.beatCopies[beat]) > 0; copying {
+					order = append(order, beat)
+				}
+			case ornamentElements[t.Name.Local] && (inside(stack, "Note") || inside(stack, "Beat")):
+				p.ornaments++
+				return nil
+			case t.Name.Local == "Property" && (inside(stack, "Note", "Properties") || inside(stack, "Beat", "Properties")) && ornamentProperty(attrValue(t, "name")):
+				p.ornaments++
+				return nil
+			}
+		case xml.CharData:
+			switch {
+			case inside(stack, "Bar", "Voices"):
+				ids := strings.Fields(string(t))
+				for i := 1; i < len(ids); i++ {
+					if ids[i] != "-1" {
+						ids[i] = "-1"
+						p.dropped++
+					}
+				}
+				keep = []xml.Token{xml.CharData(strings.Join(ids, " "))}
+			case inside(stack, "Voice", "Beats"):
+				if beats, ok := p.voices[voice]; ok {
+					keep = []xml.Token{xml.CharData(beats)}
+				}
+			}
+		case xml.EndElement:
+			switch {
+			case t.Name.Local == "Beats" && len(stack) == 2:
+				// The copies follow the last beat
+				var copies []xml.Token
+				for _, id := range order {
+					for _, c := range p.beatCopies[id] {
+						copies = append(copies, copyBeat(captured[id], c)...)
+					}
+				}
+				keep = append(copies, tok)
+			case t.Name.Local == "Rhythms" && len(stack) == 2:
+				var added []xml.Token
+				for _, r := range p.rhythms {
+					added = append(added, xmlTokens(plainRhythmXML(r))...)
+				}
+				keep = append(added, tok)
+			}
+		}
+		if copying {
+			captured[beat] = append(captured[beat], keep...)
+			if end, ok := tok.(xml.EndElement); ok && end.Name.Local == "Beat" && len(stack) == 3 {
+				copying = false
+			}
+		}
+		return keep
+	})
+	return out, p, err
+}
+
+// copyBeat repeats a beat's tokens under the copy's id and rhythm
+func copyBeat(tokens []xml.Token, c beatCopy) []xml.Token {
+	copied := make([]xml.Token, len(tokens))
+	for i, tok := range tokens {
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			copied[i] = tok
+			continue
+		}
+		se.Attr = append([]xml.Attr(nil), se.Attr...)
+		for k, a := range se.Attr {
+			switch {
+			case i == 0 && a.Name.Local == "id":
+				se.Attr[k].Value = strconv.Itoa(c.id)
+			case se.Name.Local == "Rhythm" && a.Name.Local == "ref":
+				se.Attr[k].Value = strconv.Itoa(c.rhythm)
+			}
+		}
+		copied[i] = se
+	}
+	return copied
+}
+
+// plainRhythmXML is a Rhythm element without tuplet
+func plainRhythmXML(r GpifRhythm) string {
+	s := fmt.Sprintf(`<Rhythm id="%d"><NoteValue>%s</NoteValue>`, r.ID, r.NoteValue)
+	if r.AugmentationDot.Count > 0 {
+		s += fmt.Sprintf(`<AugmentationDot count="%d"/>`, r.AugmentationDot.Count)
+	}
+	return s + `</Rhythm>`
+}
+
+// simplifiedPath is where the practice version of song.gp goes
+func simplifiedPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, ".gp") + ".simple.gp"
+}
+
+// writeSimplified writes the practice version of a converted score to
+// path, leaving fs as it is
+func writeSimplified(fs *GpxFileSystem, path string) (*simplifyPlan, error) {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return nil, fs.missingScore()
+	}
+	data, p, err := simplifyScore(file.Data)
+	if err != nil {
+		return nil, fmt.Errorf("simplifying score.gpif: %v", err)
+	}
+	simple := *fs
+	simple.Files = append([]GpxFile(nil), fs.Files...)
+	score := simple.File("score.gpif")
+	score.Data, score.FileSize = data, len(data)
+	if err := createGpArchive(path, &simple); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	if err := conformTarget(path); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return p, nil
+}
+
+// summary describes what simplifying removed
+func (p *simplifyPlan) summary() string {
+	return fmt.Sprintf("%s and %s removed, %s collapsed, %s dropped",
+		plural(p.ornaments, "ornament"), plural(p.graceNotes, "grace note"), plural(p.tuplets, "tuplet"), plural(p.dropped, "secondary voice"))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type songbookEntry struct {
+	title  string
+	artist string
+	pages  [][]string
+}
+
+// firstFrettedTrack returns the first track with a tuning, or -1
+func (g *Gpif) firstFrettedTrack() int {
+	for i := range g.Tracks {
+		if len(g.Tracks[i].Tuning()) > 0 && !g.Tracks[i].IsPercussion() {
+			return i
+		}
+	}
+	return -1
+}
+
+func paginate(lines []string) [][]string {
+	var pages [][]string
+	for len(lines) > 0 {
+		n := min(len(lines), pdfLinesPerPage)
+		// Avoid splitting a tab system across pages
+		if n < len(lines) {
+			for cut := n; cut > n/2; cut-- {
+				if lines[cut-1] == "" {
+					n = cut
+					break
+				}
+			}
+		}
+		pages = append(pages, lines[:n])
+		lines = lines[n:]
+	}
+	return pages
+}
+
+func tocLine(index int, title string, page, width int) string {
+	left := fmt.Sprintf("%3d. %s ", index, title)
+	right := fmt.Sprintf(" %d", page)
+	if dots := width - len(left) - len(right); dots > 0 {
+		return left + strings.Repeat(".", dots) + right
+	}
+	return left + right
+}
+
+// newSongbookEntry renders a track of the score as tab pages, by default
+// the first fretted one
+func newSongbookEntry(score *Gpif, inputPath string, track, width int) (songbookEntry, error) {
+	if track < 0 {
+		track = score.firstFrettedTrack()
+	}
+	if track < 0 || track >= len(score.Tracks) || len(score.Tracks[track].Tuning()) == 0 {
+		return songbookEntry{}, fmt.Errorf("no printable fretted track")
+	}
+	var text bytes.Buffer
+	if err := writeShareText(&text, score, track, width, "plain"); err != nil {
+		return songbookEntry{}, err
+	}
+	entry := songbookEntry{title: strings.TrimSpace(score.Score.Title), artist: strings.TrimSpace(score.Score.Artist)}
+	if entry.title == "" {
+		entry.title = inputPath
+	}
+	entry.pages = paginate(strings.Split(strings.TrimRight(text.String(), "\n"), "\n"))
+	return entry, nil
+}
+
+// buildSongbook lays out a contents page and the songs in order
+func buildSongbook(title string, entries []songbookEntry, width int) *PdfDocument {
+	// Contents pages come first, so their count fixes every song's page number
+	tocHeader := []string{title, strings.Repeat("=", len(title)), ""}
+	tocPages := (len(tocHeader) + len(entries) + pdfLinesPerPage - 1) / pdfLinesPerPage
+	toc := append([]string{}, tocHeader...)
+	page := tocPages + 1
+	for i, e := range entries {
+		name := e.title
+		if e.artist != "" {
+			name += " - " + e.artist
+		}
+		toc = append(toc, tocLine(i+1, name, page, width))
+		page += len(e.pages)
+	}
+
+	doc := &PdfDocument{Title: title}
+	for _, p := range paginate(toc) {
+		doc.AddPage(p)
+	}
+	for _, e := range entries {
+		for _, p := range e.pages {
+			doc.AddPage(p)
+		}
+	}
+	return doc
+}
+
+func runSongbook(args []string) {
+	cmd := flag.NewFlagSet("songbook", flag.ExitOnError)
+	var outputPath, title string
+	var trackIndex, width int
+	cmd.StringVar(&outputPath, "o", "", "Output PDF file")
+	cmd.StringVar(&outputPath, "out", "", "Output PDF file")
+	cmd.StringVar(&title, "title", "Songbook", "Title printed on the contents page")
+	cmd.IntVar(&trackIndex, "track", -1, "Track index to print (default: first fretted track)")
+	cmd.IntVar(&width, "width", 80, "Wrap tab lines at this many columns")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if outputPath == "" || cmd.NArg() == 0 {
+		fmt.Println("Usage: gpx2gp songbook -o <book.pdf> [-title T] [-track N] [-width 80] <input.gpx|dir>...")
+		os.Exit(1)
+	}
+	outputPath = withExtension(outputPath, ".pdf")
+	if _, err := os.Stat(outputPath); err == nil {
+		fmt.Printf("Error: Output file '%s' already exists.\n", outputPath)
+		os.Exit(1)
+	}
+
+	inputs, err := collectInputs(cmd.Args())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var entries []songbookEntry
+	for _, inputPath := range inputs {
+		_, score, err := readScore(inputPath)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", inputPath, err)
+			continue
+		}
+		entry, err := newSongbookEntry(score, inputPath, trackIndex, width)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", inputPath, err)
+			continue
+		}
+		entries = append(entries, entry)
+		debug("Added %s (%d pages)", inputPath, len(entry.pages))
+	}
+	if len(entries) == 0 {
+		fmt.Println("Error: no songs could be rendered.")
+		os.Exit(1)
+	}
+	doc := buildSongbook(title, entries, width)
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := doc.Write(out); err != nil {
+		out.Close()
+		os.Remove(outputPath)
+		fmt.Printf("Error writing songbook: %v\n", err)
+		os.Exit(1)
+	}
+	out.Close()
+	fmt.Printf("Wrote %s: %d songs, %d pages.\n", outputPath, len(entries), doc.PageCount())
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Spilling inner files to temporary files, so containers whose files do
+// not fit the memory limit next to the decompressed data still convert on
+// small machines. The score is always kept in memory, it is parsed.
+
+// spillToDisk applies to every container read by the CLI
+var spillToDisk = false
+
+// fits reports whether n more bytes stay within the memory limit
+func (fs *GpxFileSystem) fits(n int) bool {
+	return fs.Limits.MaxMemory <= 0 || fs.reserved+n <= fs.Limits.MaxMemory
+}
+
+// spillFile creates a temporary file in the container's spill directory
+func (fs *GpxFileSystem) spillFile() (*os.File, error) {
+	if fs.spillDir == "" {
+		dir, err := os.MkdirTemp("", "gpx2gp-spill-")
+		if err != nil {
+			return nil, err
+		}
+		fs.spillDir = dir
+	}
+	return os.CreateTemp(fs.spillDir, "file-*")
+}
+
+// decompressToDisk decodes a BCFZ stream into a spill file and maps it, so
+// the sectors are read from the page cache rather than a buffer of the
+// whole declared length. The mapping is released by Close.
+func (fs *GpxFileSystem) decompressToDisk(src *BitReader, expectedLength int) ([]byte, error) {
+	f, err := fs.spillFile()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	debug("Decompressing %d bytes to %s", expectedLength, f.Name())
+	w := bufio.NewWriterSize(f, 64<<10)
+	if err := fs.decompressTo(src, expectedLength, w); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("spilling the decompressed container: %v", err)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, release, err := mapFile(f, int(size))
+	if err != nil {
+		return nil, fmt.Errorf("mapping the decompressed container: %v", err)
+	}
+	if input := fs.release; input != nil {
+		fs.release = func() error {
+			err := release()
+			if inputErr := input(); err == nil {
+				err = inputErr
+			}
+			return err
+		}
+	} else {
+		fs.release = release
+	}
+	return data, nil
+}
+
+// Close removes the files spilled to disk and releases the input and a
+// mapped container, after which file data pointing into them must no
+// longer be used
+func (fs *GpxFileSystem) Close() error {
+	var err error
+	if fs.release != nil {
+		err = fs.release()
+		fs.release = nil
+	}
+	fs.raw = nil
+	if fs.spillDir != "" {
+		if rmErr := os.RemoveAll(fs.spillDir); err == nil {
+			err = rmErr
+		}
+		fs.spillDir = ""
+	}
+	return err
+}
+
+// fileAssembler collects the sectors of an inner file, in memory or in a
+// spill file
+type fileAssembler struct {
+	data  []byte
+	spill *os.File
+	w     *bufio.Writer
+	size  int
+	err   error
+}
+
+func (a *fileAssembler) write(p []byte) {
+	a.size += len(p)
+	if a.w == nil {
+		a.data = append(a.data, p...)
+	} else if a.err == nil {
+		_, a.err = a.w.Write(p)
+	}
+}
+
+// finish returns the file, cut to size bytes
+func (a *fileAssembler) finish(name string, declared, size int) (GpxFile, error) {
+	if a.w == nil {
+		return GpxFile{FileName: name, FileSize: declared, Data: a.data[:size]}, nil
+	}
+	err := a.err
+	if err == nil {
+		err = a.w.Flush()
+	}
+	if err == nil {
+		err = a.spill.Truncate(int64(size))
+	}
+	if closeErr := a.spill.Close(); err == nil {
+		err = closeErr
+	}
+	return GpxFile{FileName: name, FileSize: declared, spill: a.spill.Name(), spillSize: size}, err
+}
+
+// Len is the number of bytes of the file's data
+func (f *GpxFile) Len() int {
+	if f.spill != "" {
+		return f.spillSize
+	}
+	return len(f.Data)
+}
+
+// Open reads the file's data, from memory or from its spill file
+func (f *GpxFile) Open() (io.ReadCloser, error) {
+	if f.spill != "" {
+		return os.Open(f.spill)
+	}
+	return io.NopCloser(bytes.NewReader(f.Data)), nil
+}
+
+// Content returns the file's data, loading it if it was spilled
+func (f *GpxFile) Content() ([]byte, error) {
+	if f.spill != "" {
+		return os.ReadFile(f.spill)
+	}
+	return f.Data, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+// TestDecompressSpills decodes a container that does not fit the memory
+// limit into a mapped spill file, which Close removes
+func TestDecompressSpills(t *testing.T) {
+	if string(selftestGpx[:4]) != "BCFZ" {
+		t.Skip("the fixture is not compressed")
+	}
+	declared := int(binary.LittleEndian.Uint32(selftestGpx[4:]))
+	_, score := fixtureScore(t)
+
+	fs := &GpxFileSystem{Limits: Limits{MaxMemory: len(selftestGpx) + declared - 1}, Spill: true}
+	if err := fs.Load(selftestGpx); err != nil {
+		t.Fatal(err)
+	}
+	if fs.spillDir == "" || fs.release == nil {
+		t.Fatal("the container was decompressed into memory")
+	}
+	if !bytes.Equal(fs.File("score.gpif").Data, score) {
+		t.Error("score.gpif reads differently from the spill file")
+	}
+	dir := fs.spillDir
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s is left behind: %v", dir, err)
+	}
+
+	// Without -spill the limit still fails the conversion
+	fs = &GpxFileSystem{Limits: Limits{MaxMemory: len(selftestGpx) + declared - 1}}
+	if err := fs.Load(selftestGpx); err == nil {
+		t.Error("decompressed over the memory limit")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Splitting writes every section of a score as a file of its own next to
+// the full conversion, for teaching a long piece one section at a time.
+// A section file keeps the section's master bars and what they refer to,
+// and starts with the tempo in effect where the section begins.
+
+// sectionPlan is what a section file keeps of the score
+type sectionPlan struct {
+	first, last int
+	bars        map[int]bool
+	voices      map[int]bool
+	beats       map[int]bool
+	notes       map[int]bool
+	rhythms     map[int]bool
+	automations map[int]int  // by position among the master track's automations, to the new bar
+	toStart     map[int]bool // automations from before the section, moved to its start
+}
+
+// planSection works out what a section file keeps
+func (g *Gpif) planSection(s sectionRange) *sectionPlan {
+	p := &sectionPlan{
+		first: s.First, last: s.Last,
+		bars: make(map[int]bool), voices: make(map[int]bool), beats: make(map[int]bool),
+		notes: make(map[int]bool), rhythms: make(map[int]bool), automations: make(map[int]int), toStart: make(map[int]bool),
+	}
+	for m := s.First; m <= s.Last; m++ {
+		for _, id := range parseIDs(g.MasterBars[m].Bars) {
+			p.bars[id] = true
+			bar := g.barByID[id]
+			if bar == nil {
+				continue
+			}
+			for _, voice := range g.BarVoices(bar) {
+				p.voices[voice.ID] = true
+				for _, beat := range g.VoiceBeats(voice) {
+					p.beats[beat.ID] = true
+					if id, err := strconv.Atoi(beat.Rhythm.Ref); err == nil {
+						p.rhythms[id] = true
+					}
+					for _, id := range parseIDs(beat.Notes) {
+						p.notes[id] = true
+					}
+				}
+			}
+		}
+	}
+
+	// Automations within the section move with it, the last one of each
+	// type before it moves to its start unless one is there already
+	before := make(map[string]int)
+	atStart := make(map[string]bool)
+	for i, a := range g.MasterTrack.Automations {
+		switch {
+		case a.Bar >= s.First && a.Bar <= s.Last:
+			p.automations[i] = a.Bar - s.First
+			if a.Bar == s.First && a.Position == 0 {
+				atStart[a.Type] = true
+			}
+		case a.Bar < s.First:
+			if j, ok := before[a.Type]; !ok || g.MasterTrack.Automations[j].Bar < a.Bar ||
+				(g.MasterTrack.Automations[j].Bar == a.Bar && g.MasterTrack.Automations[j].Position <= a.Position) {
+				before[a.Type] = i
+			}
+		}
+	}
+	for typ, i := range before {
+		if !atStart[typ] {
+			p.automations[i] = 0
+			p.toStart[i] = true
+		}
+	}
+	return p
+}
+
+// sectionScore cuts a section out of a score
+func sectionScore(gpif []byte, p *sectionPlan) ([]byte, error) {
+	masterBar := -1
+	automation := -1
+	var held []xml.Token // the automation being read
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		if held != nil {
+			switch t := tok.(type) {
+			case xml.CharData:
+				bar, kept := p.automations[automation]
+				switch {
+				case kept && inside(stack, "Automation", "Bar"):
+					tok = xml.CharData(strconv.Itoa(bar))
+				case p.toStart[automation] && inside(stack, "Automation", "Position"):
+					tok = xml.CharData("0")
+				}
+			case xml.EndElement:
+				if t.Name.Local == "Automation" && inside(stack, "Automations", "Automation") {
+					toks := append(held, tok)
+					held = nil
+					if _, ok := p.automations[automation]; ok {
+						return toks
+					}
+					return []xml.Token{}
+				}
+			}
+			held = append(held, tok)
+			return []xml.Token{}
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			return []xml.Token{tok}
+		}
+		id, _ := strconv.Atoi(attrValue(se, "id"))
+		switch {
+		case se.Name.Local == "Automation" && inside(stack, "MasterTrack", "Automations"):
+			automation++
+			held = []xml.Token{tok}
+			return []xml.Token{}
+		case se.Name.Local == "MasterBar" && inside(stack, "MasterBars"):
+			masterBar++
+			if masterBar < p.first || masterBar > p.last {
+				return nil
+			}
+		case se.Name.Local == "Bar" && inside(stack, "GPIF", "Bars") && !p.bars[id],
+			se.Name.Local == "Voice" && inside(stack, "GPIF", "Voices") && !p.voices[id],
+			se.Name.Local == "Beat" && inside(stack, "GPIF", "Beats") && !p.beats[id],
+			se.Name.Local == "Note" && inside(stack, "GPIF", "Notes") && !p.notes[id],
+			se.Name.Local == "Rhythm" && inside(stack, "GPIF", "Rhythms") && !p.rhythms[id]:
+			return nil
+		}
+		return []xml.Token{tok}
+	})
+	return out, err
+}
+
+// sectionPath names the file of the nth section, from 1, after the output
+func sectionPath(outputPath string, n int, name string) string {
+	return fmt.Sprintf("%s - %02d %s.gp", strings.TrimSuffix(outputPath, ".gp"), n, safeFileName(name))
+}
+
+// writeSections writes every section of a converted score to a file of
+// its own next to outputPath, leaving fs as it is, and returns their paths
+func writeSections(fs *GpxFileSystem, inputPath, outputPath string) ([]string, error) {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return nil, fs.missingScore()
+	}
+	score, err := parseGpif(file.Data)
+	if err != nil {
+		return nil, err
+	}
+	sections := score.sectionRanges()
+	if len(sections) < 2 {
+		return nil, nil
+	}
+	var paths []string
+	for i, s := range sections {
+		path := sectionPath(outputPath, i+1, s.Name)
+		if err := checkOutputPath(inputPath, path); err != nil {
+			return paths, err
+		}
+		data, err := sectionScore(file.Data, score.planSection(s))
+		if err != nil {
+			return paths, fmt.Errorf("cutting section %s out of score.gpif: %v", s.Name, err)
+		}
+		section := *fs
+		section.Files = append([]GpxFile(nil), fs.Files...)
+		f := section.File("score.gpif")
+		f.Data, f.FileSize = data, len(data)
+		if err := createGpArchive(path, &section); err != nil {
+			os.Remove(path)
+			return paths, err
+		}
+		if err := conformTarget(path); err != nil {
+			os.Remove(path)
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
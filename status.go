@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Exit codes of the converter. Pipelines can quarantine results that
+// exited with exitWarnings.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitWarnings = 2 // converted, but data was recovered, dropped or synthesized
+)
+
+// exitHooks run before the converter exits, in reverse order
+var exitHooks []func()
+
+// atExit registers f to run before the converter exits through finish
+func atExit(f func()) {
+	exitHooks = append(exitHooks, f)
+}
+
+func runExitHooks() {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	exitHooks = nil
+}
+
+// ConversionStatus is the outcome of a conversion, printed by -json
+type ConversionStatus struct {
+	Input    string   `json:"input"`
+	Output   string   `json:"output,omitempty"`
+	Status   string   `json:"status"` // "ok", "warnings", "skipped" or "error"
+	Skipped  bool     `json:"-"`
+	Partial  bool     `json:"partial"`
+	Files    int      `json:"files"`
+	Warnings []string `json:"warnings"`
+	Error    string   `json:"error,omitempty"`
+	Seconds  float64  `json:"seconds"`
+}
+
+// exitCode classifies the conversion and fills in Status
+func (s *ConversionStatus) exitCode() int {
+	switch {
+	case s.Error != "":
+		s.Status = "error"
+		return exitError
+	case s.Partial || len(s.Warnings) > 0:
+		s.Status = "warnings"
+		return exitWarnings
+	case s.Skipped:
+		s.Status = "skipped"
+		return exitOK
+	}
+	s.Status = "ok"
+	return exitOK
+}
+
+// finish prints the summary, as JSON or text, and exits with the status code
+func (s *ConversionStatus) finish(start time.Time, asJSON bool) {
+	elapsed := time.Since(start)
+	runExitHooks()
+	code := s.exitCode()
+	s.Seconds = elapsed.Seconds()
+	if s.Warnings == nil {
+		s.Warnings = []string{}
+	}
+	s.notify()
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(s)
+		os.Exit(code)
+	}
+
+	switch {
+	case s.Error != "":
+		fmt.Printf("Error: %s\n", s.Error)
+	case s.Partial:
+		fmt.Printf("Partial conversion in %v: the input is damaged, the output may be incomplete.\n", elapsed)
+	case len(s.Warnings) > 0:
+		fmt.Printf("Completed with %d warnings in %v.\n", len(s.Warnings), elapsed)
+	case s.Skipped:
+		fmt.Println("Nothing to convert.")
+	default:
+		fmt.Printf("Success! Converted in %v.\n", elapsed)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Where inputs come from and outputs go. Paths with a scheme, as in
+// s3://bucket/key, are handled by the Storage registered for the scheme,
+// everything else is a local file. A new backend implements Storage and
+// registers itself from an init function, the conversion core only sees
+// paths.
+
+// Storage reads and writes whole files of one kind of location
+type Storage interface {
+	// Read returns the file at path and its modification time, which is
+	// zero when unknown. Reads stop past limits.MaxInputSize.
+	Read(path string) ([]byte, time.Time, error)
+	// Exists reports whether a file is stored at path
+	Exists(path string) (bool, error)
+	// Write stores data at path, replacing any file there
+	Write(path string, data []byte) error
+}
+
+// storages are the backends by URL scheme
+var storages = map[string]Storage{}
+
+func registerStorage(scheme string, s Storage) {
+	if _, dup := storages[scheme]; dup {
+		panic("storage registered twice for " + scheme)
+	}
+	storages[scheme] = s
+}
+
+// pathScheme returns the scheme of a URL path, "" for a local path
+func pathScheme(path string) string {
+	scheme, _, ok := strings.Cut(path, "://")
+	if !ok || scheme == "" || strings.ContainsAny(scheme, `/\.`) {
+		return ""
+	}
+	return scheme
+}
+
+// isRemotePath reports whether path is handled by a registered backend
+// rather than read as a local file
+func isRemotePath(path string) bool {
+	return pathScheme(path) != ""
+}
+
+// storageFor returns the backend of path
+func storageFor(path string) (Storage, error) {
+	scheme := pathScheme(path)
+	if scheme == "" {
+		return localStorage{}, nil
+	}
+	if s := storages[scheme]; s != nil {
+		return s, nil
+	}
+	return nil, fmt.Errorf("%s: no storage for %s:// paths", path, scheme)
+}
+
+// remoteFile is an input read from a backend
+type remoteFile struct {
+	data     []byte
+	modified time.Time
+}
+
+// remoteFiles keeps remote inputs, which are read several times during a
+// conversion but downloaded once
+var remoteFiles = make(map[string]*remoteFile)
+
+// readRemote reads path from its backend, within the input size limit
+func readRemote(path string) (*remoteFile, error) {
+	if f := remoteFiles[path]; f != nil {
+		return f, nil
+	}
+	s, err := storageFor(path)
+	if err != nil {
+		return nil, err
+	}
+	data, modified, err := s.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := limits.checkInput(len(data)); err != nil {
+		return nil, err
+	}
+	f := &remoteFile{data: data, modified: modified}
+	remoteFiles[path] = f
+	return f, nil
+}
+
+// putRemote stores the archive write produces at path once it passed the
+// checks of the target release, nothing is stored otherwise
+func putRemote(path string, write func(w io.Writer) error) error {
+	s, err := storageFor(path)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	if err := conformData(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.Write(path, buf.Bytes())
+}
+
+// readLimited reads r up to one byte past the input size limit, enough
+// for the caller to report it exceeded
+func readLimited(r io.Reader) ([]byte, error) {
+	if limits.MaxInputSize > 0 {
+		r = io.LimitReader(r, int64(limits.MaxInputSize)+1)
+	}
+	return io.ReadAll(r)
+}
+
+// localStorage is the file system
+type localStorage struct{}
+
+func (localStorage) Read(path string) ([]byte, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := readLimited(f)
+	return data, info.ModTime(), err
+}
+
+func (localStorage) Exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	return err == nil, nil
+}
+
+func (localStorage) Write(path string, data []byte) error {
+	return os.WriteFile(path, data, 0644)
+}
+
+// httpStorage reads inputs from web servers, it cannot write
+type httpStorage struct {
+	client *http.Client
+}
+
+func init() {
+	s := httpStorage{client: &http.Client{Timeout: 10 * time.Minute}}
+	registerStorage("http", s)
+	registerStorage("https", s)
+}
+
+func (s httpStorage) Read(path string) ([]byte, time.Time, error) {
+	resp, err := s.client.Get(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	if resp.ContentLength > 0 {
+		if err := limits.checkInput(int(resp.ContentLength)); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	data, err := readLimited(resp.Body)
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return data, modified, err
+}
+
+func (s httpStorage) Exists(path string) (bool, error) {
+	resp, err := s.client.Head(path)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (s httpStorage) Write(path string, data []byte) error {
+	return fmt.Errorf("%s: cannot write over http, use a local or s3:// output", path)
+}
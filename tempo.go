@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TempoReport groups the songs of a library by tempo
+type TempoReport struct {
+	Bucket    int          `json:"bucket"` // width of a histogram range in bpm
+	Histogram []TempoRange `json:"histogram"`
+	Songs     []TempoSong  `json:"songs"`
+}
+
+// TempoRange counts the songs whose average tempo is in [Min, Max)
+type TempoRange struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Songs int `json:"songs"`
+}
+
+// TempoSong is the tempo range of one song, in quarter notes per minute
+type TempoSong struct {
+	File    string  `json:"file"`
+	Title   string  `json:"title"`
+	Min     float64 `json:"min"`
+	Average float64 `json:"average"` // over the playing time
+	Max     float64 `json:"max"`
+	Seconds float64 `json:"seconds"`
+}
+
+func (r *TempoReport) add(path string, score *Gpif) {
+	tl := score.BuildTimeline()
+	song := TempoSong{File: path, Title: strings.TrimSpace(score.Score.Title), Min: math.Inf(1)}
+	for _, t := range tl.Tempos {
+		song.Min = min(song.Min, t.BPM)
+		song.Max = max(song.Max, t.BPM)
+	}
+	song.Seconds = tl.Duration()
+	song.Average = tl.Tempos[0].BPM
+	if song.Seconds > 0 {
+		// Quarter notes played over minutes taken weighs every tempo by
+		// how long it lasts
+		song.Average = float64(tl.TotalTicks()) / ticksPerQuarter / (song.Seconds / 60)
+	}
+	song.Average = math.Round(song.Average*10) / 10
+	song.Seconds = math.Round(song.Seconds*100) / 100
+	r.Songs = append(r.Songs, song)
+}
+
+// histogram counts the songs per bucket, from the slowest to the fastest
+// range any song falls in
+func (r *TempoReport) histogram() {
+	if len(r.Songs) == 0 {
+		return
+	}
+	counts := make(map[int]int)
+	low, high := math.MaxInt, 0
+	for _, s := range r.Songs {
+		b := int(s.Average) / r.Bucket
+		counts[b]++
+		low, high = min(low, b), max(high, b)
+	}
+	for b := low; b <= high; b++ {
+		r.Histogram = append(r.Histogram, TempoRange{Min: b * r.Bucket, Max: (b + 1) * r.Bucket, Songs: counts[b]})
+	}
+}
+
+func (r *TempoReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Tempos of %s, by average bpm\n\n", plural(len(r.Songs), "song"))
+	most := 0
+	for _, h := range r.Histogram {
+		most = max(most, h.Songs)
+	}
+	for _, h := range r.Histogram {
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("#", (h.Songs*40+most-1)/most)
+		}
+		fmt.Fprintf(w, "%3d-%-3d  %4d  %s\n", h.Min, h.Max-1, h.Songs, bar)
+	}
+	fmt.Fprintf(w, "\n%6s %6s %6s %6s  %s\n", "min", "avg", "max", "time", "song")
+	for _, s := range r.Songs {
+		name := s.File
+		if s.Title != "" {
+			name = s.Title + " (" + s.File + ")"
+		}
+		fmt.Fprintf(w, "%6s %6s %6s %6s  %s\n", formatBpm(s.Min), formatBpm(s.Average), formatBpm(s.Max), formatClock(s.Seconds), name)
+	}
+}
+
+func runReportTempo(args []string) {
+	cmd := flag.NewFlagSet("report tempo", flag.ExitOnError)
+	var outputPath string
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	asJSON := cmd.Bool("json", false, "Write the report as JSON")
+	bucket := cmd.Int("bucket", 20, "Width of a histogram range in bpm")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if *bucket < 1 {
+		fmt.Println("Error: -bucket must be at least 1.")
+		os.Exit(1)
+	}
+	report := TempoReport{Bucket: *bucket, Histogram: []TempoRange{}, Songs: []TempoSong{}}
+	reportScores(cmd.Args(), report.add)
+	// Slowest first, the order a teacher works through material in
+	sort.SliceStable(report.Songs, func(i, j int) bool { return report.Songs[i].Average < report.Songs[j].Average })
+	report.histogram()
+	writeReport(outputPath, *asJSON, report, report.writeText)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ticksPerQuarter = 960
+
+// Timeline maps the score's master bars, in playback order, to absolute ticks
+type Timeline struct {
+	Bars   []TimedBar
+	Tempos []TempoChange
+}
+
+type TimedBar struct {
+	Index      int // master bar index
+	Occurrence int // how many times this bar has been played before
+	Tick       int
+	Ticks      int
+}
+
+type TempoChange struct {
+	Tick   int
+	BPM    float64
+	Linear bool // the tempo ramps linearly to the next change rather than holding
+}
+
+func noteValueTicks(value string) int {
+	switch value {
+	case "DoubleWhole":
+		return ticksPerQuarter * 8
+	case "Whole":
+		return ticksPerQuarter * 4
+	case "Half":
+		return ticksPerQuarter * 2
+	case "Quarter":
+		return ticksPerQuarter
+	case "Eighth":
+		return ticksPerQuarter / 2
+	case "16th":
+		return ticksPerQuarter / 4
+	case "32nd":
+		return ticksPerQuarter / 8
+	case "64th":
+		return ticksPerQuarter / 16
+	case "128th":
+		return ticksPerQuarter / 32
+	case "256th":
+		return ticksPerQuarter / 64
+	}
+	return ticksPerQuarter
+}
+
+// BeatTicks returns the played duration of a beat, grace notes take no time
+func (g *Gpif) BeatTicks(beat *GpifBeat) int {
+	if beat.GraceNotes != "" {
+		return 0
+	}
+	id, err := strconv.Atoi(beat.Rhythm.Ref)
+	if err != nil {
+		return ticksPerQuarter
+	}
+	rhythm := g.rhythmByID[id]
+	if rhythm == nil {
+		return ticksPerQuarter
+	}
+	ticks := noteValueTicks(rhythm.NoteValue)
+	switch rhythm.AugmentationDot.Count {
+	case 1:
+		ticks = ticks * 3 / 2
+	case 2:
+		ticks = ticks * 7 / 4
+	}
+	for _, t := range []GpifTuplet{rhythm.PrimaryTuplet, rhythm.SecondaryTuplet} {
+		if t.Num > 0 && t.Den > 0 {
+			ticks = ticks * t.Den / t.Num
+		}
+	}
+	return ticks
+}
+
+// TimeSignature parses "num/den", falling back to 4/4
+func (mb *GpifMasterBar) TimeSignature() (int, int) {
+	parts := strings.Split(mb.Time, "/")
+	if len(parts) == 2 {
+		num, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+		den, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err1 == nil && err2 == nil && num > 0 && den > 0 {
+			return num, den
+		}
+	}
+	return 4, 4
+}
+
+func (mb *GpifMasterBar) DurationTicks() int {
+	num, den := mb.TimeSignature()
+	return num * ticksPerQuarter * 4 / den
+}
+
+// PlaybackOrder expands repeats and alternate endings into the sequence of
+// master bar indices as they are played
+func (g *Gpif) PlaybackOrder() []int {
+	var order []int
+	start, pass := 0, 1
+	limit := len(g.MasterBars) * 64
+	for i := 0; i < len(g.MasterBars) && len(order) < limit; {
+		mb := &g.MasterBars[i]
+		if endings := parseIDs(mb.AlternateEndings); len(endings) > 0 && !containsInt(endings, pass) {
+			i = g.advance(i, &start, &pass)
+			continue
+		}
+		order = append(order, i)
+		if mb.Repeat.End && pass < mb.Repeat.Count {
+			pass++
+			i = start
+			continue
+		}
+		if mb.Repeat.End {
+			pass = 1
+			start = i + 1
+		}
+		i = g.advance(i, &start, &pass)
+	}
+	return order
+}
+
+func (g *Gpif) advance(i int, start, pass *int) int {
+	i++
+	if i < len(g.MasterBars) && g.MasterBars[i].Repeat.Start {
+		*start = i
+		*pass = 1
+	}
+	return i
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTempo decodes a tempo automation value "bpm [reference]" into quarter
+// notes per minute. The reference selects the beat unit the bpm refers to.
+func parseTempo(value string) (float64, bool) {
+	parts := strings.Fields(value)
+	if len(parts) == 0 {
+		return 0, false
+	}
+	bpm, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || bpm <= 0 {
+		return 0, false
+	}
+	if len(parts) > 1 {
+		factors := map[string]float64{"1": 0.5, "2": 1, "3": 1.5, "4": 2, "5": 3}
+		if f, ok := factors[parts[1]]; ok {
+			bpm *= f
+		}
+	}
+	return bpm, true
+}
+
+func (g *Gpif) BuildTimeline() *Timeline {
+	tl := &Timeline{}
+
+	tempos := make(map[int][]GpifAutomation)
+	for _, a := range g.MasterTrack.Automations {
+		if a.Type == "Tempo" {
+			tempos[a.Bar] = append(tempos[a.Bar], a)
+		}
+	}
+
+	seen := make(map[int]int)
+	tick := 0
+	for _, index := range g.PlaybackOrder() {
+		mb := &g.MasterBars[index]
+		bar := TimedBar{Index: index, Occurrence: seen[index], Tick: tick, Ticks: mb.DurationTicks()}
+		seen[index]++
+		tl.Bars = append(tl.Bars, bar)
+
+		for _, a := range tempos[index] {
+			if bpm, ok := parseTempo(a.Value); ok {
+				at := tick + int(a.Position*float64(bar.Ticks))
+				tl.Tempos = append(tl.Tempos, TempoChange{Tick: at, BPM: bpm, Linear: a.Linear})
+			}
+		}
+		tick += bar.Ticks
+	}
+
+	sort.SliceStable(tl.Tempos, func(i, j int) bool { return tl.Tempos[i].Tick < tl.Tempos[j].Tick })
+	if len(tl.Tempos) == 0 || tl.Tempos[0].Tick > 0 {
+		tl.Tempos = append([]TempoChange{{Tick: 0, BPM: 120}}, tl.Tempos...)
+	}
+
+	// Repeated bars restate their tempo, keep actual changes only. A ramp
+	// keeps the change it ends on.
+	changes := tl.Tempos[:1]
+	for _, t := range tl.Tempos[1:] {
+		if last := changes[len(changes)-1]; t.BPM != last.BPM || t.Linear != last.Linear || last.Linear {
+			changes = append(changes, t)
+		}
+	}
+	tl.Tempos = changes
+	return tl
+}
+
+func (tl *Timeline) TotalTicks() int {
+	if len(tl.Bars) == 0 {
+		return 0
+	}
+	last := tl.Bars[len(tl.Bars)-1]
+	return last.Tick + last.Ticks
+}
+
+// Seconds converts an absolute tick to wall clock time using the tempo map
+func (tl *Timeline) Seconds(tick int) float64 {
+	seconds := 0.0
+	for i, t := range tl.Tempos {
+		if t.Tick >= tick {
+			break
+		}
+		end := tick
+		if i+1 < len(tl.Tempos) && tl.Tempos[i+1].Tick < tick {
+			end = tl.Tempos[i+1].Tick
+		}
+		if next := i + 1; t.Linear && next < len(tl.Tempos) && tl.Tempos[next].BPM != t.BPM {
+			// The time a tick takes is inverse to the tempo, which grows
+			// linearly: integrate 60/bpm over the ramp up to end
+			span := float64(tl.Tempos[next].Tick - t.Tick)
+			slope := (tl.Tempos[next].BPM - t.BPM) / span
+			at := t.BPM + slope*float64(end-t.Tick)
+			seconds += 60.0 / ticksPerQuarter * math.Log(at/t.BPM) / slope
+			continue
+		}
+		seconds += float64(end-t.Tick) / ticksPerQuarter * 60 / t.BPM
+	}
+	return seconds
+}
+
+// TempoSteps is the tempo map as a MIDI file can hold it: each linear ramp
+// becomes a step a beat, at the tempo that makes the step last as long as
+// Seconds has it
+func (tl *Timeline) TempoSteps() []TempoChange {
+	var steps []TempoChange
+	for i, t := range tl.Tempos {
+		if next := i + 1; !t.Linear || next == len(tl.Tempos) || tl.Tempos[next].BPM == t.BPM {
+			steps = append(steps, TempoChange{Tick: t.Tick, BPM: t.BPM})
+			continue
+		}
+		end := tl.Tempos[i+1].Tick
+		for at := t.Tick; at < end; at += ticksPerQuarter {
+			to := min(at+ticksPerQuarter, end)
+			bpm := float64(to-at) / ticksPerQuarter * 60 / (tl.Seconds(to) - tl.Seconds(at))
+			steps = append(steps, TempoChange{Tick: at, BPM: bpm})
+		}
+	}
+	return steps
+}
+
+// Duration is the playing time of the whole score in seconds, repeats and
+// alternate endings played out and tempo ramps followed
+func (tl *Timeline) Duration() float64 {
+	return tl.Seconds(tl.TotalTicks())
+}
+
+// TimedBeat is a beat placed on the timeline
+type TimedBeat struct {
+	Track int
+	Voice int
+	Bar   TimedBar
+	Beat  *GpifBeat
+	Tick  int
+	Ticks int
+}
+
+// TrackBeats lists every beat of a track in playback order
+func (g *Gpif) TrackBeats(tl *Timeline, track int) []TimedBeat {
+	var beats []TimedBeat
+	for _, tb := range tl.Bars {
+		bar := g.TrackBar(tb.Index, track)
+		if bar == nil {
+			continue
+		}
+		for v, voice := range g.BarVoices(bar) {
+			offset := 0
+			for _, beat := range g.VoiceBeats(voice) {
+				ticks := g.BeatTicks(beat)
+				beats = append(beats, TimedBeat{Track: track, Voice: v, Bar: tb, Beat: beat, Tick: tb.Tick + offset, Ticks: ticks})
+				offset += ticks
+			}
+		}
+	}
+	sort.SliceStable(beats, func(i, j int) bool { return beats[i].Tick < beats[j].Tick })
+	return beats
+}
+
+// NoteEvent is a sounding note with ties already merged into its duration
+type NoteEvent struct {
+	Track    int
+	Tick     int
+	Ticks    int
+	Pitch    int
+	Velocity int
+	Beat     *GpifBeat
+	Note     *GpifNote
+}
+
+func dynamicVelocity(dynamic string) int {
+	switch dynamic {
+	case "PPP":
+		return 16
+	case "PP":
+		return 32
+	case "P":
+		return 48
+	case "MP":
+		return 64
+	case "F":
+		return 96
+	case "FF":
+		return 112
+	case "FFF":
+		return 127
+	}
+	return 80
+}
+
+// graceTicks is the short duration given to grace notes on playback
+const graceTicks = ticksPerQuarter / 8
+
+func (g *Gpif) TrackNotes(tl *Timeline, track int) []NoteEvent {
+	t := &g.Tracks[track]
+	var events []NoteEvent
+	open := make(map[int]int) // pitch -> index of the last event, for ties
+	for _, tb := range g.TrackBeats(tl, track) {
+		for _, note := range g.BeatNotes(tb.Beat) {
+			pitch, ok := note.Pitch(t)
+			if !ok {
+				continue
+			}
+			ticks := tb.Ticks
+			if ticks == 0 {
+				ticks = graceTicks
+			}
+			if note.Tie.Destination {
+				if i, ok := open[pitch]; ok {
+					events[i].Ticks = tb.Tick + ticks - events[i].Tick
+					continue
+				}
+			}
+			open[pitch] = len(events)
+			events = append(events, NoteEvent{
+				Track:    track,
+				Tick:     tb.Tick,
+				Ticks:    ticks,
+				Pitch:    pitch,
+				Velocity: dynamicVelocity(tb.Beat.Dynamic),
+				Beat:     tb.Beat,
+				Note:     note,
+			})
+		}
+	}
+	return events
+}
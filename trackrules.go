@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Track name rules make a library of mixed origin consistent: each rule
+// matches track names with a regular expression and gives the canonical
+// name, and optionally the short name and instrument, of the tracks it
+// matches. The first matching rule applies.
+
+// TrackRule is one rule of a -track-rules file
+type TrackRule struct {
+	Match      string `json:"match"`                // regular expression on the track name
+	Name       string `json:"name"`                 // replacement, may refer to groups as $1
+	ShortName  string `json:"short_name,omitempty"` // replacement short name
+	Program    *int   `json:"program,omitempty"`    // General MIDI program, 0 to 127
+	Instrument string `json:"instrument,omitempty"` // Guitar Pro instrument, e.g. e-gtr6
+	re         *regexp.Regexp
+}
+
+// TrackRulesFile is what -track-rules reads
+type TrackRulesFile struct {
+	Rules []TrackRule `json:"rules"`
+}
+
+// trackRules holds the rules of -track-rules, trackRulesSum the hash of
+// its file, which is part of the cache key
+var (
+	trackRules    []TrackRule
+	trackRulesSum string
+)
+
+// loadTrackRules reads and compiles a rules file
+func loadTrackRules(path string) ([]TrackRule, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var file TrackRulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, "", fmt.Errorf("%s: not a track rules file: %v", path, err)
+	}
+	for i := range file.Rules {
+		r := &file.Rules[i]
+		if r.re, err = regexp.Compile(r.Match); err != nil {
+			return nil, "", fmt.Errorf("%s: rule %d: %v", path, i+1, err)
+		}
+		if r.Program != nil && (*r.Program < 0 || *r.Program > 127) {
+			return nil, "", fmt.Errorf("%s: rule %d: program %d is not between 0 and 127", path, i+1, *r.Program)
+		}
+	}
+	sum := sha256.Sum256(data)
+	return file.Rules, hex.EncodeToString(sum[:]), nil
+}
+
+// trackRuleFor returns the first rule matching a track name and the name
+// it gives, or nil
+func trackRuleFor(rules []TrackRule, name string) (*TrackRule, string) {
+	name = strings.TrimSpace(name)
+	for i := range rules {
+		r := &rules[i]
+		m := r.re.FindStringSubmatchIndex(name)
+		if m == nil {
+			continue
+		}
+		if r.Name == "" {
+			return r, name
+		}
+		return r, string(r.re.ExpandString(nil, r.Name, name, m))
+	}
+	return nil, ""
+}
+
+// applyTrackRules renames the tracks of a score, reporting each change as
+// "old -> new". GPIF gives a track's name before its short name, MIDI
+// program and instrument, so the rule is known by the time they come.
+func applyTrackRules(gpif []byte, rules []TrackRule) ([]byte, []string, error) {
+	var changes []string
+	var rule *TrackRule
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "Track" && inside(stack, "Tracks") {
+				rule = nil
+			}
+			if t.Name.Local == "Instrument" && inside(stack, "Tracks", "Track") && rule != nil && rule.Instrument != "" {
+				for i, a := range t.Attr {
+					if a.Name.Local == "ref" {
+						t.Attr[i].Value = rule.Instrument
+					}
+				}
+				return []xml.Token{t}
+			}
+		case xml.CharData:
+			switch {
+			case inside(stack, "Tracks", "Track", "Name"):
+				var name string
+				rule, name = trackRuleFor(rules, string(t))
+				if rule == nil {
+					break
+				}
+				if old := strings.TrimSpace(string(t)); old != name {
+					changes = append(changes, fmt.Sprintf("%s -> %s", old, name))
+				}
+				return []xml.Token{xml.CharData(name)}
+			case inside(stack, "Tracks", "Track", "ShortName") && rule != nil && rule.ShortName != "":
+				return []xml.Token{xml.CharData(rule.ShortName)}
+			case inside(stack, "Tracks", "Track", "GeneralMidi", "Program") && rule != nil && rule.Program != nil:
+				return []xml.Token{xml.CharData(strconv.Itoa(*rule.Program))}
+			}
+		}
+		return []xml.Token{tok}
+	})
+	return out, changes, err
+}
+
+// renameTracks applies the rules to score.gpif
+func (fs *GpxFileSystem) renameTracks(rules []TrackRule) ([]string, error) {
+	file := fs.File("score.gpif")
+	if file == nil {
+		return nil, fs.missingScore()
+	}
+	data, changes, err := applyTrackRules(file.Data, rules)
+	if err != nil {
+		return nil, fmt.Errorf("renaming tracks in score.gpif: %v", err)
+	}
+	file.Data, file.FileSize = data, len(data)
+	return changes, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Guitar Pro 7 shows a track's tuning by the label stored with it, and
+// tunings without one as Custom. GP6 scores have no labels, so conversion
+// labels every track in a common alternate tuning, such as Drop D or Open
+// G, and lists the names in meta.json. The standard tuning of each
+// instrument needs no label, and a label the score already has is kept.
+
+// standardTunings are the common tunings left unlabelled
+var standardTunings = map[string]bool{
+	"E standard": true, "7-string B standard": true, "8-string F# standard": true,
+	"Bass E standard": true, "5-string bass B standard": true, "6-string bass B standard": true,
+	"Ukulele standard": true,
+}
+
+// genericLabels are labels that say nothing about the tuning
+var genericLabels = map[string]bool{"": true, "custom": true}
+
+// TuningLabel is a track's tuning as named in meta.json
+type TuningLabel struct {
+	Track  string `json:"track"`
+	Tuning string `json:"tuning"`
+}
+
+// applyTuningLabels writes the label of every track in a common
+// alternate tuning into its Tuning property, returning the labels written,
+// and gpif itself when there are none
+func applyTuningLabels(gpif []byte, score *Gpif) ([]byte, []TuningLabel, error) {
+	names := make(map[int]string) // by track id
+	for i := range score.Tracks {
+		t := &score.Tracks[i]
+		if name, ok := knownTuning(t.Tuning()); ok && !standardTunings[name] && !t.IsPercussion() {
+			names[t.ID] = name
+		}
+	}
+	if len(names) == 0 {
+		return gpif, nil, nil
+	}
+
+	var labels []TuningLabel
+	labelled := make(map[int]bool)
+	track := -1
+	tuning := false // inside a track's Tuning property
+	label, visible := false, false
+	text := false // the label had text
+	out, _, err := rewriteXML(gpif, func(stack []string, tok xml.Token) []xml.Token {
+		name := names[track]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "Track" && inside(stack, "Tracks"):
+				track, _ = strconv.Atoi(attrValue(t, "id"))
+			case t.Name.Local == "Property" && track >= 0 && attrValue(t, "name") == "Tuning":
+				tuning, label, visible = true, false, false
+			case t.Name.Local == "Label" && tuning && inside(stack, "Property"):
+				label, text = true, false
+			case t.Name.Local == "LabelVisible" && tuning && inside(stack, "Property"):
+				visible = true
+			}
+		case xml.CharData:
+			if tuning && inside(stack, "Property", "Label") && name != "" {
+				text = true
+				if genericLabels[strings.ToLower(strings.TrimSpace(string(t)))] {
+					labelled[track] = true
+					return []xml.Token{xml.CharData(name)}
+				}
+			}
+		case xml.EndElement:
+			switch {
+			case t.Name.Local == "Label" && tuning && inside(stack, "Property", "Label") && !text && name != "":
+				labelled[track] = true
+				return []xml.Token{xml.CharData(name), tok}
+			case t.Name.Local == "Property" && tuning:
+				tuning = false
+				if name == "" {
+					break
+				}
+				var add []xml.Token
+				if !label {
+					start := xml.StartElement{Name: xml.Name{Local: "Label"}}
+					add = append(add, start, xml.CharData(name), start.End())
+					labelled[track] = true
+				}
+				if !visible {
+					start := xml.StartElement{Name: xml.Name{Local: "LabelVisible"}}
+					add = append(add, start, xml.CharData("true"), start.End())
+				}
+				return append(add, tok)
+			case t.Name.Local == "Track" && len(stack) >= 2 && stack[len(stack)-2] == "Tracks":
+				track = -1
+			}
+		}
+		return []xml.Token{tok}
+	})
+	for i := range score.Tracks {
+		t := &score.Tracks[i]
+		if labelled[t.ID] {
+			labels = append(labels, TuningLabel{Track: strings.TrimSpace(t.Name), Tuning: names[t.ID]})
+		}
+	}
+	if err != nil || len(labels) == 0 {
+		// Rewriting would respell the rest of the document, CDATA included
+		return gpif, labels, err
+	}
+	return out, labels, nil
+}
+
+// labelTunings labels the alternate tunings of score.gpif and records them
+// for meta.json
+func (fs *GpxFileSystem) labelTunings() ([]TuningLabel, error) {
+	score, err := fs.loadScore()
+	if err != nil {
+		return nil, err
+	}
+	file := fs.File("score.gpif")
+	data, labels, err := applyTuningLabels(file.Data, score)
+	if err != nil {
+		return nil, fmt.Errorf("labelling tunings in score.gpif: %v", err)
+	}
+	if len(labels) > 0 {
+		file.Data, file.FileSize = data, len(data)
+	}
+	fs.tunings = labels
+	return labels, nil
+}
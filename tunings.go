@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// namedTuning is a common tuning, string pitches lowest first
+type namedTuning struct {
+	name    string
+	pitches []int
+}
+
+var namedTunings = []namedTuning{
+	{"E standard", []int{40, 45, 50, 55, 59, 64}},
+	{"Drop D", []int{38, 45, 50, 55, 59, 64}},
+	{"Eb standard", []int{39, 44, 49, 54, 58, 63}},
+	{"D standard", []int{38, 43, 48, 53, 57, 62}},
+	{"Drop C#", []int{37, 44, 49, 54, 58, 63}},
+	{"C# standard", []int{37, 42, 47, 52, 56, 61}},
+	{"Drop C", []int{36, 43, 48, 53, 57, 62}},
+	{"C standard", []int{36, 41, 46, 51, 55, 60}},
+	{"Drop B", []int{35, 42, 47, 52, 56, 61}},
+	{"B standard", []int{35, 40, 45, 50, 54, 59}},
+	{"DADGAD", []int{38, 45, 50, 55, 57, 62}},
+	{"Open D", []int{38, 45, 50, 54, 57, 62}},
+	{"Open G", []int{38, 43, 50, 55, 59, 62}},
+	{"Open E", []int{40, 47, 52, 56, 59, 64}},
+	{"Open C", []int{36, 43, 48, 55, 60, 64}},
+	{"7-string B standard", []int{35, 40, 45, 50, 55, 59, 64}},
+	{"7-string Drop A", []int{33, 40, 45, 50, 55, 59, 64}},
+	{"8-string F# standard", []int{30, 35, 40, 45, 50, 55, 59, 64}},
+	{"Bass E standard", []int{28, 33, 38, 43}},
+	{"Bass Drop D", []int{26, 33, 38, 43}},
+	{"Bass Eb standard", []int{27, 32, 37, 42}},
+	{"Bass D standard", []int{26, 31, 36, 41}},
+	{"5-string bass B standard", []int{23, 28, 33, 38, 43}},
+	{"6-string bass B standard", []int{23, 28, 33, 38, 43, 48}},
+	{"Ukulele standard", []int{67, 60, 64, 69}},
+}
+
+// knownTuning returns the name of a common tuning
+func knownTuning(pitches []int) (string, bool) {
+	for _, t := range namedTunings {
+		if slices.Equal(t.pitches, pitches) {
+			return t.name, true
+		}
+	}
+	return "", false
+}
+
+// tuningName names a tuning, spelling its notes from the lowest string
+// when it is not a common one
+func tuningName(pitches []int) string {
+	if name, ok := knownTuning(pitches); ok {
+		return name
+	}
+	names := make([]string, len(pitches))
+	for i, p := range pitches {
+		names[i] = pitchName(p)
+	}
+	return strings.Join(names, " ")
+}
+
+// TuningReport counts the songs of a library by tuning
+type TuningReport struct {
+	Songs   int           `json:"songs"`
+	Tunings []TuningGroup `json:"tunings"`
+}
+
+// TuningGroup lists the songs with tracks in one tuning
+type TuningGroup struct {
+	Name    string      `json:"name"`
+	Pitches []int       `json:"pitches"`
+	Songs   []TuningUse `json:"songs"`
+}
+
+// TuningUse is one song using a tuning, with the tracks that do
+type TuningUse struct {
+	File   string   `json:"file"`
+	Title  string   `json:"title"`
+	Artist string   `json:"artist"`
+	Tracks []string `json:"tracks"`
+	Capo   int      `json:"capo,omitempty"` // highest capo among the tracks
+}
+
+// add counts the stringed tracks of a score by tuning
+func (r *TuningReport) add(path string, score *Gpif) {
+	r.Songs++
+	for i := range score.Tracks {
+		t := &score.Tracks[i]
+		tuning := t.Tuning()
+		if t.IsPercussion() || len(tuning) == 0 {
+			continue
+		}
+		name := tuningName(tuning)
+		k := slices.IndexFunc(r.Tunings, func(g TuningGroup) bool { return g.Name == name })
+		if k < 0 {
+			r.Tunings = append(r.Tunings, TuningGroup{Name: name, Pitches: tuning})
+			k = len(r.Tunings) - 1
+		}
+		group := &r.Tunings[k]
+		if n := len(group.Songs); n == 0 || group.Songs[n-1].File != path {
+			group.Songs = append(group.Songs, TuningUse{
+				File:   path,
+				Title:  strings.TrimSpace(score.Score.Title),
+				Artist: strings.TrimSpace(score.Score.Artist),
+			})
+		}
+		use := &group.Songs[len(group.Songs)-1]
+		use.Tracks = append(use.Tracks, strings.TrimSpace(t.Name))
+		use.Capo = max(use.Capo, t.Capo())
+	}
+}
+
+// sort puts the most used tunings first
+func (r *TuningReport) sort() {
+	sort.SliceStable(r.Tunings, func(i, j int) bool {
+		if len(r.Tunings[i].Songs) != len(r.Tunings[j].Songs) {
+			return len(r.Tunings[i].Songs) > len(r.Tunings[j].Songs)
+		}
+		return r.Tunings[i].Name < r.Tunings[j].Name
+	})
+}
+
+func (r *TuningReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Tunings in %s\n\n", plural(r.Songs, "song"))
+	width := 0
+	for _, g := range r.Tunings {
+		width = max(width, len(g.Name))
+	}
+	for _, g := range r.Tunings {
+		fmt.Fprintf(w, "%-*s  %s\n", width, g.Name, plural(len(g.Songs), "song"))
+	}
+	for _, g := range r.Tunings {
+		fmt.Fprintf(w, "\n%s\n", g.Name)
+		for _, s := range g.Songs {
+			line := s.File
+			if s.Title != "" {
+				line = s.Title + " (" + s.File + ")"
+				if s.Artist != "" {
+					line = s.Artist + " - " + line
+				}
+			}
+			fmt.Fprintf(w, "  %s: %s", line, strings.Join(s.Tracks, ", "))
+			if s.Capo > 0 {
+				fmt.Fprintf(w, ", capo %d", s.Capo)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func runReportTunings(args []string) {
+	cmd := flag.NewFlagSet("report tunings", flag.ExitOnError)
+	var outputPath string
+	cmd.StringVar(&outputPath, "o", "", "Output file (default: stdout)")
+	cmd.StringVar(&outputPath, "out", "", "Output file (default: stdout)")
+	asJSON := cmd.Bool("json", false, "Write the report as JSON")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	report := TuningReport{Tunings: []TuningGroup{}}
+	reportScores(cmd.Args(), report.add)
+	report.sort()
+	writeReport(outputPath, *asJSON, report, report.writeText)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Watching a folder, by default Downloads, and converting .gpx files as
+// they arrive. Files already there when watching starts are left alone.
+// A file is converted once its size stayed the same for one interval, so
+// downloads in progress are not picked up half written.
+
+// watchedFile is what the last poll saw of a file
+type watchedFile struct {
+	size     int64
+	modified time.Time
+	done     bool // converted, or failed, in this state
+}
+
+// desktopNotify shows a notification with the system's tools, where there
+// are any; failures only show up in verbose output
+func desktopNotify(title, message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("notify-send", "--app-name=gpx2gp", title, message)
+	case "darwin":
+		// Passed as arguments, quoting them into the script would need
+		// AppleScript's escaping rather than Go's
+		cmd = exec.Command("osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run", title, message)
+	default:
+		return
+	}
+	if err := cmd.Run(); err != nil {
+		debug("Notification failed: %v", err)
+	}
+}
+
+// defaultWatchDir is the user's Downloads folder
+func defaultWatchDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, "Downloads")
+}
+
+// pollWatched lists the .gpx files of dir with their size and time
+func pollWatched(dir string) (map[string]watchedFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]watchedFile)
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".gpx") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files[e.Name()] = watchedFile{size: info.Size(), modified: info.ModTime()}
+	}
+	return files, nil
+}
+
+func runWatch(args []string) {
+	cmd := flag.NewFlagSet("watch", flag.ExitOnError)
+	outputDir := cmd.String("o", "", "Folder for the converted files (default: the watched folder)")
+	interval := cmd.Duration("interval", 2*time.Second, "Time between looks at the folder")
+	notify := cmd.Bool("notify", true, "Show desktop notifications")
+	lenient := cmd.Bool("lenient", false, "Recover what is possible from damaged files, reporting warnings")
+	cmd.StringVar(&webhookURL, "webhook", "", "POST the status of every conversion as JSON to this URL")
+	cmd.BoolVar(&verbose, "v", false, "Verbose output")
+	cmd.Parse(args)
+
+	if cmd.NArg() > 1 || *interval <= 0 {
+		fmt.Println("Usage: gpx2gp watch [-o <output dir>] [-interval 2s] [-notify=false] [-lenient] [-webhook URL] [folder]")
+		os.Exit(1)
+	}
+	if webhookURL != "" {
+		if err := checkWebhookURL(webhookURL); err != nil {
+			fmt.Printf("Error: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+	if *lenient {
+		parseMode = ParseLenient
+	}
+	dir := defaultWatchDir()
+	if cmd.NArg() == 1 {
+		dir = cmd.Arg(0)
+	}
+	if *outputDir == "" {
+		*outputDir = dir
+	}
+	if info, err := os.Stat(*outputDir); err != nil || !info.IsDir() {
+		fmt.Printf("Error: output folder '%s' does not exist.\n", *outputDir)
+		os.Exit(1)
+	}
+
+	seen, err := pollWatched(dir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	for name, f := range seen {
+		f.done = true
+		seen[name] = f
+	}
+	fmt.Printf("Watching %s for .gpx files, converting to %s. Press Ctrl+C to stop.\n", dir, *outputDir)
+
+	for {
+		time.Sleep(*interval)
+		current, err := pollWatched(dir)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		for name, f := range current {
+			prev, known := seen[name]
+			unchanged := known && prev.size == f.size && prev.modified.Equal(f.modified)
+			if !unchanged {
+				// New or still being written, look again next time
+				seen[name] = f
+				continue
+			}
+			if prev.done {
+				continue
+			}
+			inputPath := filepath.Join(dir, name)
+			outputPath := namedOutputPath("", inputPath, *outputDir)
+			status := convertTo(inputPath, outputPath)
+			prev.done = true
+			seen[name] = prev
+
+			switch status.Status {
+			case "error":
+				fmt.Printf("Error: %s: %s\n", name, status.Error)
+				if *notify {
+					desktopNotify("Conversion failed", name+": "+status.Error)
+				}
+			case "warnings":
+				fmt.Printf("Converted %s to %s with %d warnings:\n", name, outputPath, len(status.Warnings))
+				for _, w := range status.Warnings {
+					fmt.Printf("  Warning: %s\n", w)
+				}
+				if *notify {
+					desktopNotify("Converted with warnings", fmt.Sprintf("%s: %d warnings", filepath.Base(outputPath), len(status.Warnings)))
+				}
+			default:
+				fmt.Printf("Converted %s to %s\n", name, outputPath)
+				if *notify {
+					desktopNotify("Converted", filepath.Base(outputPath))
+				}
+			}
+		}
+		for name := range seen {
+			if _, ok := current[name]; !ok {
+				delete(seen, name)
+			}
+		}
+	}
+}
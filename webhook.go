@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Webhook notifications: with -webhook, the status of every finished
+// conversion is POSTed as JSON, the same document -json prints, so chat
+// bots and workflow engines can react without polling the output tree.
+// A failed notification is reported but does not fail the conversion.
+
+// webhookURL receives the notifications, "" disables them
+var webhookURL string
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// checkWebhookURL rejects webhook URLs that cannot be posted to
+func checkWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL '%s' is not an http or https URL", raw)
+	}
+	return nil
+}
+
+// notifyWebhook posts s, which must be finished, to webhookURL
+func notifyWebhook(s *ConversionStatus) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gpx2gp/"+toolVersion())
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", webhookURL, resp.Status)
+	}
+	return nil
+}
+
+// notify sends s to the webhook if one is configured, reporting failures
+// on stderr so they do not mix with -json output
+func (s *ConversionStatus) notify() {
+	if webhookURL == "" {
+		return
+	}
+	if err := notifyWebhook(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: webhook notification failed: %v\n", err)
+	}
+}